@@ -0,0 +1,129 @@
+// Package accounting turns validated shares into miner credits
+//
+// Two payout schemes are implemented:
+//
+//	PPLNS: the reward of a block is split between the last shares, up to a window measured in difficulty
+//	PPS:   each share is credited when submitted, from its expected value at the network difficulty
+//
+// Amounts are in atomic units, the pool fee is in basis points
+package accounting
+
+import (
+	"errors"
+	"math/big"
+	"time"
+)
+
+var (
+	ErrInvalidFee       = errors.New("accounting: fee must be at most 10000 basis points")
+	ErrInvalidWindow    = errors.New("accounting: window must be positive")
+	ErrInvalidShare     = errors.New("accounting: share without miner or difficulty")
+	ErrNoNetwork        = errors.New("accounting: network difficulty and reward not set")
+	ErrCorruptedStorage = errors.New("accounting: corrupted storage")
+	ErrDuplicateReport  = errors.New("accounting: block height already reported")
+)
+
+// MAX_FEE is a fee of 100%
+const MAX_FEE = 10000
+
+// Share is a validated share, credited to the miner address
+type Share struct {
+	Miner      string    `json:"miner"`
+	Difficulty uint64    `json:"difficulty"`
+	Time       time.Time `json:"time"`
+}
+
+// Block is a block found by the pool
+type Block struct {
+	Height uint64    `json:"height"`
+	Reward uint64    `json:"reward"`
+	Time   time.Time `json:"time"`
+}
+
+// Report lists the credits of a block round
+type Report struct {
+	Height uint64 `json:"height"`
+	// Credits are the amounts credited by miner address
+	Credits map[string]uint64 `json:"credits"`
+	// Fee is the part of the reward kept by the pool, with the rounding dust
+	Fee uint64 `json:"fee"`
+}
+
+// Total returns the sum of the credits
+func (r *Report) Total() uint64 {
+	var total uint64
+	for _, credit := range r.Credits {
+		total += credit
+	}
+	return total
+}
+
+// Storage persists the state of the accounting
+// Implementations must be safe for concurrent use
+type Storage interface {
+	// AddShare appends a share to the log
+	AddShare(share Share) error
+	// Shares returns the logged shares, the oldest first
+	Shares() ([]Share, error)
+	// TrimShares drops the n oldest shares of the log
+	TrimShares(n int) error
+
+	// Credit adds the amounts to the balances of the miners and to the current round
+	Credit(credits map[string]uint64) error
+	// Balances returns the balance of every miner
+	Balances() (map[string]uint64, error)
+	// Round returns the amounts credited with Credit since the last report
+	Round() (map[string]uint64, error)
+
+	// AddReport records the report of a round and starts a new round
+	// A report for a height already recorded returns ErrDuplicateReport
+	AddReport(report Report) error
+	// CreditReport is AddReport also crediting the amounts of the report,
+	// both are written at once so a retry can't credit them twice
+	CreditReport(report Report) error
+	// Reports returns the recorded reports, the oldest first
+	Reports() ([]Report, error)
+}
+
+// Scheme is a payout scheme
+type Scheme interface {
+	// AddShare records a validated share
+	AddShare(share Share) error
+	// BlockFound closes the round and returns its credits
+	BlockFound(block Block) (Report, error)
+}
+
+// reported returns the report recorded for the height, if any
+func reported(storage Storage, height uint64) (Report, bool, error) {
+	reports, err := storage.Reports()
+	if err != nil {
+		return Report{}, false, err
+	}
+	for i := len(reports) - 1; i >= 0; i-- {
+		if reports[i].Height == height {
+			return reports[i], true, nil
+		}
+	}
+	return Report{}, false, nil
+}
+
+func validShare(share *Share) error {
+	if share.Miner == "" || share.Difficulty == 0 {
+		return ErrInvalidShare
+	}
+	return nil
+}
+
+// mulDiv returns a * b / c without overflow, c must not be zero and the result must fit 64 bits
+func mulDiv(a uint64, b uint64, c *big.Int) uint64 {
+	var n big.Int
+	n.SetUint64(a)
+	n.Mul(&n, new(big.Int).SetUint64(b))
+	n.Quo(&n, c)
+	return n.Uint64()
+}
+
+// withoutFee returns the amount left after the fee in basis points
+func withoutFee(amount uint64, fee uint64) uint64 {
+	return mulDiv(amount, MAX_FEE-fee, big.NewInt(MAX_FEE))
+}
@@ -0,0 +1,308 @@
+package accounting
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var epoch = time.Unix(1736271107, 0).UTC()
+
+func TestPPLNSWindow(t *testing.T) {
+	storage := NewMemoryStorage()
+	pplns, err := NewPPLNS(storage, 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, share := range []Share{
+		{Miner: "a", Difficulty: 40},
+		{Miner: "b", Difficulty: 30},
+		{Miner: "a", Difficulty: 50},
+		{Miner: "c", Difficulty: 40},
+	} {
+		if err := pplns.AddShare(share); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := pplns.BlockFound(Block{Height: 7, Reward: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the window covers c 40, a 50 and 10 of the 30 of b, the first share of a is out
+	expected := Report{Height: 7, Credits: map[string]uint64{"a": 495, "b": 99, "c": 396}, Fee: 10}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("incorrect report %+v", report)
+	}
+
+	shares, _ := storage.Shares()
+	if len(shares) != 3 || shares[0].Miner != "b" {
+		t.Fatalf("the shares out of the window must be trimmed, %d left", len(shares))
+	}
+
+	// the shares of the window are paid again by the next block
+	report, err = pplns.BlockFound(Block{Height: 8, Reward: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Credits["a"] != 495 {
+		t.Fatalf("incorrect second report %+v", report)
+	}
+
+	balances, _ := storage.Balances()
+	if balances["a"] != 990 || balances["b"] != 198 || balances["c"] != 792 {
+		t.Fatalf("incorrect balances %v", balances)
+	}
+	if reports, _ := storage.Reports(); len(reports) != 2 {
+		t.Fatalf("expected 2 reports, got %d", len(reports))
+	}
+}
+
+// trimFailure fails the next trim, after the block got credited
+type trimFailure struct {
+	*MemoryStorage
+	fail bool
+}
+
+var errTrim = errors.New("trim failed")
+
+func (s *trimFailure) TrimShares(n int) error {
+	if s.fail {
+		s.fail = false
+		return errTrim
+	}
+	return s.MemoryStorage.TrimShares(n)
+}
+
+func TestPPLNSRetry(t *testing.T) {
+	storage := &trimFailure{MemoryStorage: NewMemoryStorage(), fail: true}
+	pplns, err := NewPPLNS(storage, 50, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, share := range []Share{{Miner: "a", Difficulty: 50}, {Miner: "b", Difficulty: 50}} {
+		if err := pplns.AddShare(share); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := pplns.BlockFound(Block{Height: 5, Reward: 100}); err != errTrim {
+		t.Fatalf("expected %v, got %v", errTrim, err)
+	}
+	// a share arriving before the retry doesn't change the recorded round
+	if err := pplns.AddShare(Share{Miner: "c", Difficulty: 50}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the retry returns the report of the first attempt without crediting it again
+	report, err := pplns.BlockFound(Block{Height: 5, Reward: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Report{Height: 5, Credits: map[string]uint64{"b": 100}}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("incorrect report %+v", report)
+	}
+	if balances, _ := storage.Balances(); !reflect.DeepEqual(balances, expected.Credits) {
+		t.Fatalf("the block must be credited once, got %v", balances)
+	}
+	if reports, _ := storage.Reports(); len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(reports))
+	}
+	if err := storage.CreditReport(report); err != ErrDuplicateReport {
+		t.Fatalf("expected %v, got %v", ErrDuplicateReport, err)
+	}
+}
+
+func TestPPLNSNoShares(t *testing.T) {
+	pplns, err := NewPPLNS(NewMemoryStorage(), 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := pplns.BlockFound(Block{Height: 1, Reward: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Credits) != 0 || report.Fee != 1000 {
+		t.Fatalf("the pool keeps the reward without shares, got %+v", report)
+	}
+}
+
+// TestPPLNSRounds simulates miners of different hashrates over many block rounds
+func TestPPLNSRounds(t *testing.T) {
+	const NETWORK_DIFFICULTY = 100000
+	const REWARD = 1_000_000_000
+
+	storage := NewMemoryStorage()
+	pplns, err := NewPPLNS(storage, 2*NETWORK_DIFFICULTY, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hashrates in difficulty per second, each miner has its own share difficulty
+	miners := []struct {
+		name       string
+		hashrate   float64
+		difficulty uint64
+	}{
+		{"small", 100, 60},
+		{"medium", 400, 240},
+		{"large", 1500, 1000},
+	}
+	var total_hashrate float64
+	for _, miner := range miners {
+		total_hashrate += miner.hashrate
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	now := epoch
+	var rewards, fees uint64
+	for round := 0; round < 200; round++ {
+		// every second, each miner sends its shares and the pool finds a block with the probability of its hashrate
+		for {
+			now = now.Add(time.Second)
+			for _, miner := range miners {
+				for n := rng.Float64() * miner.hashrate; n > 0; n -= float64(miner.difficulty) {
+					if rng.Float64()*float64(miner.difficulty) > n {
+						break
+					}
+					if err := pplns.AddShare(Share{Miner: miner.name, Difficulty: miner.difficulty, Time: now}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if rng.Float64() < total_hashrate/NETWORK_DIFFICULTY {
+				break
+			}
+		}
+
+		report, err := pplns.BlockFound(Block{Height: uint64(round), Reward: REWARD, Time: now})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Total()+report.Fee != REWARD {
+			t.Fatalf("round %d: credits and fee must add up to the reward", round)
+		}
+		rewards += REWARD
+		fees += report.Fee
+	}
+
+	// the fee is 2%, plus the rounding dust
+	if fees < rewards/50 || fees > rewards/50+200*3 {
+		t.Fatalf("incorrect fees %d for %d rewarded", fees, rewards)
+	}
+
+	balances, _ := storage.Balances()
+	for _, miner := range miners {
+		expected := miner.hashrate / total_hashrate
+		got := float64(balances[miner.name]) / float64(rewards-fees)
+		if got < expected*0.9 || got > expected*1.1 {
+			t.Errorf("%s: got %.3f of the rewards, expected %.3f", miner.name, got, expected)
+		}
+	}
+
+	// the log never grows past the window
+	shares, _ := storage.Shares()
+	var window uint64
+	for _, share := range shares[1:] {
+		window += share.Difficulty
+	}
+	if window >= 2*NETWORK_DIFFICULTY {
+		t.Fatalf("%d shares kept, more than the window", len(shares))
+	}
+}
+
+func TestPPS(t *testing.T) {
+	storage := NewMemoryStorage()
+	pps, err := NewPPS(storage, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pps.AddShare(Share{Miner: "a", Difficulty: 10, Time: epoch}); err != ErrNoNetwork {
+		t.Fatalf("expected %v, got %v", ErrNoNetwork, err)
+	}
+	if err := pps.SetNetwork(1000, 20000); err != nil {
+		t.Fatal(err)
+	}
+
+	// 5% fee: a share of difficulty 10 is worth 20000 * 0.95 * 10 / 1000
+	for i := 0; i < 3; i++ {
+		if err := pps.AddShare(Share{Miner: "a", Difficulty: 10, Time: epoch}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pps.AddShare(Share{Miner: "b", Difficulty: 50, Time: epoch}); err != nil {
+		t.Fatal(err)
+	}
+
+	balances, _ := storage.Balances()
+	if balances["a"] != 570 || balances["b"] != 950 {
+		t.Fatalf("the shares must be credited when submitted, got %v", balances)
+	}
+
+	report, err := pps.BlockFound(Block{Height: 3, Reward: 20000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Report{Height: 3, Credits: map[string]uint64{"a": 570, "b": 950}, Fee: 20000 - 1520}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("incorrect report %+v", report)
+	}
+
+	// the next round starts empty, and the pool takes the loss of unlucky rounds
+	if err := pps.SetNetwork(1000, 20000); err != nil {
+		t.Fatal(err)
+	}
+	if err := pps.AddShare(Share{Miner: "a", Difficulty: 2000, Time: epoch}); err != nil {
+		t.Fatal(err)
+	}
+	report, err = pps.BlockFound(Block{Height: 4, Reward: 20000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Credits) != 1 || report.Credits["a"] != 38000 || report.Fee != 0 {
+		t.Fatalf("incorrect report %+v", report)
+	}
+
+	// a retry returns the same report and leaves the next round alone
+	if err := pps.AddShare(Share{Miner: "b", Difficulty: 10, Time: epoch}); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := pps.BlockFound(Block{Height: 4, Reward: 20000}); err != nil || !reflect.DeepEqual(again, report) {
+		t.Fatalf("got %+v, %v, expected the recorded report", again, err)
+	}
+	if round, _ := storage.Round(); round["b"] != 190 {
+		t.Fatalf("incorrect round %v", round)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := NewPPLNS(NewMemoryStorage(), 0, 0); err != ErrInvalidWindow {
+		t.Fatalf("expected %v, got %v", ErrInvalidWindow, err)
+	}
+	if _, err := NewPPLNS(NewMemoryStorage(), 1, MAX_FEE+1); err != ErrInvalidFee {
+		t.Fatalf("expected %v, got %v", ErrInvalidFee, err)
+	}
+	if _, err := NewPPS(NewMemoryStorage(), MAX_FEE+1); err != ErrInvalidFee {
+		t.Fatalf("expected %v, got %v", ErrInvalidFee, err)
+	}
+
+	pps, _ := NewPPS(NewMemoryStorage(), 0)
+	if err := pps.SetNetwork(0, 1); err != ErrNoNetwork {
+		t.Fatalf("expected %v, got %v", ErrNoNetwork, err)
+	}
+
+	var scheme Scheme
+	scheme, _ = NewPPLNS(NewMemoryStorage(), 1, 0)
+	for _, share := range []Share{{Difficulty: 1}, {Miner: "a"}} {
+		if err := scheme.AddShare(share); err != ErrInvalidShare {
+			t.Fatalf("expected %v, got %v", ErrInvalidShare, err)
+		}
+	}
+}
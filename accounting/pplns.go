@@ -0,0 +1,102 @@
+package accounting
+
+import (
+	"math/big"
+	"sync"
+)
+
+// PPLNS splits the reward of each block between the last shares
+// The window is measured in difficulty, so it covers the same amount of work whatever the share difficulties,
+// and the oldest share of the window only counts for its part inside the window
+// Shares older than the window are trimmed from the storage when a block is found
+type PPLNS struct {
+	storage Storage
+	window  uint64
+	fee     uint64
+
+	mu sync.Mutex
+}
+
+// NewPPLNS returns a PPLNS scheme with the window in difficulty and the fee in basis points
+// A window of about twice the network difficulty is usual
+func NewPPLNS(storage Storage, window uint64, fee uint64) (*PPLNS, error) {
+	if window == 0 {
+		return nil, ErrInvalidWindow
+	}
+	if fee > MAX_FEE {
+		return nil, ErrInvalidFee
+	}
+
+	return &PPLNS{storage: storage, window: window, fee: fee}, nil
+}
+
+func (p *PPLNS) AddShare(share Share) error {
+	if err := validShare(&share); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.storage.AddShare(share)
+}
+
+// BlockFound credits the miners of the shares in the window
+// A block whose height is already reported isn't credited again, its recorded report is returned,
+// so BlockFound can be retried after an error
+func (p *PPLNS) BlockFound(block Block) (Report, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if report, ok, err := reported(p.storage, block.Height); err != nil || ok {
+		return report, err
+	}
+
+	shares, err := p.storage.Shares()
+	if err != nil {
+		return Report{}, err
+	}
+
+	// walk back from the newest share until the window is filled
+	weights := make(map[string]uint64)
+	var total uint64
+	first := len(shares)
+	for first > 0 && total < p.window {
+		first--
+		share := &shares[first]
+
+		weight := share.Difficulty
+		if left := p.window - total; weight > left {
+			weight = left
+		}
+		weights[share.Miner] += weight
+		total += weight
+	}
+
+	report := Report{Height: block.Height, Credits: make(map[string]uint64, len(weights))}
+	if total == 0 {
+		// no share at all, the pool keeps the reward
+		report.Fee = block.Reward
+	} else {
+		reward := withoutFee(block.Reward, p.fee)
+		divisor := new(big.Int).SetUint64(total)
+		for miner, weight := range weights {
+			report.Credits[miner] = mulDiv(reward, weight, divisor)
+		}
+		report.Fee = block.Reward - report.Total()
+	}
+
+	if err := p.storage.CreditReport(report); err != nil {
+		return Report{}, err
+	}
+
+	// the shares before the window will never be paid again
+	// A failed trim is harmless, the window of the next block leaves them out too
+	if first > 0 {
+		if err := p.storage.TrimShares(first); err != nil {
+			return Report{}, err
+		}
+	}
+
+	return report, nil
+}
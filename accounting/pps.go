@@ -0,0 +1,86 @@
+package accounting
+
+import (
+	"math/big"
+	"sync"
+)
+
+// PPS credits each share with its expected value: the block reward times the share difficulty
+// over the network difficulty, minus the fee
+// The pool takes the variance, the report of a block lists the credits of the shares since the previous block:
+// the round of the storage, so it survives a restart
+type PPS struct {
+	storage Storage
+	fee     uint64
+
+	mu sync.Mutex
+	// network is nil until SetNetwork is called
+	network *big.Int
+	reward  uint64
+}
+
+// NewPPS returns a PPS scheme with the fee in basis points
+// SetNetwork must be called before adding shares
+func NewPPS(storage Storage, fee uint64) (*PPS, error) {
+	if fee > MAX_FEE {
+		return nil, ErrInvalidFee
+	}
+
+	return &PPS{storage: storage, fee: fee}, nil
+}
+
+// SetNetwork updates the network difficulty and the block reward the shares are valued with
+func (p *PPS) SetNetwork(difficulty uint64, reward uint64) error {
+	if difficulty == 0 {
+		return ErrNoNetwork
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.network = new(big.Int).SetUint64(difficulty)
+	p.reward = reward
+	return nil
+}
+
+func (p *PPS) AddShare(share Share) error {
+	if err := validShare(&share); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.network == nil {
+		return ErrNoNetwork
+	}
+
+	credit := mulDiv(withoutFee(p.reward, p.fee), share.Difficulty, p.network)
+	return p.storage.Credit(map[string]uint64{share.Miner: credit})
+}
+
+// BlockFound closes the round
+// The Fee of the report is what the pool earned on the round, 0 if the shares cost more than the reward
+// A block whose height is already reported returns its recorded report and leaves the round open
+func (p *PPS) BlockFound(block Block) (Report, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if report, ok, err := reported(p.storage, block.Height); err != nil || ok {
+		return report, err
+	}
+
+	round, err := p.storage.Round()
+	if err != nil {
+		return Report{}, err
+	}
+	report := Report{Height: block.Height, Credits: round}
+	if total := report.Total(); total < block.Reward {
+		report.Fee = block.Reward - total
+	}
+
+	if err := p.storage.AddReport(report); err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
@@ -0,0 +1,527 @@
+package accounting
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// MemoryStorage keeps everything in memory, for tests and short-lived pools
+type MemoryStorage struct {
+	mu       sync.Mutex
+	shares   []Share
+	balances map[string]uint64
+	round    map[string]uint64
+	reports  []Report
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{balances: make(map[string]uint64), round: make(map[string]uint64)}
+}
+
+func (m *MemoryStorage) AddShare(share Share) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.shares = append(m.shares, share)
+	return nil
+}
+
+func (m *MemoryStorage) Shares() ([]Share, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Share(nil), m.shares...), nil
+}
+
+func (m *MemoryStorage) TrimShares(n int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n > len(m.shares) {
+		n = len(m.shares)
+	}
+	m.shares = append([]Share(nil), m.shares[n:]...)
+	return nil
+}
+
+func (m *MemoryStorage) Credit(credits map[string]uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for miner, credit := range credits {
+		m.balances[miner] += credit
+		m.round[miner] += credit
+	}
+	return nil
+}
+
+func (m *MemoryStorage) Balances() (map[string]uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return copyAmounts(m.balances), nil
+}
+
+func (m *MemoryStorage) Round() (map[string]uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return copyAmounts(m.round), nil
+}
+
+func copyAmounts(amounts map[string]uint64) map[string]uint64 {
+	copied := make(map[string]uint64, len(amounts))
+	for miner, amount := range amounts {
+		copied[miner] = amount
+	}
+	return copied
+}
+
+func (m *MemoryStorage) AddReport(report Report) error {
+	return m.addReport(report, false)
+}
+
+func (m *MemoryStorage) CreditReport(report Report) error {
+	return m.addReport(report, true)
+}
+
+func (m *MemoryStorage) addReport(report Report, credit bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.reportedLocked(report.Height) {
+		return ErrDuplicateReport
+	}
+	if credit {
+		for miner, amount := range report.Credits {
+			m.balances[miner] += amount
+		}
+	}
+	m.reports = append(m.reports, report)
+	m.round = make(map[string]uint64)
+	return nil
+}
+
+func (m *MemoryStorage) reported(height uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.reportedLocked(height)
+}
+
+func (m *MemoryStorage) reportedLocked(height uint64) bool {
+	for i := range m.reports {
+		if m.reports[i].Height == height {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStorage) Reports() ([]Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Report(nil), m.reports...), nil
+}
+
+const (
+	SHARES_FILE   = "shares.jsonl"
+	BALANCES_FILE = "balances.json"
+	CREDITS_FILE  = "credits.jsonl"
+	REPORTS_FILE  = "reports.jsonl"
+)
+
+// COMPACT_CREDITS is the number of credits and reports after which the balances file is rewritten
+const COMPACT_CREDITS = 1024
+
+// FileStorage persists the accounting in a directory:
+//
+//	shares.jsonl   one share per line, appended
+//	balances.json  the balances and the round up to a sequence number, replaced atomically
+//	credits.jsonl  one credit per line with its sequence number, appended
+//	reports.jsonl  one report per line with its sequence number, appended
+//
+// The credits are journaled so PPS doesn't rewrite the balances on every share,
+// the journal is folded into balances.json every COMPACT_CREDITS records and on Close.
+// Credits and reports share the sequence numbers, the ones after balances.json are replayed
+// in order when opened, so a crash during the compaction doesn't credit them twice
+// and a report credits its amounts in the same write as it is recorded.
+// The state is loaded in memory when opened, the files are only written,
+// the memory is only updated once the write succeeded
+type FileStorage struct {
+	dir    string
+	memory *MemoryStorage
+
+	mu      sync.Mutex
+	shares  *os.File
+	credits *os.File
+	reports *os.File
+	// seq is the sequence number of the last credit or report, journaled is the number of them
+	// not yet in balances.json
+	seq       uint64
+	journaled int
+}
+
+// balancesFile is the content of balances.json
+type balancesFile struct {
+	// Seq is the sequence number of the last credit or report included
+	Seq      uint64            `json:"seq"`
+	Balances map[string]uint64 `json:"balances"`
+	Round    map[string]uint64 `json:"round,omitempty"`
+}
+
+// creditEntry is a line of credits.jsonl
+type creditEntry struct {
+	Seq     uint64            `json:"seq"`
+	Credits map[string]uint64 `json:"credits"`
+}
+
+// reportEntry is a line of reports.jsonl, the reports written before the sequence numbers have none
+type reportEntry struct {
+	Report
+	Seq uint64 `json:"seq,omitempty"`
+	// Credited is set when the credits of the report were added to the balances with it
+	Credited bool `json:"credited,omitempty"`
+}
+
+// OpenFileStorage opens the storage in the directory, creating it if needed
+func OpenFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f := &FileStorage{dir: dir, memory: NewMemoryStorage()}
+
+	if err := readLines(filepath.Join(dir, SHARES_FILE), func(line []byte) error {
+		var share Share
+		if err := json.Unmarshal(line, &share); err != nil {
+			return err
+		}
+		f.memory.shares = append(f.memory.shares, share)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	balances, err := os.ReadFile(filepath.Join(dir, BALANCES_FILE))
+	if err == nil {
+		var file balancesFile
+		if err := json.Unmarshal(balances, &file); err != nil {
+			return nil, ErrCorruptedStorage
+		}
+		f.seq = file.Seq
+		if file.Balances != nil {
+			f.memory.balances = file.Balances
+		}
+		if file.Round != nil {
+			f.memory.round = file.Round
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	// the credits and reports after balances.json, replayed in their order
+	var replay []replayed
+	if err := readLines(filepath.Join(dir, CREDITS_FILE), func(line []byte) error {
+		var entry creditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		if entry.Seq > f.seq {
+			replay = append(replay, replayed{seq: entry.Seq, credit: &entry})
+		}
+		f.journaled++
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := readLines(filepath.Join(dir, REPORTS_FILE), func(line []byte) error {
+		var entry reportEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		if entry.Seq > f.seq {
+			replay = append(replay, replayed{seq: entry.Seq, report: &entry})
+			f.journaled++
+		} else {
+			f.memory.reports = append(f.memory.reports, entry.Report)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(replay, func(i, j int) bool {
+		return replay[i].seq < replay[j].seq
+	})
+	for i := range replay {
+		f.replay(&replay[i])
+	}
+
+	if f.shares, err = openAppend(filepath.Join(dir, SHARES_FILE)); err != nil {
+		return nil, err
+	}
+	if f.credits, err = openAppend(filepath.Join(dir, CREDITS_FILE)); err != nil {
+		f.shares.Close()
+		return nil, err
+	}
+	if f.reports, err = openAppend(filepath.Join(dir, REPORTS_FILE)); err != nil {
+		f.shares.Close()
+		f.credits.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// replayed is a credit or a report written after balances.json, applied in order when opening
+type replayed struct {
+	seq    uint64
+	credit *creditEntry
+	report *reportEntry
+}
+
+func (f *FileStorage) replay(r *replayed) {
+	f.seq = r.seq
+	if r.credit != nil {
+		f.memory.Credit(r.credit.Credits)
+	} else {
+		f.memory.addReport(r.report.Report, r.report.Credited)
+	}
+}
+
+func openAppend(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// readLines calls fn with every line of the file, a missing file has no lines
+// A truncated last line, left by a crash during a write, is removed so the next appends start on a new line
+func readLines(path string, fn func(line []byte) error) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var size int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if len(line) == 0 {
+				return nil
+			}
+			// no newline, the last write didn't complete
+			return os.Truncate(path, size)
+		}
+		if err := fn(line); err != nil {
+			return ErrCorruptedStorage
+		}
+		size += int64(len(line))
+	}
+}
+
+// writeFile replaces the file atomically
+func writeFile(path string, write func(*bufio.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func appendLine(file *os.File, v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func (f *FileStorage) AddShare(share Share) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := appendLine(f.shares, share); err != nil {
+		return err
+	}
+	return f.memory.AddShare(share)
+}
+
+func (f *FileStorage) Shares() ([]Share, error) {
+	return f.memory.Shares()
+}
+
+func (f *FileStorage) TrimShares(n int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	shares, _ := f.memory.Shares()
+	if n > len(shares) {
+		n = len(shares)
+	}
+	shares = shares[n:]
+
+	path := filepath.Join(f.dir, SHARES_FILE)
+	if err := writeFile(path, func(w *bufio.Writer) error {
+		encoder := json.NewEncoder(w)
+		for i := range shares {
+			if err := encoder.Encode(&shares[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// the appends must go to the new file
+	file, err := openAppend(path)
+	if err == nil {
+		f.shares.Close()
+		f.shares = file
+	}
+	// the file is already trimmed, the memory must follow even if the appends can't be reopened
+	f.memory.TrimShares(n)
+	return err
+}
+
+// Credit journals the credits, the balances file is rewritten every COMPACT_CREDITS records
+func (f *FileStorage) Credit(credits map[string]uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := appendLine(f.credits, creditEntry{Seq: f.seq + 1, Credits: credits}); err != nil {
+		return err
+	}
+	f.seq++
+	f.journaled++
+	f.memory.Credit(credits)
+
+	f.maybeCompact()
+	return nil
+}
+
+// maybeCompact compacts once COMPACT_CREDITS records are waiting
+// The records are safe in their files, a failed compaction is retried on the next one
+func (f *FileStorage) maybeCompact() {
+	if f.journaled >= COMPACT_CREDITS {
+		f.compact()
+	}
+}
+
+// Flush folds the journaled credits into the balances file
+func (f *FileStorage) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.compact()
+}
+
+// compact rewrites balances.json with every credit, then empties the journal
+func (f *FileStorage) compact() error {
+	if f.journaled == 0 {
+		return nil
+	}
+
+	balances, _ := f.memory.Balances()
+	round, _ := f.memory.Round()
+	if err := writeFile(filepath.Join(f.dir, BALANCES_FILE), func(w *bufio.Writer) error {
+		return json.NewEncoder(w).Encode(balancesFile{Seq: f.seq, Balances: balances, Round: round})
+	}); err != nil {
+		return err
+	}
+
+	// the appends go to the end of the file, truncating is enough
+	if err := f.credits.Truncate(0); err != nil {
+		return err
+	}
+	f.journaled = 0
+	return nil
+}
+
+func (f *FileStorage) Balances() (map[string]uint64, error) {
+	return f.memory.Balances()
+}
+
+func (f *FileStorage) Round() (map[string]uint64, error) {
+	return f.memory.Round()
+}
+
+func (f *FileStorage) AddReport(report Report) error {
+	return f.addReport(report, false)
+}
+
+// CreditReport writes the report and its credits in a single line of reports.jsonl
+func (f *FileStorage) CreditReport(report Report) error {
+	return f.addReport(report, true)
+}
+
+func (f *FileStorage) addReport(report Report, credit bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.memory.reported(report.Height) {
+		return ErrDuplicateReport
+	}
+	if err := appendLine(f.reports, reportEntry{Report: report, Seq: f.seq + 1, Credited: credit}); err != nil {
+		return err
+	}
+	f.seq++
+	f.journaled++
+	f.memory.addReport(report, credit)
+
+	f.maybeCompact()
+	return nil
+}
+
+func (f *FileStorage) Reports() ([]Report, error) {
+	return f.memory.Reports()
+}
+
+// Close folds the journal into the balances and closes the files, the storage can't be used anymore
+func (f *FileStorage) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.compact()
+	if e := f.shares.Close(); err == nil {
+		err = e
+	}
+	if e := f.credits.Close(); err == nil {
+		err = e
+	}
+	if e := f.reports.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,279 @@
+package accounting
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileStorage(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pplns, err := NewPPLNS(storage, 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shares := []Share{
+		{Miner: "a", Difficulty: 40, Time: epoch},
+		{Miner: "b", Difficulty: 30, Time: epoch},
+		{Miner: "a", Difficulty: 50, Time: epoch},
+		{Miner: "c", Difficulty: 40, Time: epoch},
+	}
+	for _, share := range shares {
+		if err := pplns.AddShare(share); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := pplns.BlockFound(Block{Height: 7, Reward: 1000, Time: epoch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a share after the trim must be appended to the new log
+	extra := Share{Miner: "d", Difficulty: 1, Time: epoch}
+	if err := pplns.AddShare(extra); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// everything is back after reopening
+	storage, err = OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	loaded, _ := storage.Shares()
+	if !reflect.DeepEqual(loaded, append(shares[1:], extra)) {
+		t.Fatalf("incorrect shares %+v", loaded)
+	}
+	balances, _ := storage.Balances()
+	if !reflect.DeepEqual(balances, report.Credits) {
+		t.Fatalf("incorrect balances %v", balances)
+	}
+	reports, _ := storage.Reports()
+	if len(reports) != 1 || !reflect.DeepEqual(reports[0], report) {
+		t.Fatalf("incorrect reports %+v", reports)
+	}
+}
+
+func TestFileStorageRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.AddShare(Share{Miner: "a", Difficulty: 1, Time: epoch}); err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+
+	// a crash in the middle of an append leaves a truncated line
+	file, err := os.OpenFile(filepath.Join(dir, SHARES_FILE), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(`{"miner":"b","diffi`))
+	file.Close()
+
+	storage, err = OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.AddShare(Share{Miner: "c", Difficulty: 1, Time: epoch}); err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+
+	// the next appends are readable
+	storage, err = OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, _ := storage.Shares()
+	storage.Close()
+	if len(shares) != 2 || shares[1].Miner != "c" {
+		t.Fatalf("the truncated share must be dropped, got %+v", shares)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, BALANCES_FILE), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFileStorage(dir); err != ErrCorruptedStorage {
+		t.Fatalf("expected %v, got %v", ErrCorruptedStorage, err)
+	}
+}
+
+func TestFileStorageCredits(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := storage.Credit(map[string]uint64{"a": 10, "b": 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the credits are only journaled
+	if _, err := os.Stat(filepath.Join(dir, BALANCES_FILE)); !os.IsNotExist(err) {
+		t.Fatalf("the balances must not be rewritten on every credit, got %v", err)
+	}
+
+	// a crash before the compaction loses nothing
+	reopened, err := OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]uint64{"a": 30, "b": 3}
+	if balances, _ := reopened.Balances(); !reflect.DeepEqual(balances, expected) {
+		t.Fatalf("incorrect balances %v, expected %v", balances, expected)
+	}
+	reopened.Close()
+
+	journal, err := os.ReadFile(filepath.Join(dir, CREDITS_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+
+	// a crash between the rewrite of the balances and the truncation of the journal
+	// must not credit the journal twice
+	if err := os.WriteFile(filepath.Join(dir, CREDITS_FILE), journal, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	storage, err = OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if balances, _ := storage.Balances(); !reflect.DeepEqual(balances, expected) {
+		t.Fatalf("incorrect balances %v, expected %v", balances, expected)
+	}
+
+	// the journal is folded every COMPACT_CREDITS credits
+	for i := 0; i < COMPACT_CREDITS; i++ {
+		if err := storage.Credit(map[string]uint64{"c": 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, CREDITS_FILE)); err != nil || info.Size() != 0 {
+		t.Fatalf("the journal must be compacted, got %v", err)
+	}
+	expected["c"] = COMPACT_CREDITS
+	if balances, _ := storage.Balances(); !reflect.DeepEqual(balances, expected) {
+		t.Fatalf("incorrect balances %v, expected %v", balances, expected)
+	}
+}
+
+func TestFileStorageWriteFailure(t *testing.T) {
+	storage, err := OpenFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Credit(map[string]uint64{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.AddShare(Share{Miner: "a", Difficulty: 1, Time: epoch}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the memory is only updated once written
+	storage.credits.Close()
+	storage.shares.Close()
+	if err := storage.Credit(map[string]uint64{"a": 1}); err == nil {
+		t.Fatal("the credit must fail")
+	}
+	if balances, _ := storage.Balances(); balances["a"] != 1 {
+		t.Fatalf("a failed credit must not be applied, got %v", balances)
+	}
+	if err := storage.AddShare(Share{Miner: "b", Difficulty: 1, Time: epoch}); err == nil {
+		t.Fatal("the share must fail")
+	}
+	if shares, _ := storage.Shares(); len(shares) != 1 {
+		t.Fatalf("a failed share must not be logged, got %+v", shares)
+	}
+}
+
+func TestFileStorageRound(t *testing.T) {
+	dir := t.TempDir()
+
+	storage, err := OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pps, err := NewPPS(storage, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pps.SetNetwork(100, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := pps.AddShare(Share{Miner: "a", Difficulty: 1, Time: epoch}); err != nil {
+		t.Fatal(err)
+	}
+	// the round is kept in balances.json once compacted
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err = OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pps, _ = NewPPS(storage, 0)
+	pps.SetNetwork(100, 1000)
+	if err := pps.AddShare(Share{Miner: "b", Difficulty: 2, Time: epoch}); err != nil {
+		t.Fatal(err)
+	}
+
+	// and rebuilt from the journal after a crash
+	storage, err = OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	pps, _ = NewPPS(storage, 0)
+	report, err := pps.BlockFound(Block{Height: 1, Reward: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]uint64{"a": 10, "b": 20}
+	if !reflect.DeepEqual(report.Credits, expected) {
+		t.Fatalf("the round must survive a restart, got %+v", report)
+	}
+
+	// a report credits its amounts in the same write, reopening credits them once
+	if err := storage.CreditReport(Report{Height: 2, Credits: map[string]uint64{"a": 5}}); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if round, _ := reopened.Round(); len(round) != 0 {
+		t.Fatalf("the report must start a new round, got %v", round)
+	}
+	if balances, _ := reopened.Balances(); !reflect.DeepEqual(balances, map[string]uint64{"a": 15, "b": 20}) {
+		t.Fatalf("incorrect balances %v", balances)
+	}
+	if reports, _ := reopened.Reports(); len(reports) != 2 || reports[1].Height != 2 {
+		t.Fatalf("incorrect reports %+v", reports)
+	}
+	if err := reopened.AddReport(Report{Height: 1}); err != ErrDuplicateReport {
+		t.Fatalf("expected %v, got %v", ErrDuplicateReport, err)
+	}
+}
@@ -0,0 +1,154 @@
+// Package address parses and encodes XELIS addresses
+//
+// An address is the bech32 encoding (BIP173 checksum, ':' separator) of the miner public key
+// followed by the address type:
+//
+//	| public key (32 bytes) | type (1 byte) | data (integrated addresses only) |
+//
+// Mainnet addresses start with "xel:", testnet and devnet ones with "xet:".
+// The data of integrated addresses is kept as raw bytes, it isn't needed to mine.
+package address
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/xelpool/xelishash"
+)
+
+const (
+	PREFIX_MAINNET = "xel"
+	PREFIX_TESTNET = "xet"
+)
+
+// Address types, written after the public key
+const (
+	TYPE_NORMAL     = 0
+	TYPE_INTEGRATED = 1
+)
+
+var (
+	ErrInvalidSize   = errors.New("address: invalid size")
+	ErrInvalidType   = errors.New("address: invalid address type")
+	ErrMissingData   = errors.New("address: integrated address without data")
+	ErrWrongNetwork  = errors.New("address: wrong network")
+	ErrUnknownPrefix = errors.New("address: unknown prefix")
+)
+
+type Network int
+
+const (
+	Mainnet Network = iota
+	Testnet
+)
+
+func (n Network) String() string {
+	switch n {
+	case Mainnet:
+		return "mainnet"
+	case Testnet:
+		return "testnet"
+	}
+	return fmt.Sprintf("Network(%d)", int(n))
+}
+
+// Prefix returns the human readable part of the addresses of the network
+func (n Network) Prefix() string {
+	if n == Mainnet {
+		return PREFIX_MAINNET
+	}
+	return PREFIX_TESTNET
+}
+
+type Address struct {
+	Network   Network
+	PublicKey [xelishash.PUBLIC_KEY_SIZE]byte
+	// Data is the serialized data of an integrated address, nil for a normal address
+	Data []byte
+}
+
+// NewAddress returns the normal address of the public key on the network
+func NewAddress(network Network, public_key [xelishash.PUBLIC_KEY_SIZE]byte) Address {
+	return Address{Network: network, PublicKey: public_key}
+}
+
+// Parse decodes an address of any network
+func Parse(s string) (Address, error) {
+	prefix, data, err := decodeBech32(s)
+	if err != nil {
+		return Address{}, err
+	}
+
+	var a Address
+	switch prefix {
+	case PREFIX_MAINNET:
+		a.Network = Mainnet
+	case PREFIX_TESTNET:
+		a.Network = Testnet
+	default:
+		return Address{}, ErrUnknownPrefix
+	}
+
+	raw, err := convertBits(data, 5, 8, false)
+	if err != nil {
+		return Address{}, err
+	}
+	if len(raw) < xelishash.PUBLIC_KEY_SIZE+1 {
+		return Address{}, ErrInvalidSize
+	}
+	copy(a.PublicKey[:], raw)
+
+	switch raw[xelishash.PUBLIC_KEY_SIZE] {
+	case TYPE_NORMAL:
+		if len(raw) != xelishash.PUBLIC_KEY_SIZE+1 {
+			return Address{}, ErrInvalidSize
+		}
+	case TYPE_INTEGRATED:
+		if len(raw) == xelishash.PUBLIC_KEY_SIZE+1 {
+			return Address{}, ErrMissingData
+		}
+		a.Data = raw[xelishash.PUBLIC_KEY_SIZE+1:]
+	default:
+		return Address{}, ErrInvalidType
+	}
+
+	return a, nil
+}
+
+// ParseNetwork decodes an address and checks it belongs to the network
+func ParseNetwork(s string, network Network) (Address, error) {
+	a, err := Parse(s)
+	if err != nil {
+		return Address{}, err
+	}
+	if a.Network != network {
+		return Address{}, fmt.Errorf("%w: %s address, expected a %s one", ErrWrongNetwork, a.Network, network)
+	}
+	return a, nil
+}
+
+// IsIntegrated reports whether the address carries data
+func (a Address) IsIntegrated() bool {
+	return a.Data != nil
+}
+
+// Normal returns the address without its data
+func (a Address) Normal() Address {
+	return Address{Network: a.Network, PublicKey: a.PublicKey}
+}
+
+// Bytes returns the serialized public key, type and data
+func (a Address) Bytes() []byte {
+	raw := make([]byte, 0, xelishash.PUBLIC_KEY_SIZE+1+len(a.Data))
+	raw = append(raw, a.PublicKey[:]...)
+	if a.IsIntegrated() {
+		raw = append(raw, TYPE_INTEGRATED)
+		return append(raw, a.Data...)
+	}
+	return append(raw, TYPE_NORMAL)
+}
+
+func (a Address) String() string {
+	data, _ := convertBits(a.Bytes(), 8, 5, true)
+	return encodeBech32(a.Network.Prefix(), data)
+}
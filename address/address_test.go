@@ -0,0 +1,135 @@
+package address
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xelpool/xelishash"
+)
+
+// the address receiving the dev fee of the XELIS daemon
+const DEV_ADDRESS = "xel:vs3mfyywt0fjys0rgslue7mm4wr23xdgejsjk0ld7f2kxng4d4nqqnkdufz"
+const DEV_PUBLIC_KEY = "6423b4908e5bd32241e3443fccfb7bab86a899a8cca12b3fedf255634d156d66"
+
+func TestParse(t *testing.T) {
+	a, err := Parse(DEV_ADDRESS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Network != Mainnet || a.IsIntegrated() || hex.EncodeToString(a.PublicKey[:]) != DEV_PUBLIC_KEY {
+		t.Fatalf("incorrect address %+v", a)
+	}
+	if a.String() != DEV_ADDRESS {
+		t.Fatalf("incorrect encoding %s", a)
+	}
+
+	// the case is ignored as long as it isn't mixed
+	if upper, err := Parse(strings.ToUpper(DEV_ADDRESS)); err != nil || !reflect.DeepEqual(upper, a) {
+		t.Fatalf("got %+v, %v", upper, err)
+	}
+
+	testnet := NewAddress(Testnet, a.PublicKey)
+	if !strings.HasPrefix(testnet.String(), "xet:") {
+		t.Fatalf("incorrect testnet address %s", testnet)
+	}
+	if parsed, err := ParseNetwork(testnet.String(), Testnet); err != nil || !reflect.DeepEqual(parsed, testnet) {
+		t.Fatalf("got %+v, %v", parsed, err)
+	}
+}
+
+func TestIntegrated(t *testing.T) {
+	a, _ := Parse(DEV_ADDRESS)
+	a.Data = []byte{2, 0, 1, 'x'}
+
+	parsed, err := Parse(a.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.IsIntegrated() || parsed.PublicKey != a.PublicKey || !bytes.Equal(parsed.Data, a.Data) {
+		t.Fatalf("incorrect integrated address %+v", parsed)
+	}
+	if parsed.Normal().String() != DEV_ADDRESS {
+		t.Fatalf("incorrect normal address %s", parsed.Normal())
+	}
+}
+
+func TestWrongNetwork(t *testing.T) {
+	if _, err := ParseNetwork(DEV_ADDRESS, Testnet); !errors.Is(err, ErrWrongNetwork) {
+		t.Fatalf("expected %v, got %v", ErrWrongNetwork, err)
+	}
+
+	// a valid bech32 string with another prefix
+	other := encodeBech32("btc", make([]byte, 53))
+	if _, err := Parse(other); err != ErrUnknownPrefix {
+		t.Fatalf("expected %v, got %v", ErrUnknownPrefix, err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	// every single character substitution is detected
+	separator := strings.Index(DEV_ADDRESS, SEPARATOR)
+	for i := separator + 1; i < len(DEV_ADDRESS); i++ {
+		for _, c := range CHARSET {
+			if byte(c) == DEV_ADDRESS[i] {
+				continue
+			}
+			corrupted := DEV_ADDRESS[:i] + string(c) + DEV_ADDRESS[i+1:]
+			if _, err := Parse(corrupted); err != ErrInvalidChecksum {
+				t.Fatalf("%s: expected %v, got %v", corrupted, ErrInvalidChecksum, err)
+			}
+		}
+	}
+
+	// the prefix is covered by the checksum
+	if _, err := Parse("xet" + DEV_ADDRESS[3:]); err != ErrInvalidChecksum {
+		t.Fatalf("expected %v, got %v", ErrInvalidChecksum, err)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	tests := []struct {
+		address string
+		err     error
+	}{
+		{"xel:" + strings.ToUpper(DEV_ADDRESS[4:]), ErrMixedCase},
+		{"xel1" + DEV_ADDRESS[4:], ErrMissingSeparator},
+		{":" + DEV_ADDRESS[4:], ErrInvalidPrefix},
+		{DEV_ADDRESS[:10] + "b" + DEV_ADDRESS[11:], ErrInvalidCharacter},
+		{"xel:qqq", ErrInvalidChecksum},
+		{encodeBech32(PREFIX_MAINNET, make([]byte, 10)), ErrInvalidSize},
+	}
+	for _, test := range tests {
+		if _, err := Parse(test.address); err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.address, test.err, err)
+		}
+	}
+
+	// unknown address type
+	raw := make([]byte, xelishash.PUBLIC_KEY_SIZE+1)
+	raw[xelishash.PUBLIC_KEY_SIZE] = 2
+	data, _ := convertBits(raw, 8, 5, true)
+	if _, err := Parse(encodeBech32(PREFIX_MAINNET, data)); err != ErrInvalidType {
+		t.Fatalf("expected %v, got %v", ErrInvalidType, err)
+	}
+
+	// integrated without data
+	raw[xelishash.PUBLIC_KEY_SIZE] = TYPE_INTEGRATED
+	data, _ = convertBits(raw, 8, 5, true)
+	if _, err := Parse(encodeBech32(PREFIX_MAINNET, data)); err != ErrMissingData {
+		t.Fatalf("expected %v, got %v", ErrMissingData, err)
+	}
+}
+
+func TestMinerWorkPublicKey(t *testing.T) {
+	a, _ := Parse(DEV_ADDRESS)
+
+	var work xelishash.MinerWork
+	work.SetPublicKey(a.PublicKey)
+	if work.PublicKey() != a.PublicKey || NewAddress(Mainnet, work.PublicKey()).String() != DEV_ADDRESS {
+		t.Fatalf("incorrect work %s", work)
+	}
+}
@@ -0,0 +1,150 @@
+package address
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// SEPARATOR splits the prefix from the data, XELIS uses ':' instead of the '1' of BIP173
+	SEPARATOR = ":"
+	CHARSET   = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	// CHECKSUM_SIZE is the number of 5-bit characters of the checksum
+	CHECKSUM_SIZE = 6
+)
+
+var (
+	ErrMixedCase        = errors.New("address: mixed case")
+	ErrMissingSeparator = errors.New("address: missing separator")
+	ErrInvalidPrefix    = errors.New("address: invalid prefix")
+	ErrInvalidCharacter = errors.New("address: invalid character")
+	ErrInvalidChecksum  = errors.New("address: invalid checksum")
+	ErrInvalidPadding   = errors.New("address: invalid padding")
+)
+
+var generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range generator {
+			if (top>>i)&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	return chk
+}
+
+// expandPrefix returns the prefix as fed to the checksum: high bits, zero, low bits
+func expandPrefix(prefix string) []byte {
+	expanded := make([]byte, 0, len(prefix)*2+1)
+	for i := 0; i < len(prefix); i++ {
+		expanded = append(expanded, prefix[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(prefix); i++ {
+		expanded = append(expanded, prefix[i]&31)
+	}
+	return expanded
+}
+
+func checksum(prefix string, data []byte) [CHECKSUM_SIZE]byte {
+	values := append(expandPrefix(prefix), data...)
+	values = append(values, make([]byte, CHECKSUM_SIZE)...)
+	mod := polymod(values) ^ 1
+
+	var sum [CHECKSUM_SIZE]byte
+	for i := range sum {
+		sum[i] = byte(mod>>(5*(5-i))) & 31
+	}
+	return sum
+}
+
+func verifyChecksum(prefix string, data []byte) bool {
+	return polymod(append(expandPrefix(prefix), data...)) == 1
+}
+
+// encodeBech32 encodes the 5-bit values with the prefix and their checksum
+func encodeBech32(prefix string, data []byte) string {
+	var b strings.Builder
+	b.Grow(len(prefix) + len(SEPARATOR) + len(data) + CHECKSUM_SIZE)
+	b.WriteString(prefix)
+	b.WriteString(SEPARATOR)
+	for _, v := range data {
+		b.WriteByte(CHARSET[v])
+	}
+	sum := checksum(prefix, data)
+	for _, v := range sum {
+		b.WriteByte(CHARSET[v])
+	}
+	return b.String()
+}
+
+// decodeBech32 returns the prefix and the 5-bit values of a bech32 string, without the checksum
+func decodeBech32(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, ErrMixedCase
+	}
+	s = strings.ToLower(s)
+
+	pos := strings.LastIndex(s, SEPARATOR)
+	if pos < 0 {
+		return "", nil, ErrMissingSeparator
+	}
+	prefix := s[:pos]
+	if len(prefix) == 0 {
+		return "", nil, ErrInvalidPrefix
+	}
+	for i := 0; i < len(prefix); i++ {
+		if prefix[i] < 33 || prefix[i] > 126 {
+			return "", nil, ErrInvalidPrefix
+		}
+	}
+
+	encoded := s[pos+len(SEPARATOR):]
+	if len(encoded) < CHECKSUM_SIZE {
+		return "", nil, ErrInvalidChecksum
+	}
+	data := make([]byte, len(encoded))
+	for i := 0; i < len(encoded); i++ {
+		v := strings.IndexByte(CHARSET, encoded[i])
+		if v < 0 {
+			return "", nil, ErrInvalidCharacter
+		}
+		data[i] = byte(v)
+	}
+
+	if !verifyChecksum(prefix, data) {
+		return "", nil, ErrInvalidChecksum
+	}
+	return prefix, data[:len(data)-CHECKSUM_SIZE], nil
+}
+
+// convertBits regroups the bits of the values from groups of from bits to groups of to bits
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	max := uint32(1)<<to - 1
+
+	converted := make([]byte, 0, len(data)*int(from)/int(to)+1)
+	for _, v := range data {
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			converted = append(converted, byte(acc>>bits&max))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			converted = append(converted, byte(acc<<(to-bits)&max))
+		}
+	} else if bits >= from || acc<<(to-bits)&max != 0 {
+		return nil, ErrInvalidPadding
+	}
+	return converted, nil
+}
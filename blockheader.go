@@ -0,0 +1,203 @@
+package xelishash
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/zeebo/blake3"
+)
+
+// Limits of the block header, as enforced by the XELIS daemon
+const (
+	TIPS_LIMIT = 3
+	TXS_LIMIT  = 1<<16 - 1
+
+	// HEADER_WORK_SIZE is the size of the input of the work hash:
+	// version (1) | height (8, big-endian) | tips hash (32) | transactions hash (32)
+	HEADER_WORK_SIZE = 1 + 8 + HASH_SIZE + HASH_SIZE
+	// BLOCK_WORK_SIZE is the size of the input of the block hash, the miner work of the header
+	BLOCK_WORK_SIZE = MINER_WORK_SIZE
+)
+
+var (
+	ErrTooManyTips        = errors.New("xelishash: too many tips")
+	ErrTooManyTxs         = errors.New("xelishash: too many transactions")
+	ErrDuplicateHash      = errors.New("xelishash: duplicate tip or transaction")
+	ErrInvalidBlockHeader = errors.New("xelishash: invalid block header")
+	ErrWorkMismatch       = errors.New("xelishash: miner work not derived from the header")
+)
+
+// BlockHeader is the header of a XELIS block
+// Its canonical serialization is:
+//
+//	version (1) | height (8) | timestamp (8) | nonce (8) | extra nonce (32) |
+//	tips count (1) | tips (32 each) | transactions count (2) | transaction hashes (32 each) | miner public key (32)
+//
+// Integers are big-endian, the timestamp is in milliseconds
+// The block hash isn't taken over it but over the miner work, which covers the tips and transactions through the work hash
+type BlockHeader struct {
+	Version    uint8
+	Height     uint64
+	Timestamp  uint64
+	Nonce      uint64
+	ExtraNonce [EXTRA_NONCE_SIZE]byte
+	Tips       []Hash
+	TxsHashes  []Hash
+	Miner      [PUBLIC_KEY_SIZE]byte
+}
+
+// Validate checks the tips and transactions fit in the serialization and are unique
+func (h *BlockHeader) Validate() error {
+	if len(h.Tips) > TIPS_LIMIT {
+		return ErrTooManyTips
+	}
+	if len(h.TxsHashes) > TXS_LIMIT {
+		return ErrTooManyTxs
+	}
+	if hasDuplicate(h.Tips) || hasDuplicate(h.TxsHashes) {
+		return ErrDuplicateHash
+	}
+	return nil
+}
+
+func hasDuplicate(hashes []Hash) bool {
+	seen := make(map[Hash]struct{}, len(hashes))
+	for _, hash := range hashes {
+		if _, ok := seen[hash]; ok {
+			return true
+		}
+		seen[hash] = struct{}{}
+	}
+	return false
+}
+
+// Size returns the size of the serialized header
+func (h *BlockHeader) Size() int {
+	return 1 + 8 + 8 + 8 + EXTRA_NONCE_SIZE + 1 + len(h.Tips)*HASH_SIZE + 2 + len(h.TxsHashes)*HASH_SIZE + PUBLIC_KEY_SIZE
+}
+
+// Bytes returns the canonical serialization of the header
+// The header must be valid, the counts are truncated otherwise
+func (h *BlockHeader) Bytes() []byte {
+	b := make([]byte, 0, h.Size())
+	b = append(b, h.Version)
+	b = binary.BigEndian.AppendUint64(b, h.Height)
+	b = binary.BigEndian.AppendUint64(b, h.Timestamp)
+	b = binary.BigEndian.AppendUint64(b, h.Nonce)
+	b = append(b, h.ExtraNonce[:]...)
+	b = append(b, uint8(len(h.Tips)))
+	for _, tip := range h.Tips {
+		b = append(b, tip[:]...)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(h.TxsHashes)))
+	for _, tx := range h.TxsHashes {
+		b = append(b, tx[:]...)
+	}
+	return append(b, h.Miner[:]...)
+}
+
+// ParseBlockHeader reads a header serialized with Bytes, without trailing bytes
+func ParseBlockHeader(b []byte) (BlockHeader, error) {
+	var h BlockHeader
+
+	const FIXED_SIZE = 1 + 8 + 8 + 8 + EXTRA_NONCE_SIZE + 1
+	if len(b) < FIXED_SIZE {
+		return BlockHeader{}, ErrInvalidBlockHeader
+	}
+	h.Version = b[0]
+	h.Height = binary.BigEndian.Uint64(b[1:])
+	h.Timestamp = binary.BigEndian.Uint64(b[9:])
+	h.Nonce = binary.BigEndian.Uint64(b[17:])
+	copy(h.ExtraNonce[:], b[25:])
+	tips := int(b[FIXED_SIZE-1])
+	b = b[FIXED_SIZE:]
+
+	if len(b) < tips*HASH_SIZE+2 {
+		return BlockHeader{}, ErrInvalidBlockHeader
+	}
+	h.Tips = readHashes(b, tips)
+	b = b[tips*HASH_SIZE:]
+
+	txs := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) != txs*HASH_SIZE+PUBLIC_KEY_SIZE {
+		return BlockHeader{}, ErrInvalidBlockHeader
+	}
+	h.TxsHashes = readHashes(b, txs)
+	copy(h.Miner[:], b[txs*HASH_SIZE:])
+
+	if err := h.Validate(); err != nil {
+		return BlockHeader{}, err
+	}
+	return h, nil
+}
+
+func readHashes(b []byte, n int) []Hash {
+	hashes := make([]Hash, n)
+	for i := range hashes {
+		copy(hashes[i][:], b[i*HASH_SIZE:])
+	}
+	return hashes
+}
+
+// hashAll returns the blake3 hash of the concatenated hashes
+func hashAll(hashes []Hash) Hash {
+	hasher := blake3.New()
+	for _, hash := range hashes {
+		hasher.Write(hash[:])
+	}
+	var sum Hash
+	hasher.Sum(sum[:0])
+	return sum
+}
+
+// TipsHash returns the hash of the concatenated tips
+func (h *BlockHeader) TipsHash() Hash {
+	return hashAll(h.Tips)
+}
+
+// TxsHash returns the hash of the concatenated transaction hashes
+func (h *BlockHeader) TxsHash() Hash {
+	return hashAll(h.TxsHashes)
+}
+
+// Work returns the input of the work hash, the part of the header the miners can't change
+func (h *BlockHeader) Work() [HEADER_WORK_SIZE]byte {
+	var work [HEADER_WORK_SIZE]byte
+	work[0] = h.Version
+	binary.BigEndian.PutUint64(work[1:], h.Height)
+	tips, txs := h.TipsHash(), h.TxsHash()
+	copy(work[9:], tips[:])
+	copy(work[9+HASH_SIZE:], txs[:])
+	return work
+}
+
+// WorkHash returns the hash heading the miner work
+func (h *BlockHeader) WorkHash() Hash {
+	work := h.Work()
+	return blake3.Sum256(work[:])
+}
+
+// Hash returns the block hash, see MinerWork.BlockHash
+func (h *BlockHeader) Hash() Hash {
+	work := h.MinerWork()
+	return work.BlockHash()
+}
+
+// MinerWork returns the input of the PoW hash of the header
+func (h *BlockHeader) MinerWork() MinerWork {
+	return NewMinerWork(h.WorkHash(), h.Timestamp, h.Nonce, h.ExtraNonce, h.Miner)
+}
+
+// ApplyMinerWork copies the fields set by the miner back into the header
+// The work must be derived from this header, ErrWorkMismatch is returned otherwise
+func (h *BlockHeader) ApplyMinerWork(work *MinerWork) error {
+	if work.WorkHash() != h.WorkHash() {
+		return ErrWorkMismatch
+	}
+	h.Timestamp = work.Timestamp()
+	h.Nonce = work.Nonce()
+	h.ExtraNonce = work.ExtraNonce()
+	h.Miner = work.PublicKey()
+	return nil
+}
@@ -0,0 +1,139 @@
+package xelishash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/blake3"
+)
+
+// The fixture is written field by field following the serialization of the XELIS daemon,
+// its timestamp, nonce, extra nonce and miner key are the ones of the TestMinerWork input
+// The miner work of real mainnet blocks is checked against their block hash in mainnet_test.go
+var headerFixture = strings.Join([]string{
+	"01",               // version
+	"00000000000003e8", // height 1000
+	"0000019441d295ce", // timestamp 1736271107534
+	"000000000000026f", // nonce 623
+	"1eb46b98029e3c92486103f0856e120dc4d589ffac2bb2ed0000000000000001", // extra nonce
+	"02", // tips
+	"aa00000000000000000000000000000000000000000000000000000000000001",
+	"aa00000000000000000000000000000000000000000000000000000000000002",
+	"0001", // transactions
+	"bb00000000000000000000000000000000000000000000000000000000000001",
+	"5069ad8c60b8d821cdbe2c3b57dfd640e297c873592a83fbb6122fd26cdb457e", // miner
+}, "")
+
+const TIPS_OFFSET = 1 + 8 + 8 + 8 + EXTRA_NONCE_SIZE + 1
+
+func parseFixture(t *testing.T) ([]byte, BlockHeader) {
+	t.Helper()
+
+	input, err := hex.DecodeString(headerFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := ParseBlockHeader(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input, header
+}
+
+func TestBlockHeader(t *testing.T) {
+	input, header := parseFixture(t)
+
+	if header.Version != 1 || header.Height != 1000 || header.Timestamp != 1736271107534 || header.Nonce != 623 {
+		t.Fatalf("incorrect header %+v", header)
+	}
+	if len(header.Tips) != 2 || header.Tips[1][HASH_SIZE-1] != 2 || len(header.TxsHashes) != 1 || header.TxsHashes[0][0] != 0xbb {
+		t.Fatalf("incorrect tips or transactions %+v", header)
+	}
+	if !bytes.Equal(header.Bytes(), input) || header.Size() != len(input) {
+		t.Fatalf("incorrect serialization %x", header.Bytes())
+	}
+	// the work hash only covers the version, the height, the tips and the transactions
+	tips := blake3.Sum256(input[TIPS_OFFSET : TIPS_OFFSET+2*HASH_SIZE])
+	txs := blake3.Sum256(input[TIPS_OFFSET+2*HASH_SIZE+2 : TIPS_OFFSET+3*HASH_SIZE+2])
+	work := append(append(append([]byte{}, input[:9]...), tips[:]...), txs[:]...)
+	if header.WorkHash() != Hash(blake3.Sum256(work)) {
+		t.Fatalf("incorrect work hash %s", header.WorkHash())
+	}
+
+	// the rest of the miner work matches the TestMinerWork input
+	miner_work := header.MinerWork()
+	expected := []byte{0, 0, 1, 148, 65, 210, 149, 206, 0, 0, 0, 0, 0, 0, 2, 111, 30, 180, 107, 152, 2, 158, 60, 146, 72, 97, 3, 240, 133, 110, 18, 13, 196, 213, 137, 255, 172, 43, 178, 237, 0, 0, 0, 0, 0, 0, 0, 1, 80, 105, 173, 140, 96, 184, 216, 33, 205, 190, 44, 59, 87, 223, 214, 64, 226, 151, 200, 115, 89, 42, 131, 251, 182, 18, 47, 210, 108, 219, 69, 126}
+	if miner_work.WorkHash() != header.WorkHash() || !bytes.Equal(miner_work[TIMESTAMP_OFFSET:], expected) {
+		t.Fatalf("incorrect miner work %s", miner_work)
+	}
+
+	tp := NewThreadPool(1)
+	if miner_work.Hash(tp) != tp.XelisHashV2(miner_work[:]) {
+		t.Fatal("the miner work must be the xel/1 input")
+	}
+
+	// the block hash is taken over the miner work, not the full serialization
+	if len(miner_work) != BLOCK_WORK_SIZE || header.Hash() != Hash(blake3.Sum256(miner_work[:])) || header.Hash() == Hash(blake3.Sum256(input)) {
+		t.Fatalf("incorrect block hash %s", header.Hash())
+	}
+}
+
+func TestBlockHeaderMinerWork(t *testing.T) {
+	_, header := parseFixture(t)
+
+	// the miner changes the nonce, the extra nonce, the timestamp and the key
+	work := header.MinerWork()
+	work.SetNonce(42)
+	work.SetTimestamp(header.Timestamp + 1)
+	work.SetPublicKey([PUBLIC_KEY_SIZE]byte{1})
+
+	block_hash := header.Hash()
+	if err := header.ApplyMinerWork(&work); err != nil {
+		t.Fatal(err)
+	}
+	if header.Nonce != 42 || header.Timestamp != 1736271107535 || header.Miner != [PUBLIC_KEY_SIZE]byte{1} || header.MinerWork() != work {
+		t.Fatalf("miner work not applied %+v", header)
+	}
+	if header.Hash() == block_hash {
+		t.Fatal("the block hash must cover the miner fields")
+	}
+	block_hash = header.Hash()
+	txs := header.TxsHashes
+	header.TxsHashes = nil
+	if header.Hash() == block_hash {
+		t.Fatal("the block hash must cover the transactions through the work hash")
+	}
+	header.TxsHashes = txs
+
+	// another template
+	header.Tips = header.Tips[:1]
+	if err := header.ApplyMinerWork(&work); err != ErrWorkMismatch {
+		t.Fatalf("expected %v, got %v", ErrWorkMismatch, err)
+	}
+}
+
+func TestBlockHeaderInvalid(t *testing.T) {
+	input, header := parseFixture(t)
+
+	for _, b := range [][]byte{input[:10], input[:TIPS_OFFSET+HASH_SIZE], input[:len(input)-1], append(input, 0)} {
+		if _, err := ParseBlockHeader(b); err != ErrInvalidBlockHeader {
+			t.Fatalf("%d bytes: expected %v, got %v", len(b), ErrInvalidBlockHeader, err)
+		}
+	}
+
+	duplicate := header
+	duplicate.Tips = []Hash{header.Tips[0], header.Tips[0]}
+	if _, err := ParseBlockHeader(duplicate.Bytes()); err != ErrDuplicateHash {
+		t.Fatalf("expected %v, got %v", ErrDuplicateHash, err)
+	}
+
+	header.Tips = make([]Hash, TIPS_LIMIT+1)
+	for i := range header.Tips {
+		header.Tips[i][0] = byte(i)
+	}
+	if err := header.Validate(); err != ErrTooManyTips {
+		t.Fatalf("expected %v, got %v", ErrTooManyTips, err)
+	}
+}
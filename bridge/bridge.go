@@ -0,0 +1,184 @@
+// Package bridge lets stratum miners mine on a node only exposing getwork
+//
+// The getwork jobs of the daemon become the templates of a stratum server.
+// The 32-byte extra nonce is split between the bridge and the miners:
+//
+//	| ExtraNonceBase | connection prefix (PrefixSize bytes) | rolled by the miner (the rest) |
+//
+// Shares are verified by the stratum server with the ThreadPool, the ones meeting the
+// network target are rebuilt into the full miner work and submitted to the daemon.
+// Invalid shares never reach the node.
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xelpool/xelishash/getwork"
+	"github.com/xelpool/xelishash/miner"
+	"github.com/xelpool/xelishash/stratum/server"
+	"github.com/xelpool/xelishash/vardiff"
+)
+
+type Config struct {
+	// Upstream is the getwork session with the daemon, the blocks are paid to its address
+	Upstream getwork.Config
+	// Server configures the stratum side, its Blocks sink is set by the bridge
+	// Without a ShareDifficulty nor Vardiff, the share difficulty is adjusted with vardiff.DefaultConfig
+	Server server.Config
+	// Errors is called with the jobs of the daemon that could not become templates
+	// and the blocks that could not be sent to the daemon, for logging
+	// They are counted in the stats either way
+	Errors func(err error)
+}
+
+// Stats counts the blocks sent to the daemon along with the stats of the stratum server
+type Stats struct {
+	Server    server.Stats
+	Submitted uint64
+	Accepted  uint64
+	Rejected  uint64
+	// Failed counts the blocks that could not be sent to the daemon
+	Failed uint64
+	// InvalidJobs counts the jobs of the daemon refused by the stratum server
+	InvalidJobs uint64
+}
+
+type Bridge struct {
+	config   Config
+	upstream *getwork.Client
+	server   *server.Server
+
+	submitted    atomic.Uint64
+	accepted     atomic.Uint64
+	rejected     atomic.Uint64
+	failed       atomic.Uint64
+	invalid_jobs atomic.Uint64
+
+	// last_reason is the reason of the last rejection by the daemon, last_err the last error of the bridge
+	mu          sync.Mutex
+	last_reason string
+	last_err    error
+}
+
+func New(config Config) (*Bridge, error) {
+	b := &Bridge{config: config, upstream: getwork.NewClient(config.Upstream)}
+
+	if config.Server.ShareDifficulty == 0 && config.Server.Vardiff == nil {
+		retarget := vardiff.DefaultConfig
+		config.Server.Vardiff = &retarget
+	}
+	config.Server.Blocks = server.BlockSinkFunc(b.submit)
+
+	s, err := server.New(config.Server)
+	if err != nil {
+		return nil, err
+	}
+	b.server = s
+
+	return b, nil
+}
+
+func (b *Bridge) Stats() Stats {
+	return Stats{
+		Server:      b.server.Stats(),
+		Submitted:   b.submitted.Load(),
+		Accepted:    b.accepted.Load(),
+		Rejected:    b.rejected.Load(),
+		Failed:      b.failed.Load(),
+		InvalidJobs: b.invalid_jobs.Load(),
+	}
+}
+
+// LastRejection returns the reason given by the daemon for the last rejected block
+func (b *Bridge) LastRejection() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.last_reason
+}
+
+// LastError returns the last job refused by the stratum server or block not sent to the daemon, nil if none
+func (b *Bridge) LastError() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.last_err
+}
+
+func (b *Bridge) fail(err error) {
+	b.mu.Lock()
+	b.last_err = err
+	b.mu.Unlock()
+
+	if b.config.Errors != nil {
+		b.config.Errors(err)
+	}
+}
+
+// submit sends a share meeting the network target to the daemon
+// The error is also returned to the stratum server, which counts the block as failed
+func (b *Bridge) submit(share server.Share) error {
+	if err := b.upstream.Submit(miner.Solution{JobID: share.JobID, Nonce: share.Work.Nonce(), Work: share.Work, Hash: share.Hash}); err != nil {
+		err = fmt.Errorf("bridge: block at height %d not submitted: %w", share.Height, err)
+		b.failed.Add(1)
+		b.fail(err)
+		return err
+	}
+	b.submitted.Add(1)
+	return nil
+}
+
+// Serve runs the getwork session and serves the stratum miners on the listener until the context is done
+func (b *Bridge) Serve(ctx context.Context, listener net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		b.upstream.Run(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		b.forward(ctx)
+	}()
+
+	return b.server.Serve(ctx, listener)
+}
+
+// forward turns the getwork jobs into stratum templates and counts the answers of the daemon
+func (b *Bridge) forward(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-b.upstream.Jobs():
+			// the miners keep the previous template, the daemon rejects its blocks once stale
+			if err := b.server.SetTemplate(miner.Template{
+				Work:      job.Work,
+				Algorithm: job.Algorithm,
+				Target:    job.Target,
+				Height:    job.Height,
+			}); err != nil {
+				b.invalid_jobs.Add(1)
+				b.fail(fmt.Errorf("bridge: job at height %d refused: %w", job.Height, err))
+			}
+		case result := <-b.upstream.Results():
+			if result.Accepted {
+				b.accepted.Add(1)
+				continue
+			}
+			b.rejected.Add(1)
+			b.mu.Lock()
+			b.last_reason = result.Reason
+			b.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package bridge
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/getwork"
+	"github.com/xelpool/xelishash/miner"
+	"github.com/xelpool/xelishash/mockdaemon"
+	"github.com/xelpool/xelishash/stratum"
+	"github.com/xelpool/xelishash/stratum/server"
+)
+
+func startBridge(t *testing.T, ctx context.Context, share_difficulty uint64) (*mockdaemon.Daemon, *Bridge, string) {
+	t.Helper()
+
+	daemon, err := mockdaemon.New(mockdaemon.Config{Difficulty: 4096, Algorithm: xelishash.ALGO_DEV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewServer(daemon.Handler())
+	t.Cleanup(upstream.Close)
+
+	bridge, err := New(Config{
+		Upstream: getwork.Config{
+			URL:        "ws" + strings.TrimPrefix(upstream.URL, "http") + "/getwork/xel:solo/bridge",
+			MinBackoff: 10 * time.Millisecond,
+		},
+		Server: server.Config{ShareDifficulty: share_difficulty},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bridge.Serve(ctx, listener)
+	}()
+	t.Cleanup(func() { <-done })
+
+	return daemon, bridge, listener.Addr().String()
+}
+
+func TestBridge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	daemon, bridge, address := startBridge(t, ctx, 256)
+
+	client := stratum.NewClient(stratum.ClientConfig{Address: address, User: "xel:miner.rig0", MinBackoff: 10 * time.Millisecond})
+	go client.Run(ctx)
+
+	engine := miner.NewEngine(1)
+	go engine.Run(ctx)
+	go func() {
+		for {
+			select {
+			case job := <-client.Jobs():
+				engine.SetJob(job)
+			case solution, ok := <-engine.Solutions():
+				if !ok {
+					return
+				}
+				client.Submit(solution)
+			}
+		}
+	}()
+
+	// the stratum miner finds shares at difficulty 256, one in 16 is a block at difficulty 4096
+	deadline := time.Now().Add(30 * time.Second)
+	for daemon.Accepted() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out, stats %+v", bridge.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	stats := bridge.Stats()
+	if stats.Server.Accepted <= stats.Submitted || stats.Server.Rejected != 0 {
+		t.Fatalf("the shares must be validated by the bridge, got %+v", stats)
+	}
+	if stats.Submitted != stats.Server.Blocks || daemon.Accepted()+daemon.Rejected() > stats.Submitted {
+		t.Fatalf("only the blocks must be sent to the daemon, got %+v", stats)
+	}
+	// blocks found by the miner at the same height race each other, the daemon only rejects the late ones
+	if stats.Rejected != 0 && bridge.LastRejection() != mockdaemon.ErrStaleWork.Error() {
+		t.Fatalf("unexpected rejection by the daemon: %s", bridge.LastRejection())
+	}
+}
+
+func TestBridgeDropsInvalidShares(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	daemon, bridge, address := startBridge(t, ctx, 1<<62)
+
+	// wait for the template of the daemon
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		encoder := json.NewEncoder(conn)
+		scanner := bufio.NewScanner(conn)
+		encoder.Encode(stratum.Message{ID: json.RawMessage("1"), Method: stratum.METHOD_SUBSCRIBE, Params: json.RawMessage(`["test"]`)})
+		encoder.Encode(stratum.Message{ID: json.RawMessage("2"), Method: stratum.METHOD_AUTHORIZE, Params: json.RawMessage(`["xel:miner","x"]`)})
+
+		var prefix int
+		var job *stratum.NotifyParams
+		for job == nil && scanner.Scan() {
+			var message stratum.Message
+			if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case string(message.ID) == "1":
+				var result stratum.SubscribeResult
+				json.Unmarshal(message.Result, &result)
+				prefix = len(result.ExtraNoncePrefix)
+			case message.Method == stratum.METHOD_NOTIFY:
+				job = &stratum.NotifyParams{}
+				json.Unmarshal(message.Params, job)
+			}
+		}
+		if job == nil {
+			if time.Now().After(deadline) {
+				t.Fatal("no job from the bridge")
+			}
+			conn.Close()
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		if job.Algorithm != xelishash.ALGO_DEV || prefix != 4 {
+			t.Fatalf("incorrect job %+v with a prefix of %d bytes", job, prefix)
+		}
+
+		// a share missing the share difficulty is answered by the bridge
+		encoder.Encode(stratum.Message{ID: json.RawMessage("3"), Method: stratum.METHOD_SUBMIT, Params: mustMarshal(t, stratum.SubmitParams{
+			User:       "xel:miner",
+			JobID:      job.JobID,
+			ExtraNonce: make([]byte, xelishash.EXTRA_NONCE_SIZE-prefix),
+		})})
+		for scanner.Scan() {
+			var message stratum.Message
+			if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+				t.Fatal(err)
+			}
+			if string(message.ID) != "3" {
+				continue
+			}
+			if message.Error == nil || message.Error.Code != stratum.CODE_LOW_DIFFICULTY {
+				t.Fatalf("expected a low difficulty error, got %+v", message)
+			}
+			break
+		}
+		break
+	}
+
+	if stats := bridge.Stats(); stats.Submitted != 0 || daemon.Rejected() != 0 {
+		t.Fatalf("the invalid share must not reach the daemon, got %+v", stats)
+	}
+}
+
+// TestBridgeErrors checks that the refused jobs and the blocks not sent are counted and reported
+func TestBridgeErrors(t *testing.T) {
+	// the daemon sends a job of an algorithm the stratum server doesn't know
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteJSON(getwork.Message{NewJob: &getwork.NewJob{Algorithm: "xel/unknown", Height: 3, Difficulty: 1}})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	errs := make(chan error, 2)
+	bridge, err := New(Config{
+		Upstream: getwork.Config{URL: "ws" + strings.TrimPrefix(upstream.URL, "http") + "/getwork/xel:solo/bridge"},
+		Server:   server.Config{ShareDifficulty: 1},
+		Errors: func(err error) {
+			errs <- err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bridge.Serve(ctx, listener)
+	}()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, miner.ErrUnknownAlgorithm) {
+			t.Fatalf("got error %v, expected %v", err, miner.ErrUnknownAlgorithm)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the refused job must be reported")
+	}
+	cancel()
+	<-done
+
+	// the daemon is gone, the block can't be sent
+	if err := bridge.submit(server.Share{Height: 3}); !errors.Is(err, getwork.ErrNotConnected) {
+		t.Fatalf("got error %v, expected %v", err, getwork.ErrNotConnected)
+	}
+	if err := <-errs; !errors.Is(err, getwork.ErrNotConnected) || bridge.LastError() != err {
+		t.Fatalf("the failed block must be reported, got %v", err)
+	}
+
+	if stats := bridge.Stats(); stats.InvalidJobs != 1 || stats.Failed != 1 || stats.Submitted != 0 {
+		t.Fatalf("incorrect stats %+v", stats)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) json.RawMessage {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
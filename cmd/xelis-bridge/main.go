@@ -0,0 +1,54 @@
+// Command xelis-bridge lets stratum miners mine on a node only exposing getwork
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/bridge"
+	"github.com/xelpool/xelishash/getwork"
+	"github.com/xelpool/xelishash/stratum/server"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:3333", "stratum address the miners connect to")
+	upstream := flag.String("upstream", "ws://127.0.0.1:8080/getwork/<address>/bridge", "getwork URL of the daemon, with the address paid")
+	share_difficulty := flag.Uint64("share-difficulty", 0, "fixed share difficulty, 0 to adjust it per miner")
+	threads := flag.Int("threads", 1, "threads used to verify the shares")
+	flag.Parse()
+
+	b, err := bridge.New(bridge.Config{
+		Upstream: getwork.Config{URL: *upstream},
+		Server: server.Config{
+			ShareDifficulty: *share_difficulty,
+			Pool:            xelishash.NewThreadPool(*threads),
+		},
+		Errors: func(err error) {
+			log.Print(err)
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	log.Printf("bridge listening on stratum+tcp://%s, upstream %s", *addr, *upstream)
+	if err := b.Serve(ctx, listener); err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
+
+	stats := b.Stats()
+	log.Printf("%d shares accepted, %d blocks submitted, %d accepted, %d rejected, %d failed", stats.Server.Accepted, stats.Submitted, stats.Accepted, stats.Rejected, stats.Failed)
+}
@@ -0,0 +1,54 @@
+// Command xelis-mockd runs a mock XELIS daemon for offline mining tests
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/mockdaemon"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "address to listen on")
+	diff := flag.Uint64("difficulty", 1000, "difficulty of every block")
+	algo := flag.String("algorithm", xelishash.ALGO_V2, "PoW algorithm (xel/0, xel/1, xel/dev)")
+	interval := flag.Duration("interval", 15*time.Second, "new template interval when no block is found, 0 to disable")
+	threads := flag.Int("threads", 1, "threads used to verify the submissions")
+	flag.Parse()
+
+	daemon, err := mockdaemon.New(mockdaemon.Config{
+		Difficulty:    *diff,
+		Algorithm:     *algo,
+		BlockInterval: *interval,
+		Threads:       *threads,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	server := &http.Server{
+		Addr:    *addr,
+		Handler: daemon.Handler(),
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go daemon.Run(ctx)
+
+	log.Printf("mock daemon listening on %s (getwork: ws://%s/getwork/<address>/<worker>, rpc: http://%s/json_rpc)", *addr, *addr, *addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	log.Printf("height %d, %d blocks accepted, %d rejected", daemon.Height(), daemon.Accepted(), daemon.Rejected())
+}
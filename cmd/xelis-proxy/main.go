@@ -0,0 +1,53 @@
+// Command xelis-proxy shares one getwork session with the daemon between many mining rigs
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/getwork"
+	"github.com/xelpool/xelishash/getwork/proxy"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8081", "address the rigs connect to")
+	upstream := flag.String("upstream", "ws://127.0.0.1:8080/getwork/<address>/proxy", "getwork URL of the daemon, with the address paid")
+	threads := flag.Int("threads", 1, "threads used to verify the submissions")
+	flag.Parse()
+
+	p, err := proxy.New(proxy.Config{
+		Upstream: getwork.Config{URL: *upstream},
+		Pool:     xelishash.NewThreadPool(*threads),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           p.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go p.Run(ctx)
+
+	log.Printf("proxy listening on ws://%s/getwork/<address>/<worker>, upstream %s", *addr, *upstream)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	stats := p.Stats()
+	log.Printf("%d blocks forwarded, %d accepted, %d rejected, %d invalid, %d stale", stats.Forwarded, stats.Accepted, stats.Rejected, stats.Invalid, stats.Stale)
+}
@@ -10,9 +10,8 @@ func intInput(input [BYTES_ARRAY_INPUT]byte) *[KECCAK_WORDS]uint64 {
 	return (*[KECCAK_WORDS]uint64)(unsafe.Pointer(&input))
 }
 
-func scratchpadToSmallpad(s *ScratchPad) *[MEMORY_SIZE * 2]uint32 {
-	return (*[MEMORY_SIZE * 2]uint32)(unsafe.Pointer(s))
-
+func scratchpadToSmallpad(s []uint64) []uint32 {
+	return unsafe.Slice((*uint32)(unsafe.Pointer(&s[0])), len(s)*2)
 }
 
 func aesConv(d *[16]byte) *[4]uint32 {
@@ -0,0 +1,106 @@
+// Package dedupe detects replayed shares before they are hashed
+//
+// Shares are identified by the full miner work they rebuild to, so the same
+// (job, extra nonce, nonce) is caught whether it comes back on the same connection or another one
+package dedupe
+
+import (
+	"sync"
+
+	"github.com/xelpool/xelishash"
+)
+
+// Filter remembers the works submitted for the most recent jobs
+// Jobs are forgotten once more than History newer jobs got shares, or when pruned,
+// so the memory is bounded by the lifetime of the jobs
+// It is safe for concurrent use
+type Filter struct {
+	history int
+
+	mu   sync.Mutex
+	jobs map[string]map[xelishash.MinerWork]struct{}
+	// order keeps the job IDs from the oldest to the newest
+	order []string
+}
+
+// NewFilter returns a filter remembering the shares of up to history jobs
+func NewFilter(history int) *Filter {
+	if history < 1 {
+		history = 1
+	}
+
+	return &Filter{
+		history: history,
+		jobs:    make(map[string]map[xelishash.MinerWork]struct{}),
+	}
+}
+
+// Seen records the work for the job and reports whether it was already submitted
+func (f *Filter) Seen(job_id string, work *xelishash.MinerWork) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	works, ok := f.jobs[job_id]
+	if !ok {
+		works = make(map[xelishash.MinerWork]struct{})
+		f.jobs[job_id] = works
+		f.order = append(f.order, job_id)
+
+		for len(f.order) > f.history {
+			delete(f.jobs, f.order[0])
+			f.order = f.order[1:]
+		}
+	}
+
+	if _, ok := works[*work]; ok {
+		return true
+	}
+	works[*work] = struct{}{}
+	return false
+}
+
+// Contains reports whether the work was already submitted for the job, without recording it
+// It lets the caller skip the hash of a replay, the work is then recorded with Seen once validated
+func (f *Filter) Contains(job_id string, work *xelishash.MinerWork) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, ok := f.jobs[job_id][*work]
+	return ok
+}
+
+// Prune forgets the jobs for which keep returns false, typically the jobs gone stale
+func (f *Filter) Prune(keep func(job_id string) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	order := f.order[:0]
+	for _, id := range f.order {
+		if keep(id) {
+			order = append(order, id)
+		} else {
+			delete(f.jobs, id)
+		}
+	}
+	f.order = order
+}
+
+// Jobs returns the number of jobs remembered
+func (f *Filter) Jobs() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.jobs)
+}
+
+// Len returns the number of works remembered across all the jobs
+func (f *Filter) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for _, works := range f.jobs {
+		n += len(works)
+	}
+	return n
+}
@@ -0,0 +1,128 @@
+package dedupe
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xelpool/xelishash"
+)
+
+func testWork(nonce uint64) xelishash.MinerWork {
+	var work xelishash.MinerWork
+	work[0] = 0x42
+	work.SetNonce(nonce)
+	return work
+}
+
+func TestSeen(t *testing.T) {
+	f := NewFilter(4)
+
+	work := testWork(1)
+	if f.Seen("a", &work) {
+		t.Fatal("first submission reported as duplicate")
+	}
+	if !f.Seen("a", &work) {
+		t.Fatal("replay not detected")
+	}
+
+	// the same work for another job is a different share
+	if f.Seen("b", &work) {
+		t.Fatal("jobs must be independent")
+	}
+
+	other := testWork(2)
+	if f.Seen("a", &other) {
+		t.Fatal("another nonce is not a duplicate")
+	}
+
+	extra_nonce := other.ExtraNonce()
+	extra_nonce[31] = 1
+	other.SetExtraNonce(extra_nonce)
+	if f.Seen("a", &other) {
+		t.Fatal("another extra nonce is not a duplicate")
+	}
+
+	if f.Jobs() != 2 || f.Len() != 4 {
+		t.Fatalf("incorrect size %d jobs %d works", f.Jobs(), f.Len())
+	}
+}
+
+func TestContains(t *testing.T) {
+	f := NewFilter(4)
+
+	work := testWork(1)
+	if f.Contains("a", &work) {
+		t.Fatal("unknown work reported as submitted")
+	}
+	// Contains doesn't record the work
+	if f.Contains("a", &work) || f.Seen("a", &work) {
+		t.Fatal("the work must only be recorded by Seen")
+	}
+	if !f.Contains("a", &work) {
+		t.Fatal("recorded work not found")
+	}
+	if f.Jobs() != 1 || f.Len() != 1 {
+		t.Fatalf("incorrect size %d jobs %d works", f.Jobs(), f.Len())
+	}
+}
+
+func TestEviction(t *testing.T) {
+	f := NewFilter(2)
+
+	work := testWork(1)
+	f.Seen("a", &work)
+	f.Seen("b", &work)
+	f.Seen("c", &work)
+
+	if f.Jobs() != 2 {
+		t.Fatalf("expected 2 jobs, got %d", f.Jobs())
+	}
+	// the oldest job got evicted, its shares are forgotten
+	if f.Seen("a", &work) {
+		t.Fatal("evicted job still remembered")
+	}
+	if !f.Seen("c", &work) {
+		t.Fatal("newest job forgotten")
+	}
+
+	f.Prune(func(job_id string) bool { return job_id != "c" })
+	if f.Jobs() != 1 || f.Seen("a", &work) == false {
+		t.Fatal("prune must only forget the selected jobs")
+	}
+	if f.Seen("c", &work) {
+		t.Fatal("pruned job still remembered")
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	f := NewFilter(8)
+
+	// every share is submitted by several connections at once, only one must get through
+	const CONNECTIONS = 8
+	const SHARES = 1000
+
+	var accepted atomic.Int64
+	var wg sync.WaitGroup
+	for c := 0; c < CONNECTIONS; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < SHARES; i++ {
+				work := testWork(uint64(i))
+				if !f.Seen(strconv.Itoa(i%4), &work) {
+					accepted.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted.Load() != SHARES {
+		t.Fatalf("expected %d unique shares, got %d", SHARES, accepted.Load())
+	}
+	if f.Len() != SHARES {
+		t.Fatalf("expected %d works, got %d", SHARES, f.Len())
+	}
+}
@@ -0,0 +1,68 @@
+// Package difficulty converts between XELIS difficulties and 256-bit targets
+//
+// It follows the daemon semantics: the target of a difficulty is
+// (2^256 - 1) / difficulty, and a hash read as a big-endian integer
+// meets the difficulty when it is lower than or equal to the target
+package difficulty
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+var (
+	ErrZeroDifficulty = errors.New("difficulty: difficulty cannot be zero")
+	ErrZeroTarget     = errors.New("difficulty: target cannot be zero")
+)
+
+// DifficultyToTarget returns the highest hash value accepted for the difficulty
+func DifficultyToTarget(difficulty uint64) (Target, error) {
+	if difficulty == 0 {
+		return Target{}, ErrZeroDifficulty
+	}
+
+	// long division of 2^256 - 1 by the difficulty, one limb at a time
+	var target Target
+	var rem uint64
+	for i := 0; i < 4; i++ {
+		target[i], rem = bits.Div64(rem, math.MaxUint64, difficulty)
+	}
+
+	return target, nil
+}
+
+// TargetToDifficulty returns the difficulty matching the target
+// Targets too low to be represented saturate at math.MaxUint64
+func TargetToDifficulty(target Target) (uint64, error) {
+	if target.IsZero() {
+		return 0, ErrZeroTarget
+	}
+
+	difficulty := new(big.Int).Div(MaxTarget.Big(), target.Big())
+	if !difficulty.IsUint64() {
+		return math.MaxUint64, nil
+	}
+	return difficulty.Uint64(), nil
+}
+
+// HashToDifficulty returns the difficulty reached by the hash
+// A zero hash saturates at math.MaxUint64
+func HashToDifficulty(hash [32]byte) uint64 {
+	difficulty, err := TargetToDifficulty(TargetFromBytes(hash))
+	if err != nil {
+		return math.MaxUint64
+	}
+	return difficulty
+}
+
+// CheckDifficulty reports whether the hash meets the difficulty
+// Miners should compute the target once with DifficultyToTarget and use Target.Check instead
+func CheckDifficulty(hash [32]byte, difficulty uint64) (bool, error) {
+	target, err := DifficultyToTarget(difficulty)
+	if err != nil {
+		return false, err
+	}
+	return target.Check(hash), nil
+}
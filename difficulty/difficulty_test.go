@@ -0,0 +1,172 @@
+package difficulty
+
+import (
+	"encoding/hex"
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func hashFromHex(t *testing.T, s string) [32]byte {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		t.Fatalf("invalid hash %s", s)
+	}
+	return [32]byte(b)
+}
+
+func TestDifficultyToTarget(t *testing.T) {
+	if _, err := DifficultyToTarget(0); err != ErrZeroDifficulty {
+		t.Fatalf("got error %v, expected %v", err, ErrZeroDifficulty)
+	}
+
+	target, err := DifficultyToTarget(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != MaxTarget {
+		t.Fatalf("incorrect target for difficulty 1: %s", target)
+	}
+
+	target, _ = DifficultyToTarget(2)
+	if target != (Target{math.MaxUint64 >> 1, math.MaxUint64, math.MaxUint64, math.MaxUint64}) {
+		t.Fatalf("incorrect target for difficulty 2: %s", target)
+	}
+
+	max := MaxTarget.Big()
+	rng := rand.New(rand.NewSource(1))
+	difficulties := []uint64{3, 1000, 1 << 32, math.MaxUint64 - 1, math.MaxUint64}
+	for i := 0; i < 1000; i++ {
+		difficulties = append(difficulties, rng.Uint64()>>uint(rng.Intn(64)))
+	}
+
+	for _, difficulty := range difficulties {
+		if difficulty == 0 {
+			continue
+		}
+		target, err := DifficultyToTarget(difficulty)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := new(big.Int).Div(max, new(big.Int).SetUint64(difficulty))
+		if target.Big().Cmp(expected) != 0 {
+			t.Fatalf("difficulty %d: incorrect target %s, expected %x", difficulty, target, expected)
+		}
+
+		back, err := TargetToDifficulty(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back != difficulty {
+			t.Fatalf("difficulty %d: round trip returned %d", difficulty, back)
+		}
+	}
+}
+
+func TestTargetToDifficulty(t *testing.T) {
+	if _, err := TargetToDifficulty(Target{}); err != ErrZeroTarget {
+		t.Fatalf("got error %v, expected %v", err, ErrZeroTarget)
+	}
+
+	tests := []struct {
+		target     Target
+		difficulty uint64
+	}{
+		{MaxTarget, 1},
+		{Target{1, math.MaxUint64, math.MaxUint64, math.MaxUint64}, 1 << 63},
+		{Target{0, 1, 0, 0}, math.MaxUint64},
+		{Target{0, 0, 0, 1}, math.MaxUint64},
+	}
+
+	for _, test := range tests {
+		difficulty, err := TargetToDifficulty(test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if difficulty != test.difficulty {
+			t.Fatalf("target %s: incorrect difficulty %d, expected %d", test.target, difficulty, test.difficulty)
+		}
+	}
+}
+
+func TestCheckDifficulty(t *testing.T) {
+	tests := []struct {
+		hash       string
+		difficulty uint64
+		valid      bool
+	}{
+		// XelisHashV2 outputs of the test vectors
+		{"7edb70f0748573902728a4691e9e2d7e4043ee34c823a11390d3d6e15fbe921b", 2, true},
+		{"7edb70f0748573902728a4691e9e2d7e4043ee34c823a11390d3d6e15fbe921b", 3, false},
+		{"c7729a1c04a4c4b2751194cb7de43391a2de6acacd37f4b25e1df8f262dd9eb3", 1, true},
+		{"c7729a1c04a4c4b2751194cb7de43391a2de6acacd37f4b25e1df8f262dd9eb3", 2, false},
+		// the target is inclusive
+		{"7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", 2, true},
+		{"8000000000000000000000000000000000000000000000000000000000000000", 2, false},
+		{"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", 1, true},
+		{"0000000000000000000000000000000000000000000000000000000000000000", math.MaxUint64, true},
+		// only the most significant bytes matter for low difficulties
+		{"00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff", 1 << 32, true},
+		{"0000000100000000000000000000000000000000000000000000000000000000", 1 << 32, false},
+	}
+
+	for _, test := range tests {
+		hash := hashFromHex(t, test.hash)
+		valid, err := CheckDifficulty(hash, test.difficulty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != test.valid {
+			t.Fatalf("hash %s with difficulty %d: got %v, expected %v", test.hash, test.difficulty, valid, test.valid)
+		}
+
+		reached := HashToDifficulty(hash)
+		if (reached >= test.difficulty) != test.valid {
+			t.Fatalf("hash %s reached difficulty %d, which disagrees with difficulty %d", test.hash, reached, test.difficulty)
+		}
+	}
+
+	if _, err := CheckDifficulty([32]byte{}, 0); err != ErrZeroDifficulty {
+		t.Fatalf("got error %v, expected %v", err, ErrZeroDifficulty)
+	}
+	if HashToDifficulty([32]byte{}) != math.MaxUint64 {
+		t.Fatal("a zero hash must saturate the difficulty")
+	}
+}
+
+func TestTargetCheck(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 10000; i++ {
+		var a, b [32]byte
+		rng.Read(a[:])
+		copy(b[:], a[:])
+		// only differ in a single byte to exercise every limb
+		b[rng.Intn(32)] = byte(rng.Intn(256))
+
+		target := TargetFromBytes(b)
+		expected := new(big.Int).SetBytes(a[:]).Cmp(target.Big()) <= 0
+		if target.Check(a) != expected {
+			t.Fatalf("hash %x against target %s: got %v, expected %v", a, target, !expected, expected)
+		}
+		if target.Bytes() != b || TargetFromBig(target.Big()) != target {
+			t.Fatalf("incorrect target encoding %s", target)
+		}
+	}
+
+	if TargetFromBig(new(big.Int).Lsh(big.NewInt(1), 256)) != MaxTarget || !TargetFromBig(big.NewInt(-1)).IsZero() {
+		t.Fatal("TargetFromBig must saturate")
+	}
+}
+
+func BenchmarkTargetCheck(b *testing.B) {
+	target, _ := DifficultyToTarget(1 << 40)
+	var hash [32]byte
+	hash[4] = 1
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		target.Check(hash)
+	}
+}
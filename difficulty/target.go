@@ -0,0 +1,91 @@
+package difficulty
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"math/big"
+)
+
+// Target is a 256-bit unsigned integer stored as four u64 limbs,
+// the most significant limb first
+// A hash meets the target when its big-endian value is lower than or equal to it
+type Target [4]uint64
+
+// MaxTarget is the highest possible target, 2^256 - 1, reached with a difficulty of 1
+var MaxTarget = Target{math.MaxUint64, math.MaxUint64, math.MaxUint64, math.MaxUint64}
+
+// TargetFromBytes reads a big-endian 256-bit value, such as a hash
+func TargetFromBytes(b [32]byte) Target {
+	return Target{
+		binary.BigEndian.Uint64(b[0:8]),
+		binary.BigEndian.Uint64(b[8:16]),
+		binary.BigEndian.Uint64(b[16:24]),
+		binary.BigEndian.Uint64(b[24:32]),
+	}
+}
+
+// TargetFromBig returns the target for the given value, saturating at MaxTarget
+// Negative values are treated as zero
+func TargetFromBig(n *big.Int) Target {
+	if n.Sign() <= 0 {
+		return Target{}
+	}
+	if n.BitLen() > 256 {
+		return MaxTarget
+	}
+
+	var b [32]byte
+	n.FillBytes(b[:])
+	return TargetFromBytes(b)
+}
+
+// Bytes returns the big-endian encoding of the target
+func (t Target) Bytes() [32]byte {
+	var b [32]byte
+	binary.BigEndian.PutUint64(b[0:8], t[0])
+	binary.BigEndian.PutUint64(b[8:16], t[1])
+	binary.BigEndian.PutUint64(b[16:24], t[2])
+	binary.BigEndian.PutUint64(b[24:32], t[3])
+	return b
+}
+
+func (t Target) Big() *big.Int {
+	b := t.Bytes()
+	return new(big.Int).SetBytes(b[:])
+}
+
+// String returns the lowercase hex encoding of the big-endian target
+func (t Target) String() string {
+	b := t.Bytes()
+	return hex.EncodeToString(b[:])
+}
+
+func (t Target) IsZero() bool {
+	return t == Target{}
+}
+
+// Cmp returns -1, 0 or 1 depending on whether t is lower than, equal to or greater than other
+func (t Target) Cmp(other Target) int {
+	for i := 0; i < 4; i++ {
+		if t[i] < other[i] {
+			return -1
+		}
+		if t[i] > other[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Check reports whether the big-endian hash is lower than or equal to the target
+// It doesn't allocate, so it can be used in the mining loop
+func (t Target) Check(hash [32]byte) bool {
+	for i := 0; i < 4; i++ {
+		limb := binary.BigEndian.Uint64(hash[i*8:])
+		if limb != t[i] {
+			return limb < t[i]
+		}
+	}
+	return true
+}
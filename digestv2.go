@@ -0,0 +1,57 @@
+package xelishash
+
+import (
+	"hash"
+)
+
+// digestV2 implements hash.Hash for XelisHashV2
+// The input is buffered as stage 1 needs its full hash before processing the chunks
+type digestV2 struct {
+	scratch_pad *ScratchPadV2
+	buffer      []byte
+}
+
+// NewV2 returns a hash.Hash computing XelisHashV2 with the given scratch pad
+// If pad is nil, a new scratch pad is allocated
+// Only some input lengths can be hashed, see Sum
+// The scratch pad must not be shared with a concurrent hashing operation
+func NewV2(pad *ScratchPadV2) hash.Hash {
+	if pad == nil {
+		pad = &ScratchPadV2{}
+	}
+
+	return &digestV2{
+		scratch_pad: pad,
+	}
+}
+
+func (d *digestV2) Write(p []byte) (int, error) {
+	d.buffer = append(d.buffer, p...)
+	return len(p), nil
+}
+
+// Sum appends the hash of the data written so far to b
+// It doesn't change the underlying hash state
+// XelisHashV2 splits the input in CHUNK_SIZE_V2 chunks whose count must divide the scratch pad size in bytes:
+// the miner work (112 bytes) is fine, the empty input or 200 bytes are not.
+// Sum panics with ErrInvalidInputSize on such a length, it doesn't pad as a padded input would share its hash
+func (d *digestV2) Sum(b []byte) []byte {
+	if err := checkInputV2(len(d.buffer), MEMORY_SIZE_V2, CHUNK_SIZE_V2); err != nil {
+		panic(err)
+	}
+
+	hash := XelisHashV2(d.buffer, d.scratch_pad)
+	return append(b, hash[:]...)
+}
+
+func (d *digestV2) Reset() {
+	d.buffer = d.buffer[:0]
+}
+
+func (d *digestV2) Size() int {
+	return HASH_SIZE
+}
+
+func (d *digestV2) BlockSize() int {
+	return CHUNK_SIZE_V2
+}
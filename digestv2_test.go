@@ -0,0 +1,86 @@
+package xelishash
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestDigestV2(t *testing.T) {
+	input := []byte{83, 175, 21, 164, 59, 64, 112, 22, 133, 157, 110, 93, 103, 233, 95, 171, 84, 212, 94, 159, 56, 231, 142, 83, 155, 90, 210, 84, 73, 195, 107, 38, 0, 0, 1, 148, 65, 210, 149, 206, 0, 0, 0, 0, 0, 0, 2, 111, 30, 180, 107, 152, 2, 158, 60, 146, 72, 97, 3, 240, 133, 110, 18, 13, 196, 213, 137, 255, 172, 43, 178, 237, 0, 0, 0, 0, 0, 0, 0, 1, 80, 105, 173, 140, 96, 184, 216, 33, 205, 190, 44, 59, 87, 223, 214, 64, 226, 151, 200, 115, 89, 42, 131, 251, 182, 18, 47, 210, 108, 219, 69, 126}
+	expectedHash := Hash{86, 153, 158, 47, 177, 49, 55, 60, 155, 61, 147, 124, 179, 204, 11, 76, 59, 90, 186, 134, 9, 20, 21, 248, 156, 47, 122, 116, 118, 227, 24, 75}
+
+	h := NewV2(nil)
+	if h.Size() != HASH_SIZE || h.BlockSize() != CHUNK_SIZE_V2 {
+		t.Fatalf("incorrect size %d or block size %d", h.Size(), h.BlockSize())
+	}
+
+	// write in uneven pieces
+	h.Write(input[:5])
+	h.Write(input[5:40])
+	h.Write(input[40:])
+
+	sum := h.Sum([]byte{0xff})
+	if sum[0] != 0xff || !bytes.Equal(sum[1:], expectedHash[:]) {
+		t.Fatalf("incorrect sum: %x, expected: %s", sum, expectedHash)
+	}
+
+	// Sum must not change the state
+	if sum := h.Sum(nil); !bytes.Equal(sum, expectedHash[:]) {
+		t.Fatalf("incorrect second sum: %x, expected: %s", sum, expectedHash)
+	}
+
+	h.Reset()
+	var scratchpad ScratchPadV2
+	zeroHash := XelisHashV2(make([]byte, 112), &scratchpad)
+
+	if _, err := io.Copy(h, bytes.NewReader(make([]byte, 112))); err != nil {
+		t.Fatal(err)
+	}
+	if sum := h.Sum(nil); !bytes.Equal(sum, zeroHash[:]) {
+		t.Fatalf("incorrect sum after reset: %x, expected: %s", sum, zeroHash)
+	}
+}
+
+func TestDigestV2Sizes(t *testing.T) {
+	var scratchpad ScratchPadV2
+	h := NewV2(&scratchpad)
+
+	for _, size := range []int{1, 112, 256} {
+		input := make([]byte, size)
+		for i := range input {
+			input[i] = byte(i)
+		}
+
+		h.Reset()
+		h.Write(input)
+		sum := h.Sum(nil)
+
+		// the result doesn't depend on what the scratch pad held before
+		for i := range scratchpad {
+			scratchpad[i] = ^uint64(i)
+		}
+		if again := h.Sum(nil); !bytes.Equal(sum, again) {
+			t.Fatalf("%d bytes: the sum is not deterministic: %x, %x", size, sum, again)
+		}
+
+		if expected := XelisHashV2(input, &ScratchPadV2{}); !bytes.Equal(sum, expected[:]) {
+			t.Fatalf("%d bytes: incorrect sum %x, expected %s", size, sum, expected)
+		}
+	}
+
+	// 0 and 200 bytes can't be split, padding them would give them the hash of another input
+	for _, size := range []int{0, 200} {
+		func() {
+			defer func() {
+				if r := recover(); r != ErrInvalidInputSize {
+					t.Fatalf("%d bytes: got panic %v, expected %v", size, r, ErrInvalidInputSize)
+				}
+			}()
+
+			h.Reset()
+			h.Write(make([]byte, size))
+			h.Sum(nil)
+		}()
+	}
+}
@@ -0,0 +1,159 @@
+package xelishash
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/xelpool/xelishash/difficulty"
+)
+
+// Network names, as reported by the XELIS daemon
+const (
+	NETWORK_MAINNET = "mainnet"
+	NETWORK_TESTNET = "testnet"
+	NETWORK_DEVNET  = "devnet"
+)
+
+// Heights of the xelis-hash v2 hard fork
+const (
+	MAINNET_V2_HEIGHT = 434_100
+	TESTNET_V2_HEIGHT = 6
+)
+
+var (
+	ErrInvalidForkSchedule = errors.New("xelishash: invalid fork schedule")
+	ErrUnknownNetwork      = errors.New("xelishash: unknown network")
+)
+
+// Fork switches the PoW to the registered Algorithm from Height on
+type Fork struct {
+	Height    uint64
+	Algorithm string
+}
+
+// ForkSchedule maps block heights to the PoW algorithm in force
+// It is safe for concurrent use
+type ForkSchedule struct {
+	forks []Fork
+
+	// hashers keeps a pool of hashers per algorithm, as they are not safe for concurrent use
+	mu      sync.Mutex
+	hashers map[string]*sync.Pool
+}
+
+// NewForkSchedule returns the schedule of the forks, the first one must start at height 0
+// Every algorithm must already be registered, see RegisterAlgorithm
+func NewForkSchedule(forks ...Fork) (*ForkSchedule, error) {
+	forks = append([]Fork(nil), forks...)
+	sort.SliceStable(forks, func(i, j int) bool {
+		return forks[i].Height < forks[j].Height
+	})
+
+	if len(forks) == 0 || forks[0].Height != 0 {
+		return nil, ErrInvalidForkSchedule
+	}
+	for i, fork := range forks {
+		if i > 0 && fork.Height == forks[i-1].Height {
+			return nil, ErrInvalidForkSchedule
+		}
+		if _, ok := lookupAlgorithm(fork.Algorithm); !ok {
+			return nil, ErrUnknownAlgorithm
+		}
+	}
+
+	return &ForkSchedule{forks: forks, hashers: make(map[string]*sync.Pool)}, nil
+}
+
+func mustForkSchedule(forks ...Fork) *ForkSchedule {
+	s, err := NewForkSchedule(forks...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// MainnetForks returns the schedule of the XELIS mainnet
+func MainnetForks() *ForkSchedule {
+	return mustForkSchedule(Fork{0, ALGO_V1}, Fork{MAINNET_V2_HEIGHT, ALGO_V2})
+}
+
+// TestnetForks returns the schedule of the XELIS testnet
+func TestnetForks() *ForkSchedule {
+	return mustForkSchedule(Fork{0, ALGO_V1}, Fork{TESTNET_V2_HEIGHT, ALGO_V2})
+}
+
+// DevnetForks returns the schedule of a local devnet, where every fork is active from the genesis
+func DevnetForks() *ForkSchedule {
+	return mustForkSchedule(Fork{0, ALGO_V2})
+}
+
+// NetworkForks returns the schedule of the network named as by the daemon
+func NetworkForks(network string) (*ForkSchedule, error) {
+	switch network {
+	case NETWORK_MAINNET:
+		return MainnetForks(), nil
+	case NETWORK_TESTNET:
+		return TestnetForks(), nil
+	case NETWORK_DEVNET:
+		return DevnetForks(), nil
+	}
+	return nil, ErrUnknownNetwork
+}
+
+// Forks returns the forks sorted by height
+func (s *ForkSchedule) Forks() []Fork {
+	return append([]Fork(nil), s.forks...)
+}
+
+// Algorithm returns the algorithm of the block at the height
+func (s *ForkSchedule) Algorithm(height uint64) string {
+	i := sort.Search(len(s.forks), func(i int) bool {
+		return s.forks[i].Height > height
+	})
+	return s.forks[i-1].Algorithm
+}
+
+// Hash computes the PoW hash of the miner work of the block at the height
+// xel/0 hashes the work padded to BYTES_ARRAY_INPUT bytes, every other algorithm hashes it as is
+func (s *ForkSchedule) Hash(height uint64, work *MinerWork) (Hash, error) {
+	algo := s.Algorithm(height)
+
+	s.mu.Lock()
+	pool, ok := s.hashers[algo]
+	if !ok {
+		pool = &sync.Pool{}
+		s.hashers[algo] = pool
+	}
+	s.mu.Unlock()
+
+	hasher, ok := pool.Get().(Hasher)
+	if !ok {
+		var err error
+		if hasher, err = NewHasher(algo); err != nil {
+			return Hash{}, err
+		}
+	}
+	defer pool.Put(hasher)
+
+	input := work[:]
+	if algo == ALGO_V1 {
+		v1 := work.V1()
+		input = v1[:]
+	}
+	return hasher.Hash(input)
+}
+
+// VerifyBlockPoW reports whether the PoW hash of the miner work meets the difficulty,
+// with the algorithm in force at the height
+func (s *ForkSchedule) VerifyBlockPoW(height uint64, work *MinerWork, diff uint64) (bool, error) {
+	if diff == 0 {
+		return false, difficulty.ErrZeroDifficulty
+	}
+
+	hash, err := s.Hash(height, work)
+	if err != nil {
+		return false, err
+	}
+	return difficulty.CheckDifficulty(hash, diff)
+}
@@ -0,0 +1,106 @@
+package xelishash
+
+import (
+	"testing"
+
+	"github.com/xelpool/xelishash/difficulty"
+)
+
+func TestForkSchedule(t *testing.T) {
+	mainnet, err := NetworkForks(NETWORK_MAINNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		schedule *ForkSchedule
+		height   uint64
+		algo     string
+	}{
+		{mainnet, 0, ALGO_V1},
+		{mainnet, MAINNET_V2_HEIGHT - 1, ALGO_V1},
+		{mainnet, MAINNET_V2_HEIGHT, ALGO_V2},
+		{mainnet, 1 << 40, ALGO_V2},
+		{TestnetForks(), TESTNET_V2_HEIGHT - 1, ALGO_V1},
+		{TestnetForks(), TESTNET_V2_HEIGHT, ALGO_V2},
+		{DevnetForks(), 0, ALGO_V2},
+	}
+	for _, test := range tests {
+		if algo := test.schedule.Algorithm(test.height); algo != test.algo {
+			t.Errorf("height %d: got %s, expected %s", test.height, algo, test.algo)
+		}
+	}
+
+	if _, err := NetworkForks("stagenet"); err != ErrUnknownNetwork {
+		t.Fatalf("expected %v, got %v", ErrUnknownNetwork, err)
+	}
+}
+
+func TestForkScheduleInvalid(t *testing.T) {
+	tests := [][]Fork{
+		nil,
+		{{Height: 10, Algorithm: ALGO_V2}},
+		{{Height: 0, Algorithm: ALGO_V1}, {Height: 0, Algorithm: ALGO_V2}},
+	}
+	for _, forks := range tests {
+		if _, err := NewForkSchedule(forks...); err != ErrInvalidForkSchedule {
+			t.Errorf("%+v: expected %v, got %v", forks, ErrInvalidForkSchedule, err)
+		}
+	}
+
+	if _, err := NewForkSchedule(Fork{0, ALGO_V2}, Fork{100, "xel/unregistered"}); err != ErrUnknownAlgorithm {
+		t.Fatalf("expected %v, got %v", ErrUnknownAlgorithm, err)
+	}
+}
+
+func TestVerifyBlockPoW(t *testing.T) {
+	input := []byte{83, 175, 21, 164, 59, 64, 112, 22, 133, 157, 110, 93, 103, 233, 95, 171, 84, 212, 94, 159, 56, 231, 142, 83, 155, 90, 210, 84, 73, 195, 107, 38, 0, 0, 1, 148, 65, 210, 149, 206, 0, 0, 0, 0, 0, 0, 2, 111, 30, 180, 107, 152, 2, 158, 60, 146, 72, 97, 3, 240, 133, 110, 18, 13, 196, 213, 137, 255, 172, 43, 178, 237, 0, 0, 0, 0, 0, 0, 0, 1, 80, 105, 173, 140, 96, 184, 216, 33, 205, 190, 44, 59, 87, 223, 214, 64, 226, 151, 200, 115, 89, 42, 131, 251, 182, 18, 47, 210, 108, 219, 69, 126}
+	expectedHash := Hash{86, 153, 158, 47, 177, 49, 55, 60, 155, 61, 147, 124, 179, 204, 11, 76, 59, 90, 186, 134, 9, 20, 21, 248, 156, 47, 122, 116, 118, 227, 24, 75}
+	work, _ := ParseMinerWork(input)
+
+	mainnet := MainnetForks()
+	if hash, err := mainnet.Hash(MAINNET_V2_HEIGHT, &work); err != nil || hash != expectedHash {
+		t.Fatalf("got %s, %v, expected %s", hash, err, expectedHash)
+	}
+
+	// the difficulty reached by the hash passes, the next one fails
+	reached := difficulty.HashToDifficulty(expectedHash)
+	if ok, err := mainnet.VerifyBlockPoW(MAINNET_V2_HEIGHT, &work, reached); err != nil || !ok {
+		t.Fatalf("got %v, %v at difficulty %d", ok, err, reached)
+	}
+	if ok, err := mainnet.VerifyBlockPoW(MAINNET_V2_HEIGHT, &work, reached+1); err != nil || ok {
+		t.Fatalf("got %v, %v at difficulty %d", ok, err, reached+1)
+	}
+	if _, err := mainnet.VerifyBlockPoW(MAINNET_V2_HEIGHT, &work, 0); err != difficulty.ErrZeroDifficulty {
+		t.Fatalf("expected %v, got %v", difficulty.ErrZeroDifficulty, err)
+	}
+
+	// before the fork the work is padded and hashed with xel/0
+	tp := NewThreadPool(1)
+	v1 := work.V1()
+	if hash, err := mainnet.Hash(MAINNET_V2_HEIGHT-1, &work); err != nil || hash != tp.XelisHash(v1[:]) {
+		t.Fatalf("got %s, %v, expected the xel/0 hash", hash, err)
+	}
+}
+
+func TestForkScheduleFutureAlgorithm(t *testing.T) {
+	// xel/test is registered in hasher_test.go
+	schedule, err := NewForkSchedule(Fork{0, ALGO_DEV}, Fork{10, "xel/test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var work MinerWork
+	work.SetNonce(1)
+	hash, err := schedule.Hash(10, &work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := FakeHasher{}.Hash(work[:])
+	if hash != expected {
+		t.Fatalf("got %s, expected the hash of the registered algorithm %s", hash, expected)
+	}
+
+	if hash, _ := schedule.Hash(9, &work); hash != NewThreadPool(1).XelisHashDev(work[:]) {
+		t.Fatalf("got %s, expected the xel/dev hash", hash)
+	}
+}
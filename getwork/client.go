@@ -0,0 +1,240 @@
+// Package getwork implements a client for the getwork WebSocket endpoint of the XELIS daemon
+package getwork
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/xelpool/xelishash/difficulty"
+	"github.com/xelpool/xelishash/miner"
+)
+
+var ErrNotConnected = errors.New("getwork: not connected")
+
+// Job is a miner job built from a new job message
+type Job struct {
+	miner.Job
+	Height     uint64
+	TopoHeight uint64
+	Difficulty uint64
+}
+
+// Result is the answer of the daemon to a submission
+type Result struct {
+	Accepted bool
+	// Reason is set when the block got rejected
+	Reason string
+	// Session numbers the connection the answer came from, see SubmitSession
+	Session uint64
+}
+
+type Config struct {
+	// URL of the endpoint, such as ws://127.0.0.1:8080/getwork/<address>/<worker>
+	URL string
+	// Dialer defaults to websocket.DefaultDialer
+	Dialer *websocket.Dialer
+	// MinBackoff is the delay before the first reconnection attempt, doubled after every failure up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Disconnected is called with the session number when a connection drops,
+	// the submissions of that session will never be answered
+	Disconnected func(session uint64)
+}
+
+// Client keeps a connection to the daemon open, reconnecting with backoff when it drops
+type Client struct {
+	config  Config
+	jobs    chan Job
+	results chan Result
+
+	mu      sync.Mutex
+	conn    *websocket.Conn
+	next_id uint64
+	// session is the number of the current connection, incremented on every connection
+	session uint64
+}
+
+func NewClient(config Config) *Client {
+	if config.Dialer == nil {
+		config.Dialer = websocket.DefaultDialer
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = time.Second
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = 30 * config.MinBackoff
+	}
+
+	return &Client{
+		config:  config,
+		jobs:    make(chan Job, 1),
+		results: make(chan Result, RESULTS_BUFFER),
+	}
+}
+
+// Jobs returns the channel receiving the jobs pushed by the daemon
+// A job not received before the next one arrives is dropped
+func (c *Client) Jobs() <-chan Job {
+	return c.jobs
+}
+
+// RESULTS_BUFFER is the number of answers kept for a consumer that doesn't receive them
+const RESULTS_BUFFER = 16
+
+// Results returns the channel receiving the answers to the submissions, in order
+// Once RESULTS_BUFFER answers are waiting, the oldest one is dropped for the next,
+// so a consumer ignoring the answers never stops the jobs
+func (c *Client) Results() <-chan Result {
+	return c.results
+}
+
+// Run connects to the daemon and reads its messages until the context is cancelled
+func (c *Client) Run(ctx context.Context) error {
+	backoff := c.config.MinBackoff
+	for {
+		conn, _, err := c.config.Dialer.DialContext(ctx, c.config.URL, nil)
+		if err == nil {
+			backoff = c.config.MinBackoff
+			c.serve(ctx, conn)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > c.config.MaxBackoff {
+			backoff = c.config.MaxBackoff
+		}
+	}
+}
+
+// serve reads the messages of the connection until it fails or the context is done
+func (c *Client) serve(ctx context.Context, conn *websocket.Conn) {
+	c.mu.Lock()
+	c.conn = conn
+	c.session++
+	session := c.session
+	c.mu.Unlock()
+
+	// unblock the read once the context is done
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	defer func() {
+		close(done)
+		conn.Close()
+
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+
+		if c.config.Disconnected != nil {
+			c.config.Disconnected(session)
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		// malformed messages are skipped
+		var message Message
+		if err := json.Unmarshal(data, &message); err != nil {
+			continue
+		}
+
+		if message.NewJob != nil {
+			job, err := c.job(message.NewJob)
+			if err != nil {
+				continue
+			}
+			// only the latest job matters, replace the one not received yet
+			select {
+			case <-c.jobs:
+			default:
+			}
+			c.jobs <- job
+			continue
+		}
+
+		result := Result{Accepted: message.BlockAccepted, Session: session}
+		if message.BlockRejected != nil {
+			result.Reason = *message.BlockRejected
+		}
+		// the read loop is the only sender, so after dropping the oldest answer the send can't block
+		select {
+		case c.results <- result:
+		default:
+			select {
+			case <-c.results:
+			default:
+			}
+			c.results <- result
+		}
+	}
+}
+
+func (c *Client) job(message *NewJob) (Job, error) {
+	target, err := difficulty.DifficultyToTarget(uint64(message.Difficulty))
+	if err != nil {
+		return Job{}, err
+	}
+
+	c.mu.Lock()
+	c.next_id++
+	id := c.next_id
+	c.mu.Unlock()
+
+	return Job{
+		Job: miner.Job{
+			ID:        strconv.FormatUint(id, 16),
+			Work:      message.MinerWork,
+			Algorithm: message.Algorithm,
+			Target:    target,
+		},
+		Height:     message.Height,
+		TopoHeight: message.TopoHeight,
+		Difficulty: uint64(message.Difficulty),
+	}, nil
+}
+
+// Submit sends the work of the solution to the daemon
+// The answer is received on the Results channel
+func (c *Client) Submit(solution miner.Solution) error {
+	_, err := c.SubmitSession(solution)
+	return err
+}
+
+// SubmitSession is like Submit, but also returns the session number of the connection used
+// The answers carry no identifier, the session tells the answers of a dropped connection
+// from the ones of the next
+func (c *Client) SubmitSession(solution miner.Solution) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return 0, ErrNotConnected
+	}
+	return c.session, c.conn.WriteJSON(SubmitMinerWork{MinerWork: solution.Work})
+}
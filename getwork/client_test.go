@@ -0,0 +1,268 @@
+package getwork
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/difficulty"
+	"github.com/xelpool/xelishash/miner"
+)
+
+func TestMessageJSON(t *testing.T) {
+	var message Message
+	if err := json.Unmarshal([]byte(`"block_accepted"`), &message); err != nil || !message.BlockAccepted {
+		t.Fatalf("incorrect block accepted message: %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"block_rejected":"invalid pow"}`), &message); err != nil || *message.BlockRejected != "invalid pow" {
+		t.Fatalf("incorrect block rejected message: %v", err)
+	}
+
+	work := strings.Repeat("00", xelishash.MINER_WORK_SIZE)
+	for _, diff := range []string{`1000`, `"1000"`} {
+		data := `{"new_job":{"algorithm":"xel/1","miner_work":"` + work + `","height":5,"topoheight":6,"difficulty":` + diff + `}}`
+		if err := json.Unmarshal([]byte(data), &message); err != nil {
+			t.Fatal(err)
+		}
+		if message.NewJob.Difficulty != 1000 || message.NewJob.Height != 5 || message.NewJob.TopoHeight != 6 {
+			t.Fatalf("incorrect new job %+v", message.NewJob)
+		}
+	}
+
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Message
+	if err := json.Unmarshal(encoded, &decoded); err != nil || *decoded.NewJob != *message.NewJob {
+		t.Fatalf("incorrect round trip %s: %v", encoded, err)
+	}
+
+	for _, data := range []string{`"unknown"`, `{}`, `{"new_job":null}`} {
+		if err := json.Unmarshal([]byte(data), &message); err == nil {
+			t.Fatalf("message %s must be rejected", data)
+		}
+	}
+}
+
+// daemon is a getwork endpoint sending one job per connection
+// and checking the submissions against the job difficulty
+type daemon struct {
+	connections atomic.Int32
+	pool        *xelishash.ThreadPool
+}
+
+func (d *daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	n := d.connections.Add(1)
+
+	var work xelishash.MinerWork
+	work[0] = byte(n)
+	job := &NewJob{
+		Algorithm:  xelishash.ALGO_DEV,
+		MinerWork:  work,
+		Height:     uint64(n),
+		TopoHeight: uint64(n),
+		Difficulty: 4,
+	}
+	if err := conn.WriteJSON(Message{NewJob: job}); err != nil {
+		return
+	}
+
+	for {
+		var submit SubmitMinerWork
+		if err := conn.ReadJSON(&submit); err != nil {
+			return
+		}
+
+		// the first connection is dropped after a submission
+		if n == 1 {
+			return
+		}
+
+		hash := d.pool.XelisHashDev(submit.MinerWork[:])
+		valid, _ := difficulty.CheckDifficulty(hash, uint64(job.Difficulty))
+		if valid {
+			conn.WriteJSON(Message{BlockAccepted: true})
+		} else {
+			reason := "invalid pow"
+			conn.WriteJSON(Message{BlockRejected: &reason})
+		}
+	}
+}
+
+func receiveJob(t *testing.T, client *Client) Job {
+	t.Helper()
+
+	select {
+	case job := <-client.Jobs():
+		return job
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a job")
+	}
+	return Job{}
+}
+
+func receiveResult(t *testing.T, client *Client) Result {
+	t.Helper()
+
+	select {
+	case result := <-client.Results():
+		return result
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a result")
+	}
+	return Result{}
+}
+
+func TestClient(t *testing.T) {
+	d := &daemon{pool: xelishash.NewThreadPool(1)}
+	server := httptest.NewServer(d)
+	defer server.Close()
+
+	disconnected := make(chan uint64, 2)
+	client := NewClient(Config{
+		URL:        "ws" + strings.TrimPrefix(server.URL, "http") + "/getwork/xel:address/worker",
+		MinBackoff: 10 * time.Millisecond,
+		Disconnected: func(session uint64) {
+			disconnected <- session
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- client.Run(ctx)
+	}()
+
+	job := receiveJob(t, client)
+	if job.Height != 1 || job.Difficulty != 4 || job.Algorithm != xelishash.ALGO_DEV || job.Work[0] != 1 {
+		t.Fatalf("incorrect job %+v", job)
+	}
+	expected, _ := difficulty.DifficultyToTarget(4)
+	if job.Target != expected {
+		t.Fatalf("incorrect target %s", job.Target)
+	}
+
+	// the daemon drops the connection, the client reconnects and receives a new job
+	if err := client.Submit(miner.Solution{Work: job.Work}); err != nil {
+		t.Fatal(err)
+	}
+	job = receiveJob(t, client)
+	if job.Height != 2 || d.connections.Load() != 2 {
+		t.Fatalf("incorrect job %+v after reconnection", job)
+	}
+	if session := <-disconnected; session != 1 {
+		t.Fatalf("session %d disconnected, expected 1", session)
+	}
+
+	// find a valid and an invalid solution
+	var valid, invalid *xelishash.MinerWork
+	for nonce := uint64(0); valid == nil || invalid == nil; nonce++ {
+		work := job.Work
+		work.SetNonce(nonce)
+		if job.Target.Check(d.pool.XelisHashDev(work[:])) {
+			valid = &work
+		} else {
+			invalid = &work
+		}
+	}
+
+	session, err := client.SubmitSession(miner.Solution{Work: *valid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := receiveResult(t, client); !result.Accepted || result.Session != session || session != 2 {
+		t.Fatalf("incorrect result %+v of session %d", result, session)
+	}
+
+	if err := client.Submit(miner.Solution{Work: *invalid}); err != nil {
+		t.Fatal(err)
+	}
+	if result := receiveResult(t, client); result.Accepted || result.Reason != "invalid pow" {
+		t.Fatalf("incorrect result %+v", result)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("got error %v, expected %v", err, context.Canceled)
+	}
+	if err := client.Submit(miner.Solution{}); err != ErrNotConnected {
+		t.Fatalf("got error %v, expected %v", err, ErrNotConnected)
+	}
+	if session := <-disconnected; session != 2 {
+		t.Fatalf("session %d disconnected, expected 2", session)
+	}
+}
+
+func TestClientBackoff(t *testing.T) {
+	// nothing listens on this server once closed
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	server.Close()
+
+	client := NewClient(Config{
+		URL:        url,
+		MinBackoff: 20 * time.Millisecond,
+		MaxBackoff: 40 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := client.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) < 150*time.Millisecond {
+		t.Fatal("client stopped before the context was done")
+	}
+}
+
+func TestClientIgnoredResults(t *testing.T) {
+	// the daemon answers more submissions than the buffer holds, then sends a job
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for i := 0; i < RESULTS_BUFFER*2; i++ {
+			reason := strings.Repeat("x", i)
+			conn.WriteJSON(Message{BlockRejected: &reason})
+		}
+		conn.WriteJSON(Message{NewJob: &NewJob{Algorithm: xelishash.ALGO_DEV, Height: 7, Difficulty: 1}})
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	client := NewClient(Config{URL: "ws" + strings.TrimPrefix(server.URL, "http")})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if job := receiveJob(t, client); job.Height != 7 {
+		t.Fatalf("incorrect job %+v", job)
+	}
+
+	// only the latest answers are kept
+	if result := receiveResult(t, client); result.Reason != strings.Repeat("x", RESULTS_BUFFER) {
+		t.Fatalf("expected the oldest kept answer, got %q", result.Reason)
+	}
+}
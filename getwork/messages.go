@@ -0,0 +1,109 @@
+package getwork
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/xelpool/xelishash"
+)
+
+var ErrInvalidMessage = errors.New("getwork: invalid message")
+
+// Difficulty is sent by the daemon either as a JSON number or as a decimal string
+// It is always encoded as a string
+type Difficulty uint64
+
+func (d Difficulty) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatUint(uint64(d), 10))
+}
+
+func (d *Difficulty) UnmarshalJSON(data []byte) error {
+	var s string
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	} else {
+		s = string(data)
+	}
+
+	value, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*d = Difficulty(value)
+	return nil
+}
+
+// NewJob is the work pushed by the daemon
+type NewJob struct {
+	Algorithm  string              `json:"algorithm"`
+	MinerWork  xelishash.MinerWork `json:"miner_work"`
+	Height     uint64              `json:"height"`
+	TopoHeight uint64              `json:"topoheight"`
+	Difficulty Difficulty          `json:"difficulty"`
+}
+
+// Message is one of the messages sent by the daemon:
+// a new job, "block_accepted" or a rejection with its reason
+type Message struct {
+	NewJob        *NewJob
+	BlockAccepted bool
+	// BlockRejected holds the reason of the rejection, if the block got rejected
+	BlockRejected *string
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	switch {
+	case m.NewJob != nil:
+		return json.Marshal(struct {
+			NewJob *NewJob `json:"new_job"`
+		}{m.NewJob})
+	case m.BlockAccepted:
+		return json.Marshal("block_accepted")
+	case m.BlockRejected != nil:
+		return json.Marshal(struct {
+			BlockRejected string `json:"block_rejected"`
+		}{*m.BlockRejected})
+	}
+	return nil, ErrInvalidMessage
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		if s != "block_accepted" {
+			return ErrInvalidMessage
+		}
+		*m = Message{BlockAccepted: true}
+		return nil
+	}
+
+	var raw struct {
+		NewJob        *NewJob `json:"new_job"`
+		BlockRejected *string `json:"block_rejected"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if (raw.NewJob == nil) == (raw.BlockRejected == nil) {
+		return ErrInvalidMessage
+	}
+
+	*m = Message{
+		NewJob:        raw.NewJob,
+		BlockRejected: raw.BlockRejected,
+	}
+	return nil
+}
+
+// SubmitMinerWork is sent by the miner with the work meeting the block difficulty
+type SubmitMinerWork struct {
+	MinerWork xelishash.MinerWork `json:"miner_work"`
+}
@@ -0,0 +1,391 @@
+// Package proxy multiplexes many getwork miners onto a single getwork session with the daemon
+//
+// Every downstream miner gets the upstream template with its own extra nonce prefix,
+// so the miners never search the same space. Submissions are verified locally against
+// the network target and only the valid blocks are forwarded to the daemon.
+// The blocks are paid to the address of the upstream session, the address in the downstream path is ignored.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/dedupe"
+	"github.com/xelpool/xelishash/getwork"
+	"github.com/xelpool/xelishash/miner"
+)
+
+// Reasons sent to the downstream miners in block_rejected messages
+const (
+	REASON_NO_JOB         = "no job"
+	REASON_STALE          = "stale work"
+	REASON_FOREIGN_WORK   = "work not issued to this miner"
+	REASON_DUPLICATE      = "duplicate work"
+	REASON_LOW_DIFFICULTY = "hash does not meet the difficulty"
+	REASON_UPSTREAM       = "upstream unavailable"
+)
+
+var (
+	ErrUpstreamTimeout = errors.New("proxy: no answer from the daemon")
+	ErrUpstreamLost    = errors.New("proxy: connection to the daemon lost before its answer")
+)
+
+type Config struct {
+	// Upstream is the getwork session with the daemon, its Disconnected hook is set by the proxy
+	Upstream getwork.Config
+	// Pool verifies the submissions, defaults to a pool with a single thread
+	Pool *xelishash.ThreadPool
+	// ExtraNonceBase is written before the prefix of every miner,
+	// PrefixSize bytes are then allocated per miner (defaults to 4)
+	ExtraNonceBase []byte
+	PrefixSize     int
+	// SubmitTimeout is how long a forwarded block waits for the answer of the daemon, defaults to 10 seconds
+	SubmitTimeout time.Duration
+}
+
+// Stats counts the submissions of the downstream miners
+type Stats struct {
+	// Forwarded blocks, then Accepted and Rejected by the daemon
+	Forwarded uint64
+	Accepted  uint64
+	Rejected  uint64
+	// Invalid submissions dropped by the proxy
+	Invalid uint64
+	Stale   uint64
+}
+
+// Proxy holds the upstream session and serves the downstream miners
+type Proxy struct {
+	config    Config
+	upstream  *getwork.Client
+	prefixes  *miner.PrefixAllocator
+	submitted *dedupe.Filter
+
+	forwarded atomic.Uint64
+	accepted  atomic.Uint64
+	rejected  atomic.Uint64
+	invalid   atomic.Uint64
+	stale     atomic.Uint64
+
+	mu sync.Mutex
+	// job is nil until the daemon sends the first one
+	job        *getwork.Job
+	downstream map[*downstream]struct{}
+
+	// submit_mu keeps the forwarded blocks in the order of their answers
+	submit_mu sync.Mutex
+	pending   []*pendingBlock
+}
+
+// pendingBlock is a forwarded block waiting for the answer of the daemon
+// The answer channel is closed without a result if the connection drops first
+// A block that stopped waiting stays queued, its buffered channel takes the late answer so the next blocks get their own
+// The answers carry no identifier: one the daemon never sends shifts the next ones until the connection drops
+type pendingBlock struct {
+	session uint64
+	answer  chan getwork.Result
+}
+
+type downstream struct {
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	prefix []byte
+}
+
+func (d *downstream) send(message getwork.Message) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.conn.WriteJSON(message)
+}
+
+func New(config Config) (*Proxy, error) {
+	if config.Pool == nil {
+		config.Pool = xelishash.NewThreadPool(1)
+	}
+	if config.PrefixSize == 0 {
+		config.PrefixSize = 4
+	}
+	if config.SubmitTimeout <= 0 {
+		config.SubmitTimeout = 10 * time.Second
+	}
+
+	prefixes, err := miner.NewPrefixAllocator(config.ExtraNonceBase, config.PrefixSize)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		config:     config,
+		prefixes:   prefixes,
+		submitted:  dedupe.NewFilter(4),
+		downstream: make(map[*downstream]struct{}),
+	}
+	config.Upstream.Disconnected = p.disconnected
+	p.upstream = getwork.NewClient(config.Upstream)
+
+	return p, nil
+}
+
+func (p *Proxy) Stats() Stats {
+	return Stats{
+		Forwarded: p.forwarded.Load(),
+		Accepted:  p.accepted.Load(),
+		Rejected:  p.rejected.Load(),
+		Invalid:   p.invalid.Load(),
+		Stale:     p.stale.Load(),
+	}
+}
+
+// Job returns the current upstream job, if any
+func (p *Proxy) Job() (getwork.Job, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.job == nil {
+		return getwork.Job{}, false
+	}
+	return *p.job, true
+}
+
+// Run keeps the upstream session open and rebroadcasts its jobs until the context is done
+func (p *Proxy) Run(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- p.upstream.Run(ctx)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return <-done
+		case job := <-p.upstream.Jobs():
+			p.setJob(job)
+		case result := <-p.upstream.Results():
+			p.answer(result)
+		}
+	}
+}
+
+func (p *Proxy) setJob(job getwork.Job) {
+	p.mu.Lock()
+	p.job = &job
+	downstream := make([]*downstream, 0, len(p.downstream))
+	for d := range p.downstream {
+		downstream = append(downstream, d)
+	}
+	p.mu.Unlock()
+
+	for _, d := range downstream {
+		d.send(getwork.Message{NewJob: newJob(&job, d.prefix)})
+	}
+}
+
+// newJob builds the job message of a miner, with its prefix written in the extra nonce
+func newJob(job *getwork.Job, prefix []byte) *getwork.NewJob {
+	work := job.Work
+	extra_nonce := work.ExtraNonce()
+	copy(extra_nonce[:], prefix)
+	work.SetExtraNonce(extra_nonce)
+
+	return &getwork.NewJob{
+		Algorithm:  job.Algorithm,
+		MinerWork:  work,
+		Height:     job.Height,
+		TopoHeight: job.TopoHeight,
+		Difficulty: getwork.Difficulty(job.Difficulty),
+	}
+}
+
+// answer hands the result of the daemon to the oldest block forwarded on the same connection
+// A result left from a dropped connection has no block waiting for it anymore and is ignored
+func (p *Proxy) answer(result getwork.Result) {
+	p.submit_mu.Lock()
+	defer p.submit_mu.Unlock()
+
+	// the blocks of the previous connections will never be answered
+	p.fail(result.Session - 1)
+
+	if len(p.pending) == 0 || p.pending[0].session != result.Session {
+		return
+	}
+	p.pending[0].answer <- result
+	p.pending = p.pending[1:]
+}
+
+// disconnected fails the blocks forwarded on the dropped connection
+func (p *Proxy) disconnected(session uint64) {
+	p.submit_mu.Lock()
+	defer p.submit_mu.Unlock()
+
+	p.fail(session)
+}
+
+// fail closes the answers of the blocks forwarded up to the given session, submit_mu must be held
+func (p *Proxy) fail(session uint64) {
+	for len(p.pending) > 0 && p.pending[0].session <= session {
+		close(p.pending[0].answer)
+		p.pending = p.pending[1:]
+	}
+}
+
+// Handler serves the downstream miners on /getwork/<address>/<worker>
+func (p *Proxy) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/getwork/", p.serveGetwork)
+	return mux
+}
+
+func (p *Proxy) serveGetwork(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/getwork/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.Error(w, "expected /getwork/<address>/<worker>", http.StatusBadRequest)
+		return
+	}
+
+	prefix, err := p.prefixes.Next()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	d := &downstream{conn: conn, prefix: prefix}
+
+	p.mu.Lock()
+	p.downstream[d] = struct{}{}
+	job := p.job
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.downstream, d)
+		p.mu.Unlock()
+	}()
+
+	if job != nil {
+		if err := d.send(getwork.Message{NewJob: newJob(job, prefix)}); err != nil {
+			return
+		}
+	}
+
+	for {
+		var submit getwork.SubmitMinerWork
+		if err := conn.ReadJSON(&submit); err != nil {
+			return
+		}
+
+		message := getwork.Message{BlockAccepted: true}
+		if reason := p.submit(r.Context(), d, &submit.MinerWork); reason != "" {
+			message = getwork.Message{BlockRejected: &reason}
+		}
+		if err := d.send(message); err != nil {
+			return
+		}
+	}
+}
+
+// submit verifies the work of a miner and forwards it to the daemon
+// It returns the reason of the rejection, empty if the daemon accepted the block
+func (p *Proxy) submit(ctx context.Context, d *downstream, work *xelishash.MinerWork) string {
+	p.mu.Lock()
+	job := p.job
+	p.mu.Unlock()
+
+	if job == nil {
+		p.invalid.Add(1)
+		return REASON_NO_JOB
+	}
+	if work.WorkHash() != job.Work.WorkHash() {
+		p.stale.Add(1)
+		return REASON_STALE
+	}
+
+	extra_nonce := work.ExtraNonce()
+	if work.PublicKey() != job.Work.PublicKey() || string(extra_nonce[:len(d.prefix)]) != string(d.prefix) {
+		p.invalid.Add(1)
+		return REASON_FOREIGN_WORK
+	}
+
+	if p.submitted.Contains(job.ID, work) {
+		p.invalid.Add(1)
+		return REASON_DUPLICATE
+	}
+
+	input := work[:]
+	if job.Algorithm == xelishash.ALGO_V1 {
+		v1 := work.V1()
+		input = v1[:]
+	}
+	hash, err := p.config.Pool.HashContext(ctx, job.Algorithm, input)
+	if err != nil {
+		return err.Error()
+	}
+	// the work is only recorded once hashed, so a rig can send it again after a failed hash
+	if p.submitted.Seen(job.ID, work) {
+		p.invalid.Add(1)
+		return REASON_DUPLICATE
+	}
+	if !job.Target.Check(hash) {
+		p.invalid.Add(1)
+		return REASON_LOW_DIFFICULTY
+	}
+
+	result, err := p.forward(ctx, work)
+	if err != nil {
+		return REASON_UPSTREAM
+	}
+	if !result.Accepted {
+		p.rejected.Add(1)
+		return result.Reason
+	}
+	p.accepted.Add(1)
+	return ""
+}
+
+// forward sends the block to the daemon and waits for its answer
+func (p *Proxy) forward(ctx context.Context, work *xelishash.MinerWork) (getwork.Result, error) {
+	block := &pendingBlock{answer: make(chan getwork.Result, 1)}
+
+	p.submit_mu.Lock()
+	session, err := p.upstream.SubmitSession(miner.Solution{Nonce: work.Nonce(), Work: *work})
+	if err == nil {
+		block.session = session
+		p.pending = append(p.pending, block)
+	}
+	p.submit_mu.Unlock()
+
+	if err != nil {
+		return getwork.Result{}, err
+	}
+	p.forwarded.Add(1)
+
+	timer := time.NewTimer(p.config.SubmitTimeout)
+	defer timer.Stop()
+
+	select {
+	case result, ok := <-block.answer:
+		if !ok {
+			return getwork.Result{}, ErrUpstreamLost
+		}
+		return result, nil
+	case <-ctx.Done():
+		return getwork.Result{}, ctx.Err()
+	case <-timer.C:
+		return getwork.Result{}, ErrUpstreamTimeout
+	}
+}
@@ -0,0 +1,401 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/getwork"
+	"github.com/xelpool/xelishash/miner"
+	"github.com/xelpool/xelishash/mockdaemon"
+)
+
+func wsURL(server *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + path
+}
+
+func startProxy(t *testing.T, ctx context.Context) (*mockdaemon.Daemon, *Proxy, *httptest.Server) {
+	t.Helper()
+
+	daemon, err := mockdaemon.New(mockdaemon.Config{Difficulty: 16, Algorithm: xelishash.ALGO_DEV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewServer(daemon.Handler())
+	t.Cleanup(upstream.Close)
+
+	proxy, err := New(Config{
+		Upstream: getwork.Config{URL: wsURL(upstream, "/getwork/xel:pool/proxy"), MinBackoff: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Run(ctx)
+
+	downstream := httptest.NewServer(proxy.Handler())
+	t.Cleanup(downstream.Close)
+
+	// wait for the upstream job before the miners connect
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, ok := proxy.Job(); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no upstream job")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return daemon, proxy, downstream
+}
+
+func receiveJob(t *testing.T, client *getwork.Client) getwork.Job {
+	t.Helper()
+
+	select {
+	case job := <-client.Jobs():
+		return job
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a job")
+	}
+	return getwork.Job{}
+}
+
+func receiveResult(t *testing.T, client *getwork.Client) getwork.Result {
+	t.Helper()
+
+	select {
+	case result := <-client.Results():
+		return result
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a result")
+	}
+	return getwork.Result{}
+}
+
+func TestProxy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	daemon, proxy, downstream := startProxy(t, ctx)
+
+	// two rigs share the upstream session
+	const RIGS = 2
+	clients := make([]*getwork.Client, RIGS)
+	engines := make([]*miner.Engine, RIGS)
+	for i := range clients {
+		clients[i] = getwork.NewClient(getwork.Config{URL: wsURL(downstream, "/getwork/xel:miner/rig"+string(rune('0'+i)))})
+		go clients[i].Run(ctx)
+
+		engines[i] = miner.NewEngine(1)
+		go engines[i].Run(ctx)
+	}
+
+	for height := uint64(1); height <= 4; height++ {
+		jobs := make([]getwork.Job, RIGS)
+		for i, client := range clients {
+			jobs[i] = receiveJob(t, client)
+			if jobs[i].Height != height || jobs[i].Difficulty != 16 {
+				t.Fatalf("rig %d: incorrect job %+v at height %d", i, jobs[i], height)
+			}
+			if err := engines[i].SetJob(jobs[i].Job); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// same template, different extra nonce slices
+		if jobs[0].Work.WorkHash() != jobs[1].Work.WorkHash() {
+			t.Fatal("the rigs must mine the same template")
+		}
+		a, b := jobs[0].Work.ExtraNonce(), jobs[1].Work.ExtraNonce()
+		if string(a[:4]) == string(b[:4]) {
+			t.Fatal("the rigs must get distinct extra nonce prefixes")
+		}
+
+		// the rigs take turns finding the block
+		rig := int(height) % RIGS
+		var solution miner.Solution
+		for solution.JobID != jobs[rig].ID {
+			select {
+			case solution = <-engines[rig].Solutions():
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for a solution")
+			}
+		}
+		if err := clients[rig].Submit(solution); err != nil {
+			t.Fatal(err)
+		}
+		if result := receiveResult(t, clients[rig]); !result.Accepted {
+			t.Fatalf("block rejected: %s", result.Reason)
+		}
+	}
+
+	if daemon.Accepted() != 4 || daemon.Rejected() != 0 {
+		t.Fatalf("the daemon got %d blocks and rejected %d", daemon.Accepted(), daemon.Rejected())
+	}
+	if stats := proxy.Stats(); stats != (Stats{Forwarded: 4, Accepted: 4}) {
+		t.Fatalf("incorrect stats %+v", stats)
+	}
+}
+
+func TestProxyRejects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	daemon, proxy, downstream := startProxy(t, ctx)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(downstream, "/getwork/xel:miner/rig0"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	read := func() getwork.Message {
+		t.Helper()
+		var message getwork.Message
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+	submit := func(work xelishash.MinerWork, reason string) {
+		t.Helper()
+		if err := conn.WriteJSON(getwork.SubmitMinerWork{MinerWork: work}); err != nil {
+			t.Fatal(err)
+		}
+		message := read()
+		if message.BlockRejected == nil || *message.BlockRejected != reason {
+			t.Fatalf("expected %q, got %+v", reason, message)
+		}
+	}
+
+	job := read().NewJob
+	if job == nil {
+		t.Fatal("expected a job")
+	}
+
+	// find a nonce missing the network target
+	work := job.MinerWork
+	for nonce := uint64(0); ; nonce++ {
+		work.SetNonce(nonce)
+		if job, _ := proxy.Job(); !job.Target.Check(daemon.Hash(work)) {
+			break
+		}
+	}
+	submit(work, REASON_LOW_DIFFICULTY)
+	submit(work, REASON_DUPLICATE)
+
+	// the slice of another rig
+	foreign := work
+	extra_nonce := foreign.ExtraNonce()
+	extra_nonce[0] ^= 0xff
+	foreign.SetExtraNonce(extra_nonce)
+	submit(foreign, REASON_FOREIGN_WORK)
+
+	// a new template makes the previous work stale
+	daemon.NewTemplate()
+	if next := read().NewJob; next == nil || next.Height != 2 {
+		t.Fatalf("expected the new template, got %+v", next)
+	}
+	work.SetNonce(1 << 40)
+	submit(work, REASON_STALE)
+
+	// nothing reached the daemon
+	if daemon.Rejected() != 0 {
+		t.Fatalf("invalid work forwarded, the daemon rejected %d blocks", daemon.Rejected())
+	}
+	if stats := proxy.Stats(); stats != (Stats{Invalid: 3, Stale: 1}) {
+		t.Fatalf("incorrect stats %+v", stats)
+	}
+}
+
+// scriptedDaemon sends the same job on every connection and handles each submission
+// with the next action: reject it after the delay, close the connection, or reject it with the action as the reason
+type scriptedDaemon struct {
+	actions chan string
+	delay   time.Duration
+}
+
+const (
+	ACTION_LATE  = "late"
+	ACTION_CLOSE = "close"
+)
+
+func (d *scriptedDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var work xelishash.MinerWork
+	work[0] = 0x42
+	// every hash meets difficulty 1, all the submissions are forwarded
+	job := &getwork.NewJob{Algorithm: xelishash.ALGO_DEV, MinerWork: work, Height: 1, Difficulty: 1}
+	if err := conn.WriteJSON(getwork.Message{NewJob: job}); err != nil {
+		return
+	}
+
+	for {
+		var submit getwork.SubmitMinerWork
+		if err := conn.ReadJSON(&submit); err != nil {
+			return
+		}
+		switch action := <-d.actions; action {
+		case ACTION_LATE:
+			time.Sleep(d.delay)
+			conn.WriteJSON(getwork.Message{BlockRejected: &action})
+		case ACTION_CLOSE:
+			return
+		default:
+			conn.WriteJSON(getwork.Message{BlockRejected: &action})
+		}
+	}
+}
+
+// rig is a downstream miner speaking the protocol directly
+type rig struct {
+	t    *testing.T
+	conn *websocket.Conn
+	work xelishash.MinerWork
+}
+
+func dialRig(t *testing.T, downstream *httptest.Server, name string) *rig {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(downstream, "/getwork/xel:miner/"+name), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	r := &rig{t: t, conn: conn}
+	if r.read().NewJob == nil {
+		t.Fatal("expected a job")
+	}
+	return r
+}
+
+// read returns the next message, the work of a job is kept for the next submissions
+func (r *rig) read() getwork.Message {
+	r.t.Helper()
+
+	var message getwork.Message
+	r.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if err := r.conn.ReadJSON(&message); err != nil {
+		r.t.Fatal(err)
+	}
+	if message.NewJob != nil {
+		r.work = message.NewJob.MinerWork
+	}
+	return message
+}
+
+// submit sends the work with the nonce and returns the reason of the rejection
+func (r *rig) submit(nonce uint64) string {
+	r.t.Helper()
+
+	work := r.work
+	work.SetNonce(nonce)
+	if err := r.conn.WriteJSON(getwork.SubmitMinerWork{MinerWork: work}); err != nil {
+		r.t.Fatal(err)
+	}
+	for {
+		message := r.read()
+		if message.NewJob != nil {
+			continue
+		}
+		if message.BlockRejected == nil {
+			r.t.Fatalf("expected a rejection, got %+v", message)
+		}
+		return *message.BlockRejected
+	}
+}
+
+// TestProxyPending checks that the answers reach the right rig
+// when the daemon answers after the timeout or drops the connection
+func TestProxyPending(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a late answer comes after the timeout of its block, but before the one of the block forwarded next
+	const TIMEOUT = time.Second
+	daemon := &scriptedDaemon{actions: make(chan string, 1), delay: TIMEOUT * 3 / 2}
+	upstream := httptest.NewServer(daemon)
+	defer upstream.Close()
+
+	proxy, err := New(Config{
+		Upstream:      getwork.Config{URL: wsURL(upstream, "/getwork/xel:pool/proxy"), MinBackoff: 10 * time.Millisecond},
+		SubmitTimeout: TIMEOUT,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Run(ctx)
+
+	downstream := httptest.NewServer(proxy.Handler())
+	defer downstream.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, ok := proxy.Job(); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no upstream job")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	a := dialRig(t, downstream, "a")
+	b := dialRig(t, downstream, "b")
+
+	// the answer to a comes after its timeout, it must not be given to b
+	daemon.actions <- ACTION_LATE
+	if reason := a.submit(1); reason != REASON_UPSTREAM {
+		t.Fatalf("expected %q, got %q", REASON_UPSTREAM, reason)
+	}
+	daemon.actions <- "for b"
+	if reason := b.submit(2); reason != "for b" {
+		t.Fatalf("the answer to b went elsewhere, got %q", reason)
+	}
+
+	// a dropped connection fails the blocks waiting on it without waiting for the timeout
+	job, _ := proxy.Job()
+	daemon.actions <- ACTION_CLOSE
+	start := time.Now()
+	if reason := a.submit(3); reason != REASON_UPSTREAM {
+		t.Fatalf("expected %q, got %q", REASON_UPSTREAM, reason)
+	}
+	if elapsed := time.Since(start); elapsed >= TIMEOUT/2 {
+		t.Fatalf("the block waited %s after the connection dropped", elapsed)
+	}
+
+	// the job of the new connection tells the reconnection
+	deadline = time.Now().Add(10 * time.Second)
+	for {
+		if next, _ := proxy.Job(); next.ID != job.ID {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no reconnection")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	daemon.actions <- ACTION_LATE
+	if reason := a.submit(4); reason != REASON_UPSTREAM {
+		t.Fatalf("expected %q, got %q", REASON_UPSTREAM, reason)
+	}
+	daemon.actions <- "for b again"
+	if reason := b.submit(5); reason != "for b again" {
+		t.Fatalf("the answer to b went elsewhere, got %q", reason)
+	}
+}
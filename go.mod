@@ -4,6 +4,7 @@ go 1.20.0
 
 require (
 	github.com/chocolatkey/chacha8 v0.0.0-20200308092524-06a0ce7f6716
+	github.com/gorilla/websocket v1.5.3
 	github.com/zeebo/blake3 v0.2.3
 	lukechampine.com/uint128 v1.3.0
 )
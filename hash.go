@@ -0,0 +1,69 @@
+package xelishash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"math/bits"
+)
+
+// Hash is the output of both XelisHash algorithms
+// Its numeric value is read as a big-endian 256-bit integer,
+// which is how XELIS compares it against the difficulty target
+type Hash [HASH_SIZE]byte
+
+var ErrInvalidHashLength = errors.New("xelishash: invalid hash length")
+
+// String returns the lowercase hex encoding of the hash
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// MarshalText encodes the hash as hex, so it can be used directly in JSON payloads
+func (h Hash) MarshalText() ([]byte, error) {
+	out := make([]byte, HASH_SIZE*2)
+	hex.Encode(out, h[:])
+	return out, nil
+}
+
+// UnmarshalText decodes a hex encoded hash of exactly HASH_SIZE bytes
+func (h *Hash) UnmarshalText(text []byte) error {
+	if len(text) != HASH_SIZE*2 {
+		return ErrInvalidHashLength
+	}
+
+	var decoded Hash
+	if _, err := hex.Decode(decoded[:], text); err != nil {
+		return err
+	}
+	*h = decoded
+
+	return nil
+}
+
+// Compare returns -1, 0 or 1 depending on whether h is numerically
+// lower than, equal to or greater than other
+func (h Hash) Compare(other Hash) int {
+	return bytes.Compare(h[:], other[:])
+}
+
+// LeadingZeroBits returns the number of leading zero bits of the hash
+func (h Hash) LeadingZeroBits() int {
+	for i, b := range h {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return HASH_SIZE * 8
+}
+
+// Big returns the hash as a big-endian unsigned integer
+func (h Hash) Big() *big.Int {
+	return new(big.Int).SetBytes(h[:])
+}
+
+// IsZero reports whether every byte of the hash is zero
+func (h Hash) IsZero() bool {
+	return h == Hash{}
+}
@@ -0,0 +1,78 @@
+package xelishash
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestHashText(t *testing.T) {
+	var scratchpad ScratchPadV2
+	hash := XelisHashV2(make([]byte, 112), &scratchpad)
+
+	expected := "7edb70f0748573902728a4691e9e2d7e4043ee34c823a11390d3d6e15fbe921b"
+	if hash.String() != expected {
+		t.Fatalf("incorrect string: %s, expected: %s", hash.String(), expected)
+	}
+
+	encoded, err := json.Marshal(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != `"`+expected+`"` {
+		t.Fatalf("incorrect json: %s", encoded)
+	}
+
+	var decoded Hash
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != hash {
+		t.Fatalf("incorrect decoded hash: %s, expected: %s", decoded, hash)
+	}
+
+	if err := decoded.UnmarshalText([]byte("7edb")); err != ErrInvalidHashLength {
+		t.Fatalf("expected ErrInvalidHashLength, got %v", err)
+	}
+	if err := decoded.UnmarshalText([]byte(expected[:62] + "zz")); err == nil {
+		t.Fatal("expected an error for invalid hex")
+	}
+	if decoded != hash {
+		t.Fatal("failed unmarshal must not modify the hash")
+	}
+}
+
+func TestHashNumeric(t *testing.T) {
+	var zero, one, max Hash
+	one[HASH_SIZE-1] = 1
+	for i := range max {
+		max[i] = 0xff
+	}
+
+	if !zero.IsZero() || one.IsZero() {
+		t.Fatal("incorrect IsZero")
+	}
+	if zero.Compare(one) != -1 || one.Compare(zero) != 1 || max.Compare(max) != 0 {
+		t.Fatal("incorrect Compare")
+	}
+
+	// the first byte is the most significant one
+	var high Hash
+	high[0] = 1
+	if high.Compare(max) != -1 || high.Compare(one) != 1 {
+		t.Fatal("incorrect Compare ordering")
+	}
+
+	if zero.LeadingZeroBits() != 256 || one.LeadingZeroBits() != 255 ||
+		high.LeadingZeroBits() != 7 || max.LeadingZeroBits() != 0 {
+		t.Fatal("incorrect LeadingZeroBits")
+	}
+
+	if one.Big().Cmp(big.NewInt(1)) != 0 {
+		t.Fatalf("incorrect Big: %s", one.Big())
+	}
+	expected := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	if max.Big().Cmp(expected) != 0 {
+		t.Fatalf("incorrect Big: %s", max.Big())
+	}
+}
@@ -0,0 +1,110 @@
+package xelishash
+
+import (
+	"errors"
+	"sync"
+	"unsafe"
+
+	"github.com/zeebo/blake3"
+)
+
+// Algorithm names, as used by the XELIS daemon
+const (
+	ALGO_V1  = "xel/0"
+	ALGO_V2  = "xel/1"
+	ALGO_DEV = "xel/dev"
+)
+
+var ErrUnknownAlgorithm = errors.New("xelishash: unknown algorithm")
+
+// Hasher computes the PoW hash of an input
+// Implementations are not expected to be safe for concurrent use
+type Hasher interface {
+	Hash(input []byte) (Hash, error)
+}
+
+// FakeHasher is a deterministic Hasher for tests
+// It returns the blake3 hash of the input without any memory hard stage
+type FakeHasher struct{}
+
+func (FakeHasher) Hash(input []byte) (Hash, error) {
+	return blake3.Sum256(input), nil
+}
+
+// algorithm is an entry of the registry
+type algorithm struct {
+	factory func() (Hasher, error)
+	// pooled hashes with a scratch pad of a ThreadPool, it is only set for the built-in algorithms
+	pooled func(input []byte, scratch_pad *ScratchPadV2) (Hash, error)
+}
+
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = map[string]*algorithm{
+		ALGO_V1: {
+			factory: func() (Hasher, error) {
+				return NewHasherV1(DefaultParamsV1())
+			},
+			pooled: func(input []byte, scratch_pad *ScratchPadV2) (Hash, error) {
+				if len(input) != BYTES_ARRAY_INPUT {
+					return Hash{}, ErrInvalidInputSize
+				}
+				return XelisHash(input, (*ScratchPad)(unsafe.Pointer(scratch_pad))), nil
+			},
+		},
+		ALGO_V2: {
+			factory: func() (Hasher, error) {
+				return NewHasherV2(DefaultParamsV2())
+			},
+			pooled: func(input []byte, scratch_pad *ScratchPadV2) (Hash, error) {
+				if err := checkInputV2(len(input), MEMORY_SIZE_V2, CHUNK_SIZE_V2); err != nil {
+					return Hash{}, err
+				}
+				return XelisHashV2(input, scratch_pad), nil
+			},
+		},
+		ALGO_DEV: {
+			factory: func() (Hasher, error) {
+				return NewHasherV2(DevParamsV2())
+			},
+			pooled: func(input []byte, scratch_pad *ScratchPadV2) (Hash, error) {
+				if err := checkInputV2(len(input), MEMORY_SIZE_DEV, CHUNK_SIZE_V2); err != nil {
+					return Hash{}, err
+				}
+				return XelisHashDev(input, (*ScratchPadDev)(unsafe.Pointer(scratch_pad))), nil
+			},
+		},
+	}
+)
+
+// RegisterAlgorithm makes a Hasher available under the given name
+// It panics if the factory is nil or the name is already registered, built-in algorithms included
+func RegisterAlgorithm(name string, factory func() (Hasher, error)) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+
+	if factory == nil {
+		panic("xelishash: RegisterAlgorithm factory is nil")
+	}
+	if _, dup := algorithms[name]; dup {
+		panic("xelishash: RegisterAlgorithm called twice for algorithm " + name)
+	}
+	algorithms[name] = &algorithm{factory: factory}
+}
+
+func lookupAlgorithm(name string) (*algorithm, bool) {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	a, ok := algorithms[name]
+	return a, ok
+}
+
+// NewHasher returns a new Hasher for the algorithm registered under the given name
+func NewHasher(name string) (Hasher, error) {
+	a, ok := lookupAlgorithm(name)
+	if !ok {
+		return nil, ErrUnknownAlgorithm
+	}
+	return a.factory()
+}
@@ -0,0 +1,90 @@
+package xelishash
+
+import (
+	"testing"
+)
+
+func TestNewHasher(t *testing.T) {
+	tp := NewThreadPool(1)
+	input := make([]byte, BYTES_ARRAY_INPUT)
+
+	tests := []struct {
+		algo  string
+		input []byte
+	}{
+		{ALGO_V1, input},
+		{ALGO_V2, input[:112]},
+		{ALGO_DEV, input[:112]},
+	}
+
+	for _, test := range tests {
+		hasher, err := NewHasher(test.algo)
+		if err != nil {
+			t.Fatalf("%s: %v", test.algo, err)
+		}
+
+		hash, err := hasher.Hash(test.input)
+		if err != nil {
+			t.Fatalf("%s: %v", test.algo, err)
+		}
+		if expected := tp.Hash(test.algo, test.input); hash != expected {
+			t.Fatalf("%s: incorrect hash: %s, expected: %s", test.algo, hash, expected)
+		}
+	}
+
+	if _, err := NewHasher("xel/unknown"); err != ErrUnknownAlgorithm {
+		t.Fatalf("got error %v, expected %v", err, ErrUnknownAlgorithm)
+	}
+}
+
+// the registry can't be reset, the test algorithms are registered once
+func init() {
+	for _, name := range []string{"xel/fake", "xel/pooled", "xel/test"} {
+		RegisterAlgorithm(name, func() (Hasher, error) {
+			return FakeHasher{}, nil
+		})
+	}
+}
+
+func TestRegisterAlgorithm(t *testing.T) {
+	hasher, err := NewHasher("xel/fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := []byte("xelis-hashing-algorithm")
+	hash, err := hasher.Hash(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := FakeHasher{}.Hash(input)
+	if hash != again || hash.IsZero() {
+		t.Fatalf("fake hasher is not deterministic: %s, %s", hash, again)
+	}
+}
+
+func TestRegisterAlgorithmDuplicate(t *testing.T) {
+	factory := func() (Hasher, error) {
+		return FakeHasher{}, nil
+	}
+
+	for _, name := range []string{ALGO_V1, ALGO_V2, ALGO_DEV, "xel/fake"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s: registered twice without a panic", name)
+				}
+			}()
+			RegisterAlgorithm(name, factory)
+		}()
+	}
+
+	// the built-in algorithm is left untouched
+	hasher, err := NewHasher(ALGO_V1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hasher.(*HasherV1); !ok {
+		t.Fatalf("got %T, expected *HasherV1", hasher)
+	}
+}
@@ -0,0 +1,68 @@
+package xelishash_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/difficulty"
+)
+
+// MAINNET_BLOCKS holds mainnet blocks with the miner work of their header, as captured from a daemon
+// At least one block before and one after the xelis-hash v2 fork are needed to cover both algorithms
+const MAINNET_BLOCKS = "testdata/mainnet_blocks.json"
+
+type mainnetBlock struct {
+	Height     uint64              `json:"height"`
+	Hash       xelishash.Hash      `json:"hash"`
+	MinerWork  xelishash.MinerWork `json:"miner_work"`
+	Difficulty json.Number         `json:"difficulty"`
+}
+
+func TestMainnetBlocks(t *testing.T) {
+	data, err := os.ReadFile(MAINNET_BLOCKS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []mainnetBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) == 0 {
+		t.Skipf("no mainnet block captured in %s", MAINNET_BLOCKS)
+	}
+
+	forks := xelishash.MainnetForks()
+	algorithms := make(map[string]bool)
+	for _, block := range blocks {
+		diff, err := block.Difficulty.Int64()
+		if err != nil || diff <= 0 {
+			t.Fatalf("block %s: invalid difficulty %q", block.Hash, block.Difficulty)
+		}
+
+		// the block hash is the blake3 hash of the miner work, whatever the algorithm
+		if hash := block.MinerWork.BlockHash(); hash != block.Hash {
+			t.Errorf("block at height %d: miner work hashes to %s, expected %s", block.Height, hash, block.Hash)
+		}
+
+		pow, err := forks.Hash(block.Height, &block.MinerWork)
+		if err != nil {
+			t.Fatalf("block %s: %v", block.Hash, err)
+		}
+		algorithms[forks.Algorithm(block.Height)] = true
+
+		// the block met its difficulty, with the algorithm of its height
+		if valid, _ := difficulty.CheckDifficulty(pow, uint64(diff)); !valid {
+			t.Errorf("block %s at height %d (%s): PoW hash %s doesn't meet difficulty %d", block.Hash, block.Height, forks.Algorithm(block.Height), pow, diff)
+		}
+		if reached := difficulty.HashToDifficulty(pow); reached < uint64(diff) {
+			t.Errorf("block %s: reached difficulty %d, below %d", block.Hash, reached, diff)
+		}
+	}
+	for _, algo := range []string{xelishash.ALGO_V1, xelishash.ALGO_V2} {
+		if !algorithms[algo] {
+			t.Errorf("no mainnet block hashed with %s in %s", algo, MAINNET_BLOCKS)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package xelishash
+
+import (
+	"math"
+
+	"github.com/xelpool/xelishash/difficulty"
+)
+
+// MineRangeV2 searches count nonces starting at start for a xel/1 hash meeting the target
+// The nonce is written in place in the work, so on success it holds the winning nonce
+// The scratch pad is reused for every attempt and the search returns early once stop is closed
+// tried is the number of hashes computed, including the winning one
+// The range ends at the last nonce, math.MaxUint64, it doesn't wrap to zero
+func MineRangeV2(work *MinerWork, start, count uint64, target difficulty.Target, pad *ScratchPadV2, stop <-chan struct{}) (nonce uint64, hash Hash, found bool, tried uint64) {
+	return mineRange(work.SetNonce, func() Hash {
+		return XelisHashV2(work[:], pad)
+	}, start, count, target, stop)
+}
+
+// MineRangeV1 is the xel/0 equivalent of MineRangeV2
+func MineRangeV1(work *MinerWorkV1, start, count uint64, target difficulty.Target, pad *ScratchPad, stop <-chan struct{}) (nonce uint64, hash Hash, found bool, tried uint64) {
+	return mineRange(work.Work().SetNonce, func() Hash {
+		return XelisHash(work[:], pad)
+	}, start, count, target, stop)
+}
+
+// MineRangeDev is the xel/dev equivalent of MineRangeV2
+func MineRangeDev(work *MinerWork, start, count uint64, target difficulty.Target, pad *ScratchPadDev, stop <-chan struct{}) (nonce uint64, hash Hash, found bool, tried uint64) {
+	return mineRange(work.SetNonce, func() Hash {
+		return XelisHashDev(work[:], pad)
+	}, start, count, target, stop)
+}
+
+// mineRange is the search loop shared by the algorithms, set writes the nonce hashed by hash
+func mineRange(set func(uint64), hash func() Hash, start, count uint64, target difficulty.Target, stop <-chan struct{}) (nonce uint64, result Hash, found bool, tried uint64) {
+	// only true for a start above zero, so the number of nonces left fits
+	if count > math.MaxUint64-start {
+		count = math.MaxUint64 - start + 1
+	}
+
+	for tried < count {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		nonce = start + tried
+		set(nonce)
+		result = hash()
+		tried++
+
+		if target.Check(result) {
+			found = true
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,135 @@
+package xelishash
+
+import (
+	"math"
+	"testing"
+
+	"github.com/xelpool/xelishash/difficulty"
+)
+
+func TestMineRangeV2(t *testing.T) {
+	var work MinerWork
+	var pad ScratchPadV2
+
+	target, err := difficulty.DifficultyToTarget(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce, hash, found, tried := MineRangeV2(&work, 100, 200, target, &pad, nil)
+	if !found {
+		t.Fatalf("no nonce found after %d tries", tried)
+	}
+	if nonce != 100+tried-1 || work.Nonce() != nonce {
+		t.Fatalf("incorrect nonce %d after %d tries, work nonce %d", nonce, tried, work.Nonce())
+	}
+	if !target.Check(hash) {
+		t.Fatalf("hash %s does not meet the target", hash)
+	}
+	if expected := XelisHashV2(work[:], &pad); hash != expected {
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expected)
+	}
+
+	// every nonce before the winning one must fail the target
+	check := work
+	for n := uint64(100); n < nonce; n++ {
+		check.SetNonce(n)
+		if h := XelisHashV2(check[:], &pad); target.Check(h) {
+			t.Fatalf("nonce %d was skipped", n)
+		}
+	}
+}
+
+func TestMineRangeV2Exhausted(t *testing.T) {
+	var work MinerWork
+	var pad ScratchPadV2
+
+	// nothing can meet a zero target
+	nonce, _, found, tried := MineRangeV2(&work, 7, 5, difficulty.Target{}, &pad, nil)
+	if found || tried != 5 || nonce != 11 || work.Nonce() != 11 {
+		t.Fatalf("incorrect result: nonce %d, found %v, tried %d", nonce, found, tried)
+	}
+}
+
+func TestMineRangeV2Stop(t *testing.T) {
+	var work MinerWork
+	var pad ScratchPadV2
+
+	stop := make(chan struct{})
+	close(stop)
+
+	_, _, found, tried := MineRangeV2(&work, 0, 1000, difficulty.MaxTarget, &pad, stop)
+	if found || tried != 0 {
+		t.Fatalf("search must stop immediately, found %v after %d tries", found, tried)
+	}
+}
+
+func TestMineRangeV1(t *testing.T) {
+	var work MinerWork
+	v1 := work.V1()
+	var pad ScratchPad
+
+	target, err := difficulty.DifficultyToTarget(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce, hash, found, tried := MineRangeV1(&v1, 0, 200, target, &pad, nil)
+	if !found {
+		t.Fatalf("no nonce found after %d tries", tried)
+	}
+	if v1.Work().Nonce() != nonce {
+		t.Fatalf("incorrect work nonce %d, expected %d", v1.Work().Nonce(), nonce)
+	}
+	if expected := XelisHash(v1[:], &pad); hash != expected || !target.Check(hash) {
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expected)
+	}
+}
+
+func TestMineRangeDev(t *testing.T) {
+	var work MinerWork
+	var pad ScratchPadDev
+
+	target, err := difficulty.DifficultyToTarget(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce, hash, found, tried := MineRangeDev(&work, 0, 1000, target, &pad, nil)
+	if !found || nonce != tried-1 || work.Nonce() != nonce {
+		t.Fatalf("incorrect search: nonce %d, found %v after %d tries", nonce, found, tried)
+	}
+	if expected := XelisHashDev(work[:], &pad); hash != expected || !target.Check(hash) {
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expected)
+	}
+
+	// a closed stop channel prevents any hash
+	stop := make(chan struct{})
+	close(stop)
+	if _, _, found, tried := MineRangeDev(&work, 0, 1000, target, &pad, stop); found || tried != 0 {
+		t.Fatalf("the search must stop, found %v after %d tries", found, tried)
+	}
+}
+
+func TestMineRangeLastNonce(t *testing.T) {
+	var work MinerWork
+	var pad ScratchPadDev
+
+	// the range is cut at math.MaxUint64 instead of wrapping to nonce 0
+	nonce, _, found, tried := MineRangeDev(&work, math.MaxUint64-2, 10, difficulty.Target{}, &pad, nil)
+	if found || tried != 3 || nonce != math.MaxUint64 || work.Nonce() != math.MaxUint64 {
+		t.Fatalf("incorrect result: nonce %d, found %v, tried %d", nonce, found, tried)
+	}
+
+	if nonce, _, _, tried := MineRangeDev(&work, math.MaxUint64, math.MaxUint64, difficulty.Target{}, &pad, nil); tried != 1 || nonce != math.MaxUint64 {
+		t.Fatalf("incorrect result: nonce %d, tried %d", nonce, tried)
+	}
+}
+
+func BenchmarkMineRangeV2(b *testing.B) {
+	var work MinerWork
+	var pad ScratchPadV2
+
+	b.ReportAllocs()
+	MineRangeV2(&work, 0, uint64(b.N), difficulty.Target{}, &pad, nil)
+}
@@ -0,0 +1,263 @@
+// Package miner implements a multi-threaded XELIS miner on top of the ThreadPool
+package miner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xelpool/xelishash"
+)
+
+var ErrUnknownAlgorithm = errors.New("miner: unknown algorithm")
+
+// Engine splits the nonce space of the current job between its workers,
+// rolling the extra nonce once a worker exhausts its range, and sends the nonces meeting the job target on the Solutions channel
+type Engine struct {
+	workers   int
+	nonces    []NonceRange
+	solutions chan Solution
+	hashes    atomic.Uint64
+
+	mu sync.Mutex
+	// job is nil until the first call to SetJob
+	job *Job
+	// changed is closed and replaced every time the job is replaced
+	changed chan struct{}
+
+	// sendMu is read locked by the workers sending a solution and write locked by SetJob to drain the channel
+	sendMu sync.RWMutex
+}
+
+func NewEngine(workers int) *Engine {
+	if workers < 1 {
+		workers = 1
+	}
+
+	nonces, _ := FullNonceRange.Split(workers)
+
+	return &Engine{
+		workers:   workers,
+		nonces:    nonces,
+		solutions: make(chan Solution, workers),
+		changed:   make(chan struct{}),
+	}
+}
+
+// Solutions returns the channel receiving the solutions found
+// It is closed once Run returns
+func (e *Engine) Solutions() <-chan Solution {
+	return e.solutions
+}
+
+// Hashes returns the total number of hashes computed by the workers
+func (e *Engine) Hashes() uint64 {
+	return e.hashes.Load()
+}
+
+// SetJob atomically replaces the current job
+// Workers drop the previous job before computing their next hash,
+// and the solutions found for it, even the ones waiting in the Solutions channel, are no longer received
+func (e *Engine) SetJob(job Job) error {
+	if err := validJob(&job); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.job = &job
+	close(e.changed)
+	e.changed = make(chan struct{})
+
+	// the senders blocked on a full channel see changed and release sendMu,
+	// the ones coming after see changed before sending, so the buffer only holds solutions of the previous job
+	e.sendMu.Lock()
+	defer e.sendMu.Unlock()
+	for {
+		select {
+		case _, ok := <-e.solutions:
+			if ok {
+				continue
+			}
+		default:
+		}
+		return nil
+	}
+}
+
+// Job returns the current job, if any
+func (e *Engine) Job() (Job, bool) {
+	job, _ := e.current()
+	if job == nil {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (e *Engine) current() (*Job, <-chan struct{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.job, e.changed
+}
+
+// Run starts the workers and blocks until the context is cancelled
+// It must only be called once
+func (e *Engine) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < e.workers; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			e.worker(ctx, index)
+		}(i)
+	}
+
+	wg.Wait()
+	close(e.solutions)
+
+	return ctx.Err()
+}
+
+// BATCH is the number of nonces searched between two updates of the hash counter
+const BATCH = 64
+
+// scratchPads holds the memory of a worker, allocated on the first job of each algorithm
+type scratchPads struct {
+	v1  *xelishash.ScratchPad
+	v2  *xelishash.ScratchPadV2
+	dev *xelishash.ScratchPadDev
+}
+
+// mineRange runs the MineRange function of the algorithm of the job
+func (p *scratchPads) mineRange(job *Job, work *xelishash.MinerWork, start, count uint64, stop <-chan struct{}) (uint64, xelishash.Hash, bool, uint64) {
+	switch job.Algorithm {
+	case xelishash.ALGO_V1:
+		if p.v1 == nil {
+			p.v1 = &xelishash.ScratchPad{}
+		}
+		v1 := work.V1()
+		defer func() {
+			*work = *v1.Work()
+		}()
+		return xelishash.MineRangeV1(&v1, start, count, job.Target, p.v1, stop)
+	case xelishash.ALGO_DEV:
+		if p.dev == nil {
+			p.dev = &xelishash.ScratchPadDev{}
+		}
+		return xelishash.MineRangeDev(work, start, count, job.Target, p.dev, stop)
+	}
+
+	if p.v2 == nil {
+		p.v2 = &xelishash.ScratchPadV2{}
+	}
+	return xelishash.MineRangeV2(work, start, count, job.Target, p.v2, stop)
+}
+
+func (e *Engine) worker(ctx context.Context, index int) {
+	var pads scratchPads
+	for {
+		job, changed := e.current()
+		if job != nil {
+			e.mine(ctx, index, job, changed, &pads)
+		}
+
+		// wait for a new job, either none was set yet or the search space got exhausted
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// mine searches the space of the worker until it is exhausted, the job changes or the context is done
+func (e *Engine) mine(ctx context.Context, index int, job *Job, changed <-chan struct{}, pads *scratchPads) {
+	nonces := e.nonces[index]
+	cursor, err := NewCursor(job.Work.ExtraNonce(), job.ExtraNoncePrefix, nonces)
+	if err != nil {
+		return
+	}
+
+	// stop interrupts the search as soon as the job changes or the context is done
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		case <-done:
+			return
+		}
+		close(stop)
+	}()
+
+	work := job.Work
+	for {
+		extra_nonce, err := cursor.nextPass()
+		if err != nil {
+			return
+		}
+		work.SetExtraNonce(extra_nonce)
+
+		// go through the nonce range in batches, the range may be the full u64 space
+		start := nonces.Start
+		for {
+			count := uint64(BATCH)
+			if remaining := nonces.End - start; remaining < count {
+				count = remaining + 1
+			}
+
+			nonce, hash, found, tried := pads.mineRange(job, &work, start, count, stop)
+			e.hashes.Add(tried)
+
+			last := start + count - 1
+			if found {
+				solution := Solution{
+					JobID: job.ID,
+					Nonce: nonce,
+					Work:  work,
+					Hash:  hash,
+				}
+				if !e.send(ctx, changed, solution) {
+					return
+				}
+				last = nonce
+			} else if tried < count {
+				// stopped
+				return
+			}
+
+			if last == nonces.End {
+				break
+			}
+			start = last + 1
+		}
+	}
+}
+
+// send delivers a solution unless its job got replaced, it reports whether the worker can go on
+// It blocks while the channel is full, SetJob drains the channel once the senders left, so it never keeps a stale solution
+func (e *Engine) send(ctx context.Context, changed <-chan struct{}, solution Solution) bool {
+	e.sendMu.RLock()
+	defer e.sendMu.RUnlock()
+
+	// select picks any ready case, a replaced job must not race with a free slot
+	select {
+	case <-changed:
+		return false
+	default:
+	}
+
+	select {
+	case e.solutions <- solution:
+		return true
+	case <-changed:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
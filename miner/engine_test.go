@@ -0,0 +1,156 @@
+package miner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/difficulty"
+)
+
+func receive(t *testing.T, solutions <-chan Solution) Solution {
+	t.Helper()
+
+	select {
+	case solution, ok := <-solutions:
+		if !ok {
+			t.Fatal("solutions channel closed")
+		}
+		return solution
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a solution")
+	}
+	return Solution{}
+}
+
+func TestEngine(t *testing.T) {
+	engine := NewEngine(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- engine.Run(ctx)
+	}()
+
+	// difficulty 1 accepts every hash
+	job := Job{
+		ID:        "a",
+		Algorithm: xelishash.ALGO_DEV,
+		Target:    difficulty.MaxTarget,
+	}
+	if err := engine.SetJob(job); err != nil {
+		t.Fatal(err)
+	}
+
+	tp := xelishash.NewThreadPool(1)
+	seen := map[uint64]bool{}
+	for i := 0; i < 10; i++ {
+		solution := receive(t, engine.Solutions())
+		if solution.JobID != "a" {
+			t.Fatalf("incorrect job id %s", solution.JobID)
+		}
+		if seen[solution.Nonce] {
+			t.Fatalf("nonce %d found twice", solution.Nonce)
+		}
+		seen[solution.Nonce] = true
+
+		if solution.Work.Nonce() != solution.Nonce || solution.Work.WorkHash() != job.Work.WorkHash() {
+			t.Fatalf("incorrect solution work %s", solution.Work)
+		}
+		if hash := tp.XelisHashDev(solution.Work[:]); hash != solution.Hash {
+			t.Fatalf("incorrect hash: %s, expected: %s", solution.Hash, hash)
+		}
+	}
+
+	// the second worker starts in the upper half of the nonce space
+	var upper bool
+	for nonce := range seen {
+		upper = upper || nonce >= 1<<63
+	}
+	if !upper {
+		t.Fatal("nonce space is not split between the workers")
+	}
+
+	job.ID = "b"
+	job.Work[0] = 1
+	if err := engine.SetJob(job); err != nil {
+		t.Fatal(err)
+	}
+
+	if solution := receive(t, engine.Solutions()); solution.JobID != "b" || solution.Work[0] != 1 {
+		t.Fatalf("solution does not use the new job: %+v", solution)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("got error %v, expected %v", err, context.Canceled)
+	}
+	for range engine.Solutions() {
+	}
+
+	if engine.Hashes() < 11 {
+		t.Fatalf("incorrect hash count %d", engine.Hashes())
+	}
+}
+
+func TestEngineStaleSolutions(t *testing.T) {
+	engine := NewEngine(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	job := Job{Algorithm: xelishash.ALGO_DEV, Target: difficulty.MaxTarget}
+	for i := 0; i < 20; i++ {
+		job.ID = string(rune('a' + i))
+		if err := engine.SetJob(job); err != nil {
+			t.Fatal(err)
+		}
+
+		// every hash is a solution, let them fill the channel before replacing the job
+		deadline := time.Now().Add(10 * time.Second)
+		for len(engine.solutions) < cap(engine.solutions) {
+			if time.Now().After(deadline) {
+				t.Fatal("the solutions channel never filled")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		next := job
+		next.ID += "'"
+		if err := engine.SetJob(next); err != nil {
+			t.Fatal(err)
+		}
+		if solution := receive(t, engine.Solutions()); solution.JobID != next.ID {
+			t.Fatalf("got a solution of job %s after switching to job %s", solution.JobID, next.ID)
+		}
+	}
+}
+
+func TestEngineTarget(t *testing.T) {
+	engine := NewEngine(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	target, err := difficulty.DifficultyToTarget(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.SetJob(Job{ID: "v1", Algorithm: xelishash.ALGO_V1, Target: target}); err != nil {
+		t.Fatal(err)
+	}
+
+	solution := receive(t, engine.Solutions())
+	input := solution.Work.V1()
+	var pad xelishash.ScratchPad
+	if hash := xelishash.XelisHash(input[:], &pad); hash != solution.Hash || !target.Check(hash) {
+		t.Fatalf("incorrect xel/0 solution %s", solution.Hash)
+	}
+
+	if err := engine.SetJob(Job{Algorithm: "xel/unknown"}); err != ErrUnknownAlgorithm {
+		t.Fatalf("got error %v, expected %v", err, ErrUnknownAlgorithm)
+	}
+	if job, ok := engine.Job(); !ok || job.ID != "v1" {
+		t.Fatal("an invalid job must not replace the current one")
+	}
+}
@@ -0,0 +1,46 @@
+package miner
+
+import (
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/difficulty"
+)
+
+// Job is a unit of work handed to the Engine
+type Job struct {
+	ID string
+	// Work is the xel/1 miner work, its nonce is overwritten by the workers
+	// For xel/0, it is zero padded to the legacy input before hashing
+	Work      xelishash.MinerWork
+	Algorithm string
+	Target    difficulty.Target
+	// ExtraNoncePrefix is the number of leading extra nonce bytes assigned by the job issuer
+	// Workers roll the remaining bytes once their nonce range is exhausted
+	ExtraNoncePrefix int
+}
+
+// Solution is a nonce found for a Job, with its hash meeting the job target
+type Solution struct {
+	JobID string
+	Nonce uint64
+	// Work has the nonce of the solution already set
+	Work xelishash.MinerWork
+	Hash xelishash.Hash
+}
+
+func validJob(job *Job) error {
+	if !validAlgorithm(job.Algorithm) {
+		return ErrUnknownAlgorithm
+	}
+	if job.ExtraNoncePrefix < 0 || job.ExtraNoncePrefix > xelishash.EXTRA_NONCE_SIZE {
+		return ErrInvalidPrefixSize
+	}
+	return nil
+}
+
+func validAlgorithm(algo string) bool {
+	switch algo {
+	case xelishash.ALGO_V1, xelishash.ALGO_V2, xelishash.ALGO_DEV:
+		return true
+	}
+	return false
+}
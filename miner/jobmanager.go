@@ -0,0 +1,285 @@
+package miner
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/difficulty"
+)
+
+var ErrNoTemplate = errors.New("miner: no template")
+
+// Clock returns the current time, it can be replaced in tests
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// JobStatus tells whether a solution refers to work that can still be submitted
+type JobStatus int
+
+const (
+	// JobUnknown is returned for jobs never issued, evicted from the history, or not matching the issued work
+	JobUnknown JobStatus = iota
+	// JobCurrent is returned for jobs built from the current template, even if their timestamp got refreshed since
+	JobCurrent
+	// JobStale is returned for jobs built from a previous template
+	JobStale
+)
+
+func (s JobStatus) String() string {
+	switch s {
+	case JobCurrent:
+		return "current"
+	case JobStale:
+		return "stale"
+	}
+	return "unknown"
+}
+
+// Template is the work received from the daemon or the pool
+type Template struct {
+	Work      xelishash.MinerWork
+	Algorithm string
+	Target    difficulty.Target
+	Height    uint64
+	// ExtraNoncePrefix is copied into the jobs, see Job.ExtraNoncePrefix
+	ExtraNoncePrefix int
+}
+
+type JobManagerConfig struct {
+	// RefreshInterval is how often the timestamp of the work is rewritten, 0 disables it
+	RefreshInterval time.Duration
+	// History is the number of previous jobs remembered to report them as stale
+	History int
+	// Clock defaults to the system clock
+	Clock Clock
+}
+
+type issuedJob struct {
+	job      Job
+	height   uint64
+	template uint64
+}
+
+// JobManager owns the current template and turns it into jobs
+// Each template and each timestamp refresh creates a new job with a new ID,
+// so workers restart their nonce ranges on the new work
+// It is safe for concurrent use
+type JobManager struct {
+	config JobManagerConfig
+
+	mu        sync.Mutex
+	template  *Template
+	templates uint64
+	refreshed time.Time
+	next_id   uint64
+	current   *issuedJob
+	// issued jobs by ID, order keeps their IDs from the oldest to the newest
+	jobs  map[string]*issuedJob
+	order []string
+}
+
+func NewJobManager(config JobManagerConfig) *JobManager {
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+	if config.History < 1 {
+		config.History = 16
+	}
+
+	return &JobManager{
+		config: config,
+		jobs:   make(map[string]*issuedJob),
+	}
+}
+
+// SetTemplate replaces the template, all the jobs issued so far become stale
+// The timestamp of the work is rewritten with the current time
+func (m *JobManager) SetTemplate(template Template) (Job, error) {
+	job := Job{
+		Work:             template.Work,
+		Algorithm:        template.Algorithm,
+		Target:           template.Target,
+		ExtraNoncePrefix: template.ExtraNoncePrefix,
+	}
+	if err := validJob(&job); err != nil {
+		return Job{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.template = &template
+	m.templates++
+
+	return m.issue(), nil
+}
+
+// issue creates a new job from the current template with a fresh timestamp
+func (m *JobManager) issue() Job {
+	now := m.config.Clock.Now()
+	m.template.Work.SetTimestamp(uint64(now.UnixMilli()))
+	m.refreshed = now
+
+	m.next_id++
+	job := Job{
+		ID:               strconv.FormatUint(m.next_id, 16),
+		Work:             m.template.Work,
+		Algorithm:        m.template.Algorithm,
+		Target:           m.template.Target,
+		ExtraNoncePrefix: m.template.ExtraNoncePrefix,
+	}
+
+	issued := &issuedJob{
+		job:      job,
+		height:   m.template.Height,
+		template: m.templates,
+	}
+	m.current = issued
+	m.jobs[job.ID] = issued
+	m.order = append(m.order, job.ID)
+
+	// the current job is kept on top of the history
+	if len(m.order) > m.config.History+1 {
+		delete(m.jobs, m.order[0])
+		m.order = m.order[1:]
+	}
+
+	return job
+}
+
+// Refresh rewrites the timestamp of the work once the refresh interval elapsed
+// It returns the new job and true if the work got refreshed
+func (m *JobManager) Refresh() (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.template == nil || m.config.RefreshInterval <= 0 {
+		return Job{}, false
+	}
+	if m.config.Clock.Now().Sub(m.refreshed) < m.config.RefreshInterval {
+		return Job{}, false
+	}
+
+	return m.issue(), true
+}
+
+// Capacity returns the most jobs remembered at once, the current one on top of the History previous ones
+// Fewer are remembered until History jobs got replaced
+func (m *JobManager) Capacity() int {
+	return m.config.History + 1
+}
+
+// Current returns the latest job issued
+func (m *JobManager) Current() (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current == nil {
+		return Job{}, ErrNoTemplate
+	}
+	return m.current.job, nil
+}
+
+// Height returns the height of the template the job was built from
+func (m *JobManager) Height(job_id string) (uint64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	issued, ok := m.jobs[job_id]
+	if !ok {
+		return 0, false
+	}
+	return issued.height, true
+}
+
+// Status reports whether the job ID refers to current, stale or unknown work
+func (m *JobManager) Status(job_id string) JobStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	issued, ok := m.jobs[job_id]
+	if !ok {
+		return JobUnknown
+	}
+	return m.status(issued)
+}
+
+func (m *JobManager) status(issued *issuedJob) JobStatus {
+	if issued.template == m.templates {
+		return JobCurrent
+	}
+	return JobStale
+}
+
+// Job returns an issued job with its status
+func (m *JobManager) Job(job_id string) (Job, JobStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	issued, ok := m.jobs[job_id]
+	if !ok {
+		return Job{}, JobUnknown
+	}
+	return issued.job, m.status(issued)
+}
+
+// Check reports the status of the job a solution refers to
+// The solution work must match the issued job, except for the nonce and the extra nonce
+func (m *JobManager) Check(solution Solution) JobStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	issued, ok := m.jobs[solution.JobID]
+	if !ok {
+		return JobUnknown
+	}
+
+	work := issued.job.Work
+	if work.WorkHash() != solution.Work.WorkHash() ||
+		work.Timestamp() != solution.Work.Timestamp() ||
+		work.PublicKey() != solution.Work.PublicKey() {
+		return JobUnknown
+	}
+	return m.status(issued)
+}
+
+// Run calls Refresh periodically until the context is done,
+// sending every refreshed job to the given function
+// The ticker runs on real time, only Refresh reads the Clock: tests with a fake clock call Refresh directly
+func (m *JobManager) Run(ctx context.Context, refreshed func(Job)) error {
+	if m.config.RefreshInterval <= 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	// tick more often than the interval so a template change doesn't delay the next refresh
+	// The period of sub-nanosecond quarters is clamped, NewTicker panics on zero
+	period := m.config.RefreshInterval / 4
+	if period <= 0 {
+		period = m.config.RefreshInterval
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if job, ok := m.Refresh(); ok {
+				refreshed(job)
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package miner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/difficulty"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func testTemplate(height uint64) Template {
+	var work xelishash.MinerWork
+	work[0] = byte(height)
+
+	return Template{
+		Work:      work,
+		Algorithm: xelishash.ALGO_DEV,
+		Target:    difficulty.MaxTarget,
+		Height:    height,
+	}
+}
+
+func TestJobManagerRefresh(t *testing.T) {
+	clock := &fakeClock{now: time.UnixMilli(1736271107534)}
+	manager := NewJobManager(JobManagerConfig{
+		RefreshInterval: 5 * time.Second,
+		Clock:           clock,
+	})
+
+	if _, err := manager.Current(); err != ErrNoTemplate {
+		t.Fatalf("got error %v, expected %v", err, ErrNoTemplate)
+	}
+	if _, ok := manager.Refresh(); ok {
+		t.Fatal("nothing to refresh without a template")
+	}
+
+	job, err := manager.SetTemplate(testTemplate(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Work.Timestamp() != 1736271107534 || job.Work[0] != 10 {
+		t.Fatalf("incorrect work %s", job.Work)
+	}
+
+	clock.Advance(4 * time.Second)
+	if _, ok := manager.Refresh(); ok {
+		t.Fatal("refreshed before the interval elapsed")
+	}
+
+	clock.Advance(time.Second)
+	refreshed, ok := manager.Refresh()
+	if !ok {
+		t.Fatal("work not refreshed")
+	}
+	if refreshed.ID == job.ID || refreshed.Work.Timestamp() != 1736271112534 {
+		t.Fatalf("incorrect refreshed job %s with work %s", refreshed.ID, refreshed.Work)
+	}
+	if refreshed.Work.WorkHash() != job.Work.WorkHash() {
+		t.Fatal("refresh must only rewrite the timestamp")
+	}
+
+	current, err := manager.Current()
+	if err != nil || current.ID != refreshed.ID {
+		t.Fatalf("incorrect current job %s: %v", current.ID, err)
+	}
+
+	// both jobs are built from the current template
+	if manager.Status(job.ID) != JobCurrent || manager.Status(refreshed.ID) != JobCurrent {
+		t.Fatal("jobs of the current template must be current")
+	}
+	if height, ok := manager.Height(job.ID); !ok || height != 10 {
+		t.Fatalf("incorrect height %d", height)
+	}
+}
+
+func TestJobManagerStatus(t *testing.T) {
+	clock := &fakeClock{now: time.UnixMilli(1000)}
+	manager := NewJobManager(JobManagerConfig{
+		RefreshInterval: time.Second,
+		History:         2,
+		Clock:           clock,
+	})
+
+	first, _ := manager.SetTemplate(testTemplate(1))
+	second, _ := manager.SetTemplate(testTemplate(2))
+
+	if manager.Status(first.ID) != JobStale || manager.Status(second.ID) != JobCurrent {
+		t.Fatalf("incorrect status %s and %s", manager.Status(first.ID), manager.Status(second.ID))
+	}
+	if manager.Status("unknown") != JobUnknown {
+		t.Fatal("never issued job must be unknown")
+	}
+
+	solution := Solution{JobID: second.ID, Work: second.Work}
+	solution.Work.SetNonce(42)
+	if status := manager.Check(solution); status != JobCurrent {
+		t.Fatalf("incorrect solution status %s", status)
+	}
+	solution.Work.SetTimestamp(1)
+	if status := manager.Check(solution); status != JobUnknown {
+		t.Fatalf("solution with a different timestamp must be unknown, got %s", status)
+	}
+
+	solution = Solution{JobID: first.ID, Work: first.Work}
+	if status := manager.Check(solution); status != JobStale {
+		t.Fatalf("incorrect solution status %s", status)
+	}
+
+	// the first job gets evicted from the history
+	clock.Advance(time.Second)
+	manager.Refresh()
+	clock.Advance(time.Second)
+	manager.Refresh()
+	if manager.Status(first.ID) != JobUnknown {
+		t.Fatal("evicted job must be unknown")
+	}
+	if _, ok := manager.Height(first.ID); ok {
+		t.Fatal("evicted job must not have a height")
+	}
+	if manager.Status(second.ID) != JobCurrent {
+		t.Fatal("job within the history must be kept")
+	}
+	if manager.Capacity() != 3 {
+		t.Fatalf("the current job and 2 previous ones must be remembered, got %d", manager.Capacity())
+	}
+
+	if _, err := manager.SetTemplate(Template{Algorithm: "xel/unknown"}); err != ErrUnknownAlgorithm {
+		t.Fatalf("got error %v, expected %v", err, ErrUnknownAlgorithm)
+	}
+}
+
+func TestJobManagerRun(t *testing.T) {
+	clock := &fakeClock{now: time.UnixMilli(1000)}
+	manager := NewJobManager(JobManagerConfig{
+		RefreshInterval: 4 * time.Millisecond,
+		Clock:           clock,
+	})
+	if _, err := manager.SetTemplate(testTemplate(1)); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs := make(chan Job, 1)
+	done := make(chan error)
+	go func() {
+		done <- manager.Run(ctx, func(job Job) {
+			jobs <- job
+		})
+	}()
+
+	select {
+	case job := <-jobs:
+		if job.Work.Timestamp() != 2000 {
+			t.Fatalf("incorrect refreshed timestamp %d", job.Work.Timestamp())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a refresh")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("got error %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestJobManagerRunShortInterval(t *testing.T) {
+	manager := NewJobManager(JobManagerConfig{RefreshInterval: 3 * time.Nanosecond})
+	if _, err := manager.SetTemplate(testTemplate(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a quarter of the interval rounds to zero, which must not panic
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := manager.Run(ctx, func(Job) {}); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
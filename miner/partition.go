@@ -0,0 +1,223 @@
+package miner
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/xelpool/xelishash"
+)
+
+var (
+	ErrSpaceExhausted    = errors.New("miner: search space exhausted")
+	ErrInvalidPrefixSize = errors.New("miner: invalid extra nonce prefix size")
+	ErrInvalidPartitions = errors.New("miner: the number of partitions must be at least 1")
+)
+
+// NonceRange is an inclusive range of nonces, so it can cover the full u64 space
+type NonceRange struct {
+	Start uint64
+	End   uint64
+}
+
+// FullNonceRange covers every possible nonce
+var FullNonceRange = NonceRange{Start: 0, End: math.MaxUint64}
+
+// Contains reports whether the nonce is part of the range
+func (r NonceRange) Contains(nonce uint64) bool {
+	return nonce >= r.Start && nonce <= r.End
+}
+
+// Split divides the range into n disjoint ranges covering it entirely
+// When the range has less than n nonces, only one range per nonce is returned
+func (r NonceRange) Split(n int) ([]NonceRange, error) {
+	if n < 1 {
+		return nil, ErrInvalidPartitions
+	}
+
+	// size - 1, as the size of the full range doesn't fit in a u64
+	last := r.End - r.Start
+	if uint64(n)-1 > last {
+		n = int(last + 1)
+	}
+
+	// the first rem ranges get one more nonce
+	span, rem := last/uint64(n), last%uint64(n)+1
+	if rem == uint64(n) {
+		span, rem = span+1, 0
+	}
+
+	// for the full range, the size of a single range wraps to 0 but its end is still correct
+	ranges := make([]NonceRange, n)
+	start := r.Start
+	for i := range ranges {
+		size := span
+		if uint64(i) < rem {
+			size++
+		}
+		ranges[i] = NonceRange{Start: start, End: start + size - 1}
+		start += size
+	}
+
+	return ranges, nil
+}
+
+// Cursor walks the search space of one worker
+// It goes through its nonce range, then rolls the extra nonce and starts the range again
+// Only the bytes after the extra nonce prefix are rolled, so cursors with disjoint
+// nonce ranges or disjoint prefixes never produce the same pair
+type Cursor struct {
+	extra_nonce [xelishash.EXTRA_NONCE_SIZE]byte
+	// position of the u64 counter rolled in the extra nonce
+	counter_offset int
+	counter_max    uint64
+
+	nonces    NonceRange
+	next      uint64
+	exhausted bool
+}
+
+// NewCursor starts at the beginning of the range with the given extra nonce
+// The first prefix_size bytes of the extra nonce are never modified
+func NewCursor(extra_nonce [xelishash.EXTRA_NONCE_SIZE]byte, prefix_size int, nonces NonceRange) (*Cursor, error) {
+	if prefix_size < 0 || prefix_size > xelishash.EXTRA_NONCE_SIZE {
+		return nil, ErrInvalidPrefixSize
+	}
+	if nonces.End < nonces.Start {
+		return nil, ErrSpaceExhausted
+	}
+
+	// the counter uses the last 8 bytes, or less if the prefix overlaps them
+	counter_size := xelishash.EXTRA_NONCE_SIZE - prefix_size
+	if counter_size > 8 {
+		counter_size = 8
+	}
+
+	var counter_max uint64
+	if counter_size > 0 {
+		counter_max = math.MaxUint64 >> (64 - 8*counter_size)
+	}
+
+	return &Cursor{
+		extra_nonce:    extra_nonce,
+		counter_offset: xelishash.EXTRA_NONCE_SIZE - counter_size,
+		counter_max:    counter_max,
+		nonces:         nonces,
+		next:           nonces.Start,
+	}, nil
+}
+
+func (c *Cursor) counter() uint64 {
+	var b [8]byte
+	size := xelishash.EXTRA_NONCE_SIZE - c.counter_offset
+	copy(b[8-size:], c.extra_nonce[c.counter_offset:])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+func (c *Cursor) setCounter(counter uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], counter)
+	size := xelishash.EXTRA_NONCE_SIZE - c.counter_offset
+	copy(c.extra_nonce[c.counter_offset:], b[8-size:])
+}
+
+// Next returns the next extra nonce and nonce pair to try
+// It returns ErrSpaceExhausted once the rolled part of the extra nonce overflows
+func (c *Cursor) Next() ([xelishash.EXTRA_NONCE_SIZE]byte, uint64, error) {
+	if c.exhausted {
+		counter := c.counter()
+		if counter >= c.counter_max {
+			return c.extra_nonce, 0, ErrSpaceExhausted
+		}
+		c.setCounter(counter + 1)
+		c.next = c.nonces.Start
+		c.exhausted = false
+	}
+
+	nonce := c.next
+	if nonce == c.nonces.End {
+		c.exhausted = true
+	} else {
+		c.next++
+	}
+
+	return c.extra_nonce, nonce, nil
+}
+
+// nextPass returns the extra nonce of the next pass over the whole nonce range,
+// the caller searches the range itself so the cursor moves to the following extra nonce
+func (c *Cursor) nextPass() ([xelishash.EXTRA_NONCE_SIZE]byte, error) {
+	extra_nonce, _, err := c.Next()
+	if err != nil {
+		return extra_nonce, err
+	}
+	c.exhausted = true
+	return extra_nonce, nil
+}
+
+// Apply writes the next pair in the work, updating it in place
+func (c *Cursor) Apply(work *xelishash.MinerWork) error {
+	extra_nonce, nonce, err := c.Next()
+	if err != nil {
+		return err
+	}
+
+	work.SetExtraNonce(extra_nonce)
+	work.SetNonce(nonce)
+	return nil
+}
+
+// PrefixAllocator hands out disjoint extra nonce prefixes, one per rig or connection
+// Each prefix is the base followed by a big-endian counter of size bytes
+// It is safe for concurrent use
+type PrefixAllocator struct {
+	mu        sync.Mutex
+	base      []byte
+	size      int
+	next      uint64
+	max       uint64
+	exhausted bool
+}
+
+func NewPrefixAllocator(base []byte, size int) (*PrefixAllocator, error) {
+	if size < 1 || size > 8 || len(base)+size > xelishash.EXTRA_NONCE_SIZE {
+		return nil, ErrInvalidPrefixSize
+	}
+
+	return &PrefixAllocator{
+		base: append([]byte(nil), base...),
+		size: size,
+		max:  math.MaxUint64 >> (64 - 8*size),
+	}, nil
+}
+
+// PrefixSize returns the length of the prefixes handed out
+func (a *PrefixAllocator) PrefixSize() int {
+	return len(a.base) + a.size
+}
+
+// Next returns a prefix that was never returned before
+func (a *PrefixAllocator) Next() ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.exhausted {
+		return nil, ErrSpaceExhausted
+	}
+
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], a.next)
+
+	prefix := make([]byte, 0, len(a.base)+a.size)
+	prefix = append(prefix, a.base...)
+	prefix = append(prefix, b[8-a.size:]...)
+
+	if a.next == a.max {
+		a.exhausted = true
+	} else {
+		a.next++
+	}
+
+	return prefix, nil
+}
@@ -0,0 +1,203 @@
+package miner
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/xelpool/xelishash"
+)
+
+// checkSplit verifies that the ranges are disjoint, ordered and cover the parent range
+func checkSplit(t *testing.T, parent NonceRange, ranges []NonceRange) {
+	t.Helper()
+
+	if ranges[0].Start != parent.Start || ranges[len(ranges)-1].End != parent.End {
+		t.Fatalf("ranges %v do not cover %v", ranges, parent)
+	}
+	for i, r := range ranges {
+		if r.End < r.Start {
+			t.Fatalf("empty range %v", r)
+		}
+		if i > 0 && ranges[i-1].End+1 != r.Start {
+			t.Fatalf("ranges %v and %v overlap or leave a gap", ranges[i-1], r)
+		}
+	}
+}
+
+func TestNonceRangeSplit(t *testing.T) {
+	if _, err := FullNonceRange.Split(0); err != ErrInvalidPartitions {
+		t.Fatalf("got error %v, expected %v", err, ErrInvalidPartitions)
+	}
+
+	ranges, err := FullNonceRange.Split(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 1 || ranges[0] != FullNonceRange {
+		t.Fatalf("incorrect split %v", ranges)
+	}
+
+	ranges, _ = NonceRange{Start: 10, End: 12}.Split(5)
+	if len(ranges) != 3 {
+		t.Fatalf("a range of 3 nonces must be split in 3, got %v", ranges)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		parent := FullNonceRange
+		if i > 0 {
+			a, b := rng.Uint64()>>uint(rng.Intn(64)), rng.Uint64()>>uint(rng.Intn(64))
+			if a > b {
+				a, b = b, a
+			}
+			parent = NonceRange{Start: a, End: b}
+		}
+
+		n := 1 + rng.Intn(64)
+		ranges, err := parent.Split(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSplit(t, parent, ranges)
+
+		// sizes differ by at most one
+		if len(ranges) > 1 {
+			first := ranges[0].End - ranges[0].Start
+			last := ranges[len(ranges)-1].End - ranges[len(ranges)-1].Start
+			if first-last > 1 {
+				t.Fatalf("unbalanced split of %v: %v", parent, ranges)
+			}
+		}
+	}
+}
+
+type pair struct {
+	extra_nonce [xelishash.EXTRA_NONCE_SIZE]byte
+	nonce       uint64
+}
+
+func TestCursorNoOverlap(t *testing.T) {
+	var extra_nonce [xelishash.EXTRA_NONCE_SIZE]byte
+	extra_nonce[0] = 0xaa
+	// start right before the counter overflows a byte
+	extra_nonce[xelishash.EXTRA_NONCE_SIZE-1] = 0xfe
+
+	ranges, _ := NonceRange{Start: 0, End: 9}.Split(3)
+	seen := map[pair]int{}
+
+	for worker, r := range ranges {
+		cursor, err := NewCursor(extra_nonce, 4, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// go through the range 4 times, rolling the extra nonce 3 times
+		size := int(r.End - r.Start + 1)
+		for i := 0; i < size*4; i++ {
+			en, nonce, err := cursor.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !r.Contains(nonce) {
+				t.Fatalf("worker %d: nonce %d is out of its range %v", worker, nonce, r)
+			}
+			if en[0] != 0xaa {
+				t.Fatal("the extra nonce prefix must not be modified")
+			}
+
+			p := pair{en, nonce}
+			if previous, ok := seen[p]; ok {
+				t.Fatalf("workers %d and %d produced the same pair", previous, worker)
+			}
+			seen[p] = worker
+		}
+	}
+
+	if len(seen) != 40 {
+		t.Fatalf("incorrect number of pairs %d", len(seen))
+	}
+}
+
+func TestCursorExhausted(t *testing.T) {
+	var extra_nonce [xelishash.EXTRA_NONCE_SIZE]byte
+	extra_nonce[xelishash.EXTRA_NONCE_SIZE-1] = 0xfe
+
+	// a prefix of 31 bytes leaves a single byte to roll
+	cursor, err := NewCursor(extra_nonce, xelishash.EXTRA_NONCE_SIZE-1, NonceRange{Start: 5, End: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var work xelishash.MinerWork
+	for i := 0; i < 4; i++ {
+		if err := cursor.Apply(&work); err != nil {
+			t.Fatal(err)
+		}
+		if work.Nonce() != uint64(5+i%2) || work.ExtraNonce()[xelishash.EXTRA_NONCE_SIZE-1] != byte(0xfe+i/2) {
+			t.Fatalf("incorrect work after %d steps: %s", i, work)
+		}
+	}
+	if err := cursor.Apply(&work); err != ErrSpaceExhausted {
+		t.Fatalf("got error %v, expected %v", err, ErrSpaceExhausted)
+	}
+
+	// nothing can be rolled with a full prefix
+	cursor, _ = NewCursor(extra_nonce, xelishash.EXTRA_NONCE_SIZE, NonceRange{Start: math.MaxUint64, End: math.MaxUint64})
+	if _, nonce, err := cursor.Next(); err != nil || nonce != math.MaxUint64 {
+		t.Fatalf("incorrect first nonce %d: %v", nonce, err)
+	}
+	if _, _, err := cursor.Next(); err != ErrSpaceExhausted {
+		t.Fatalf("got error %v, expected %v", err, ErrSpaceExhausted)
+	}
+
+	if _, err := NewCursor(extra_nonce, xelishash.EXTRA_NONCE_SIZE+1, FullNonceRange); err != ErrInvalidPrefixSize {
+		t.Fatalf("got error %v, expected %v", err, ErrInvalidPrefixSize)
+	}
+}
+
+func TestPrefixAllocator(t *testing.T) {
+	if _, err := NewPrefixAllocator(make([]byte, 30), 4); err != ErrInvalidPrefixSize {
+		t.Fatalf("got error %v, expected %v", err, ErrInvalidPrefixSize)
+	}
+
+	allocator, err := NewPrefixAllocator([]byte{0x01}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocator.PrefixSize() != 3 {
+		t.Fatalf("incorrect prefix size %d", allocator.PrefixSize())
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := map[string]bool{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1<<13; j++ {
+				prefix, err := allocator.Next()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if seen[string(prefix)] || prefix[0] != 0x01 || len(prefix) != 3 {
+					t.Errorf("invalid or duplicated prefix %x", prefix)
+				}
+				seen[string(prefix)] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != 1<<16 {
+		t.Fatalf("incorrect number of prefixes %d", len(seen))
+	}
+	if _, err := allocator.Next(); err != ErrSpaceExhausted {
+		t.Fatalf("got error %v, expected %v", err, ErrSpaceExhausted)
+	}
+}
@@ -0,0 +1,150 @@
+package xelishash
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/zeebo/blake3"
+)
+
+// Layout of the xel/1 miner work:
+// work hash (32) | timestamp (8, big-endian) | nonce (8, big-endian) | extra nonce (32) | miner public key (32)
+const (
+	EXTRA_NONCE_SIZE = 32
+	PUBLIC_KEY_SIZE  = 32
+	MINER_WORK_SIZE  = HASH_SIZE + 8 + 8 + EXTRA_NONCE_SIZE + PUBLIC_KEY_SIZE
+
+	TIMESTAMP_OFFSET   = HASH_SIZE
+	NONCE_OFFSET       = TIMESTAMP_OFFSET + 8
+	EXTRA_NONCE_OFFSET = NONCE_OFFSET + 8
+	PUBLIC_KEY_OFFSET  = EXTRA_NONCE_OFFSET + EXTRA_NONCE_SIZE
+)
+
+// MinerWork is the input hashed by XelisHashV2
+// Setters update the underlying bytes in place
+type MinerWork [MINER_WORK_SIZE]byte
+
+func NewMinerWork(work_hash Hash, timestamp uint64, nonce uint64, extra_nonce [EXTRA_NONCE_SIZE]byte, public_key [PUBLIC_KEY_SIZE]byte) MinerWork {
+	var w MinerWork
+	copy(w[:TIMESTAMP_OFFSET], work_hash[:])
+	w.SetTimestamp(timestamp)
+	w.SetNonce(nonce)
+	w.SetExtraNonce(extra_nonce)
+	copy(w[PUBLIC_KEY_OFFSET:], public_key[:])
+	return w
+}
+
+// ParseMinerWork reads a miner work of exactly MINER_WORK_SIZE bytes
+func ParseMinerWork(b []byte) (MinerWork, error) {
+	if len(b) != MINER_WORK_SIZE {
+		return MinerWork{}, ErrInvalidInputSize
+	}
+	return MinerWork(b), nil
+}
+
+// Bytes returns a slice pointing to the underlying bytes of the miner work
+func (w *MinerWork) Bytes() []byte {
+	return w[:]
+}
+
+func (w *MinerWork) WorkHash() Hash {
+	return Hash(w[:TIMESTAMP_OFFSET])
+}
+
+// Timestamp returns the timestamp of the work in milliseconds
+func (w *MinerWork) Timestamp() uint64 {
+	return binary.BigEndian.Uint64(w[TIMESTAMP_OFFSET:NONCE_OFFSET])
+}
+
+func (w *MinerWork) SetTimestamp(timestamp uint64) {
+	binary.BigEndian.PutUint64(w[TIMESTAMP_OFFSET:NONCE_OFFSET], timestamp)
+}
+
+func (w *MinerWork) Nonce() uint64 {
+	return binary.BigEndian.Uint64(w[NONCE_OFFSET:EXTRA_NONCE_OFFSET])
+}
+
+func (w *MinerWork) SetNonce(nonce uint64) {
+	binary.BigEndian.PutUint64(w[NONCE_OFFSET:EXTRA_NONCE_OFFSET], nonce)
+}
+
+func (w *MinerWork) ExtraNonce() [EXTRA_NONCE_SIZE]byte {
+	return [EXTRA_NONCE_SIZE]byte(w[EXTRA_NONCE_OFFSET:PUBLIC_KEY_OFFSET])
+}
+
+func (w *MinerWork) SetExtraNonce(extra_nonce [EXTRA_NONCE_SIZE]byte) {
+	copy(w[EXTRA_NONCE_OFFSET:PUBLIC_KEY_OFFSET], extra_nonce[:])
+}
+
+func (w *MinerWork) PublicKey() [PUBLIC_KEY_SIZE]byte {
+	return [PUBLIC_KEY_SIZE]byte(w[PUBLIC_KEY_OFFSET:])
+}
+
+func (w *MinerWork) SetPublicKey(public_key [PUBLIC_KEY_SIZE]byte) {
+	copy(w[PUBLIC_KEY_OFFSET:], public_key[:])
+}
+
+// Hash computes the xel/1 hash of the miner work
+func (w *MinerWork) Hash(pool *ThreadPool) Hash {
+	return pool.XelisHashV2(w[:])
+}
+
+// BlockHash returns the hash of the block mined with this work, the blake3 hash of its BLOCK_WORK_SIZE bytes
+// It is not the PoW hash, which depends on the algorithm of the height
+func (w *MinerWork) BlockHash() Hash {
+	return blake3.Sum256(w[:])
+}
+
+// String returns the lowercase hex encoding of the miner work
+func (w MinerWork) String() string {
+	return hex.EncodeToString(w[:])
+}
+
+// MarshalText encodes the miner work as hex, as sent by the XELIS daemon
+func (w MinerWork) MarshalText() ([]byte, error) {
+	out := make([]byte, MINER_WORK_SIZE*2)
+	hex.Encode(out, w[:])
+	return out, nil
+}
+
+// UnmarshalText decodes a hex encoded miner work of exactly MINER_WORK_SIZE bytes
+func (w *MinerWork) UnmarshalText(text []byte) error {
+	if len(text) != MINER_WORK_SIZE*2 {
+		return ErrInvalidInputSize
+	}
+
+	var decoded MinerWork
+	if _, err := hex.Decode(decoded[:], text); err != nil {
+		return err
+	}
+	*w = decoded
+
+	return nil
+}
+
+// MinerWorkV1 is the input hashed by the legacy xel/0 algorithm
+// It contains the same fields as MinerWork, zero padded to BYTES_ARRAY_INPUT bytes
+type MinerWorkV1 [BYTES_ARRAY_INPUT]byte
+
+// V1 returns the xel/0 input built from the miner work
+func (w *MinerWork) V1() MinerWorkV1 {
+	var v1 MinerWorkV1
+	copy(v1[:], w[:])
+	return v1
+}
+
+// Work returns the miner work fields of the xel/0 input
+// The returned MinerWork points to the same bytes, so its setters update the input in place
+func (w *MinerWorkV1) Work() *MinerWork {
+	return (*MinerWork)(w[:MINER_WORK_SIZE])
+}
+
+// Bytes returns a slice pointing to the underlying bytes of the xel/0 input
+func (w *MinerWorkV1) Bytes() []byte {
+	return w[:]
+}
+
+// Hash computes the xel/0 hash of the input
+func (w *MinerWorkV1) Hash(pool *ThreadPool) Hash {
+	return pool.XelisHash(w[:])
+}
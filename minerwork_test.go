@@ -0,0 +1,149 @@
+package xelishash
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestMinerWork(t *testing.T) {
+	input := []byte{83, 175, 21, 164, 59, 64, 112, 22, 133, 157, 110, 93, 103, 233, 95, 171, 84, 212, 94, 159, 56, 231, 142, 83, 155, 90, 210, 84, 73, 195, 107, 38, 0, 0, 1, 148, 65, 210, 149, 206, 0, 0, 0, 0, 0, 0, 2, 111, 30, 180, 107, 152, 2, 158, 60, 146, 72, 97, 3, 240, 133, 110, 18, 13, 196, 213, 137, 255, 172, 43, 178, 237, 0, 0, 0, 0, 0, 0, 0, 1, 80, 105, 173, 140, 96, 184, 216, 33, 205, 190, 44, 59, 87, 223, 214, 64, 226, 151, 200, 115, 89, 42, 131, 251, 182, 18, 47, 210, 108, 219, 69, 126}
+	expectedHash := Hash{86, 153, 158, 47, 177, 49, 55, 60, 155, 61, 147, 124, 179, 204, 11, 76, 59, 90, 186, 134, 9, 20, 21, 248, 156, 47, 122, 116, 118, 227, 24, 75}
+
+	work, err := ParseMinerWork(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(work.Bytes(), input) {
+		t.Fatalf("incorrect bytes: %x", work.Bytes())
+	}
+
+	if work.Timestamp() != 1736271107534 {
+		t.Fatalf("incorrect timestamp: %d", work.Timestamp())
+	}
+	if work.Nonce() != 623 {
+		t.Fatalf("incorrect nonce: %d", work.Nonce())
+	}
+
+	rebuilt := NewMinerWork(work.WorkHash(), work.Timestamp(), work.Nonce(), work.ExtraNonce(), work.PublicKey())
+	if rebuilt != work {
+		t.Fatalf("incorrect rebuilt work: %s", rebuilt)
+	}
+
+	tp := NewThreadPool(1)
+	if hash := work.Hash(tp); hash != expectedHash {
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expectedHash)
+	}
+
+	// setters update the buffer in place
+	buffer := work.Bytes()
+	work.SetNonce(0x0102030405060708)
+	work.SetTimestamp(42)
+	var extra_nonce [EXTRA_NONCE_SIZE]byte
+	extra_nonce[0] = 0xff
+	work.SetExtraNonce(extra_nonce)
+	var public_key [PUBLIC_KEY_SIZE]byte
+	copy(public_key[:], input[PUBLIC_KEY_OFFSET:])
+	public_key[0] ^= 0xff
+	work.SetPublicKey(public_key)
+
+	if !bytes.Equal(buffer[NONCE_OFFSET:EXTRA_NONCE_OFFSET], []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Fatalf("nonce not updated in place: %x", buffer)
+	}
+	if work.Timestamp() != 42 || work.ExtraNonce() != extra_nonce || buffer[EXTRA_NONCE_OFFSET] != 0xff {
+		t.Fatalf("fields not updated in place: %s", work)
+	}
+	if work.PublicKey() != public_key || buffer[PUBLIC_KEY_OFFSET] != input[PUBLIC_KEY_OFFSET]^0xff {
+		t.Fatalf("public key not updated in place: %s", work)
+	}
+	if !bytes.Equal(buffer[:TIMESTAMP_OFFSET], input[:TIMESTAMP_OFFSET]) || !bytes.Equal(buffer[PUBLIC_KEY_OFFSET+1:], input[PUBLIC_KEY_OFFSET+1:]) {
+		t.Fatal("setters must not modify other fields")
+	}
+
+	if _, err := ParseMinerWork(input[:100]); err != ErrInvalidInputSize {
+		t.Fatalf("got error %v, expected %v", err, ErrInvalidInputSize)
+	}
+}
+
+func TestMinerWorkJSON(t *testing.T) {
+	input := []byte{
+		172, 236, 108, 212, 181, 31, 109, 45, 44, 242, 54, 225, 143, 133,
+		89, 44, 179, 108, 39, 191, 32, 116, 229, 33, 63, 130, 33, 120, 185, 89,
+		146, 141, 10, 79, 183, 107, 238, 122, 92, 222, 25, 134, 90, 107, 116,
+		110, 236, 53, 255, 5, 214, 126, 24, 216, 97, 199, 148, 239, 253, 102,
+		199, 184, 232, 253, 158, 145, 86, 187, 112, 81, 78, 70, 80, 110, 33,
+		37, 159, 233, 198, 1, 178, 108, 210, 100, 109, 155, 106, 124, 124, 83,
+		89, 50, 197, 115, 231, 32, 74, 2, 92, 47, 25, 220, 135, 249, 122,
+		172, 220, 137, 143, 234, 68, 188,
+	}
+
+	work, err := ParseMinerWork(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := json.Marshal(struct {
+		Work MinerWork `json:"miner_work"`
+	}{work})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(encoded, []byte(`"`+work.String()+`"`)) {
+		t.Fatalf("incorrect json: %s", encoded)
+	}
+
+	var decoded struct {
+		Work MinerWork `json:"miner_work"`
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Work != work {
+		t.Fatalf("incorrect decoded work: %s", decoded.Work)
+	}
+
+	if err := decoded.Work.UnmarshalText([]byte("00")); err != ErrInvalidInputSize {
+		t.Fatalf("got error %v, expected %v", err, ErrInvalidInputSize)
+	}
+}
+
+func TestMinerWorkV1(t *testing.T) {
+	tp := NewThreadPool(1)
+
+	// the header fields come first, followed by zero padding
+	var work_hash Hash
+	copy(work_hash[:], []byte("xelis-hashing-algorithm"))
+	work := NewMinerWork(work_hash, 0, 0, [EXTRA_NONCE_SIZE]byte{}, [PUBLIC_KEY_SIZE]byte{})
+
+	v1 := work.V1()
+	expected := make([]byte, BYTES_ARRAY_INPUT)
+	copy(expected, []byte("xelis-hashing-algorithm"))
+	if !bytes.Equal(v1.Bytes(), expected) {
+		t.Fatalf("incorrect xel/0 input: %x", v1.Bytes())
+	}
+
+	expectedHash := Hash{
+		106, 106, 173, 8, 207, 59, 118, 108, 176, 196, 9, 124, 250, 195, 3,
+		61, 30, 146, 238, 182, 88, 83, 115, 81, 139, 56, 3, 28, 176, 86, 68, 21}
+	if hash := v1.Hash(tp); hash != expectedHash {
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expectedHash)
+	}
+
+	zero := MinerWork{}
+	v1 = zero.V1()
+	expectedHash = Hash{0x0e, 0xbb, 0xbd, 0x8a, 0x31, 0xed, 0xad, 0xfe, 0x09, 0x8f, 0x2d, 0x77, 0x0d, 0x84,
+		0xb7, 0x19, 0x58, 0x86, 0x75, 0xab, 0x88, 0xa0, 0xa1, 0x70, 0x67, 0xd0, 0x0a, 0x8f,
+		0x36, 0x18, 0x22, 0x65}
+	if hash := v1.Hash(tp); hash != expectedHash {
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expectedHash)
+	}
+
+	// updating the work updates the xel/0 input in place
+	v1.Work().SetNonce(0x0102030405060708)
+	if !bytes.Equal(v1[NONCE_OFFSET:EXTRA_NONCE_OFFSET], []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Fatalf("nonce not updated in place: %x", v1.Bytes())
+	}
+	if !bytes.Equal(v1[MINER_WORK_SIZE:], make([]byte, BYTES_ARRAY_INPUT-MINER_WORK_SIZE)) {
+		t.Fatal("padding must stay zero")
+	}
+}
@@ -0,0 +1,303 @@
+// Package mockdaemon implements a local stand-in of the XELIS daemon for offline end-to-end tests
+//
+// It serves the getwork WebSocket endpoint and the get_block_template and submit_block JSON-RPC methods
+// Templates are synthetic and submissions are verified with the ThreadPool and the configured difficulty
+package mockdaemon
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zeebo/blake3"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/address"
+	"github.com/xelpool/xelishash/difficulty"
+	"github.com/xelpool/xelishash/getwork"
+)
+
+var (
+	ErrStaleWork     = errors.New("mockdaemon: stale or unknown work")
+	ErrLowDifficulty = errors.New("mockdaemon: hash does not meet the difficulty")
+)
+
+type Config struct {
+	// Difficulty of every block, defaults to 1
+	Difficulty uint64
+	// Algorithm used to verify the blocks, defaults to xel/1
+	Algorithm string
+	// BlockInterval is how often a new template is generated when no block is found, 0 disables it
+	BlockInterval time.Duration
+	// Threads used to verify the submissions, defaults to 1
+	Threads int
+}
+
+// Daemon holds the current synthetic chain tip
+type Daemon struct {
+	config Config
+	pool   *xelishash.ThreadPool
+	target difficulty.Target
+
+	accepted atomic.Uint64
+	rejected atomic.Uint64
+
+	mu sync.Mutex
+	// height of the template being mined
+	height    uint64
+	work_hash xelishash.Hash
+	clients   map[*client]struct{}
+}
+
+type client struct {
+	mu         sync.Mutex
+	conn       *websocket.Conn
+	public_key [xelishash.PUBLIC_KEY_SIZE]byte
+}
+
+func (c *client) send(message getwork.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn.WriteJSON(message)
+}
+
+func New(config Config) (*Daemon, error) {
+	if config.Difficulty == 0 {
+		config.Difficulty = 1
+	}
+	if config.Algorithm == "" {
+		config.Algorithm = xelishash.ALGO_V2
+	}
+	if config.Threads < 1 {
+		config.Threads = 1
+	}
+
+	switch config.Algorithm {
+	case xelishash.ALGO_V1, xelishash.ALGO_V2, xelishash.ALGO_DEV:
+	default:
+		return nil, xelishash.ErrUnknownAlgorithm
+	}
+
+	target, err := difficulty.DifficultyToTarget(config.Difficulty)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Daemon{
+		config:  config,
+		pool:    xelishash.NewThreadPool(config.Threads),
+		target:  target,
+		clients: make(map[*client]struct{}),
+	}
+	d.mu.Lock()
+	d.next()
+	d.mu.Unlock()
+
+	return d, nil
+}
+
+// next moves to a new template at the next height, the lock must be held
+func (d *Daemon) next() {
+	d.height++
+
+	var seed [16]byte
+	binary.BigEndian.PutUint64(seed[:8], d.height)
+	binary.BigEndian.PutUint64(seed[8:], uint64(time.Now().UnixNano()))
+	d.work_hash = blake3.Sum256(seed[:])
+}
+
+// publicKey returns the key of a valid address, or derives a synthetic one from names like "xel:miner"
+func publicKey(s string) [xelishash.PUBLIC_KEY_SIZE]byte {
+	if a, err := address.Parse(s); err == nil {
+		return a.PublicKey
+	}
+	return blake3.Sum256([]byte(s))
+}
+
+// job builds the new job message of the current template for the given address
+func (d *Daemon) job(address string) *getwork.NewJob {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.jobFor(publicKey(address))
+}
+
+func (d *Daemon) jobFor(public_key [xelishash.PUBLIC_KEY_SIZE]byte) *getwork.NewJob {
+	work := xelishash.NewMinerWork(d.work_hash, uint64(time.Now().UnixMilli()), 0, [xelishash.EXTRA_NONCE_SIZE]byte{}, public_key)
+
+	return &getwork.NewJob{
+		Algorithm:  d.config.Algorithm,
+		MinerWork:  work,
+		Height:     d.height,
+		TopoHeight: d.height,
+		Difficulty: getwork.Difficulty(d.config.Difficulty),
+	}
+}
+
+// Height returns the height of the template being mined
+func (d *Daemon) Height() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.height
+}
+
+// Accepted returns the number of blocks accepted so far
+func (d *Daemon) Accepted() uint64 {
+	return d.accepted.Load()
+}
+
+// Rejected returns the number of blocks rejected so far
+func (d *Daemon) Rejected() uint64 {
+	return d.rejected.Load()
+}
+
+// Hash computes the PoW hash of the work with the configured algorithm
+func (d *Daemon) Hash(work xelishash.MinerWork) xelishash.Hash {
+	if d.config.Algorithm == xelishash.ALGO_V1 {
+		input := work.V1()
+		return d.pool.XelisHash(input[:])
+	}
+	// New only accepts the built-in algorithms, which can't fail on a miner work
+	return d.pool.Hash(d.config.Algorithm, work[:])
+}
+
+// Submit verifies the miner work against the current template
+// An accepted block moves the chain to the next height and notifies the getwork clients
+func (d *Daemon) Submit(work xelishash.MinerWork) error {
+	d.mu.Lock()
+	current := d.work_hash
+	d.mu.Unlock()
+
+	if work.WorkHash() != current {
+		d.rejected.Add(1)
+		return ErrStaleWork
+	}
+
+	if !d.target.Check(d.Hash(work)) {
+		d.rejected.Add(1)
+		return ErrLowDifficulty
+	}
+
+	d.mu.Lock()
+	// another submission may have been accepted while hashing
+	if d.work_hash != current {
+		d.mu.Unlock()
+		d.rejected.Add(1)
+		return ErrStaleWork
+	}
+	d.accepted.Add(1)
+	d.next()
+	d.mu.Unlock()
+
+	d.broadcast()
+	return nil
+}
+
+// NewTemplate generates a new template at the next height, as if another miner found a block
+func (d *Daemon) NewTemplate() {
+	d.mu.Lock()
+	d.next()
+	d.mu.Unlock()
+
+	d.broadcast()
+}
+
+func (d *Daemon) broadcast() {
+	d.mu.Lock()
+	clients := make(map[*client]*getwork.NewJob, len(d.clients))
+	for c := range d.clients {
+		clients[c] = d.jobFor(c.public_key)
+	}
+	d.mu.Unlock()
+
+	for c, job := range clients {
+		c.send(getwork.Message{NewJob: job})
+	}
+}
+
+// Handler serves the getwork endpoint on /getwork/<address>/<worker> and JSON-RPC on /json_rpc
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/getwork/", d.serveGetwork)
+	mux.HandleFunc("/json_rpc", d.serveRPC)
+	return mux
+}
+
+func (d *Daemon) serveGetwork(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/getwork/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.Error(w, "expected /getwork/<address>/<worker>", http.StatusBadRequest)
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	c := &client{
+		conn:       conn,
+		public_key: publicKey(parts[0]),
+	}
+
+	d.mu.Lock()
+	d.clients[c] = struct{}{}
+	job := d.jobFor(c.public_key)
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		delete(d.clients, c)
+		d.mu.Unlock()
+	}()
+
+	if err := c.send(getwork.Message{NewJob: job}); err != nil {
+		return
+	}
+
+	for {
+		var submit getwork.SubmitMinerWork
+		if err := conn.ReadJSON(&submit); err != nil {
+			return
+		}
+
+		message := getwork.Message{BlockAccepted: true}
+		if err := d.Submit(submit.MinerWork); err != nil {
+			reason := err.Error()
+			message = getwork.Message{BlockRejected: &reason}
+		}
+		if err := c.send(message); err != nil {
+			return
+		}
+	}
+}
+
+// Run generates a new template every block interval until the context is done
+func (d *Daemon) Run(ctx context.Context) error {
+	if d.config.BlockInterval <= 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	ticker := time.NewTicker(d.config.BlockInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			d.NewTemplate()
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package mockdaemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/address"
+	"github.com/xelpool/xelishash/getwork"
+	"github.com/xelpool/xelishash/miner"
+)
+
+func newDaemon(t *testing.T, config Config) (*Daemon, *httptest.Server) {
+	t.Helper()
+
+	daemon, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(daemon.Handler())
+	t.Cleanup(server.Close)
+
+	return daemon, server
+}
+
+func TestGetwork(t *testing.T) {
+	daemon, server := newDaemon(t, Config{Difficulty: 16, Algorithm: xelishash.ALGO_DEV})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := getwork.NewClient(getwork.Config{
+		URL: "ws" + strings.TrimPrefix(server.URL, "http") + "/getwork/xel:miner/rig0",
+	})
+	go client.Run(ctx)
+
+	engine := miner.NewEngine(2)
+	go engine.Run(ctx)
+
+	for height := uint64(1); height <= 3; height++ {
+		var job getwork.Job
+		select {
+		case job = <-client.Jobs():
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for a job")
+		}
+		if job.Height != height || job.Difficulty != 16 {
+			t.Fatalf("incorrect job at height %d: %+v", job.Height, job)
+		}
+		if job.Work.PublicKey() != publicKey("xel:miner") {
+			t.Fatal("incorrect miner key")
+		}
+
+		if err := engine.SetJob(job.Job); err != nil {
+			t.Fatal(err)
+		}
+
+		// skip the solutions of the previous job still waiting in the channel
+		var solution miner.Solution
+		for solution.JobID != job.ID {
+			select {
+			case solution = <-engine.Solutions():
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for a solution")
+			}
+		}
+		if err := client.Submit(solution); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case result := <-client.Results():
+			if !result.Accepted {
+				t.Fatalf("block rejected: %s", result.Reason)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for a result")
+		}
+	}
+
+	if daemon.Accepted() != 3 || daemon.Rejected() != 0 || daemon.Height() != 4 {
+		t.Fatalf("incorrect counters: height %d, %d accepted, %d rejected", daemon.Height(), daemon.Accepted(), daemon.Rejected())
+	}
+}
+
+func call(t *testing.T, url string, method string, params interface{}, result interface{}) *RPCError {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	resp, err := http.Post(url+"/json_rpc", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Error == nil && result != nil {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return response.Error
+}
+
+func TestRPC(t *testing.T) {
+	daemon, server := newDaemon(t, Config{Difficulty: 4, Algorithm: xelishash.ALGO_V1})
+
+	var template GetBlockTemplateResult
+	if err := call(t, server.URL, "get_block_template", GetBlockTemplateParams{Address: "xel:miner"}, &template); err != nil {
+		t.Fatal(err)
+	}
+	if template.Height != 1 || template.Algorithm != xelishash.ALGO_V1 || template.Difficulty != 4 {
+		t.Fatalf("incorrect template %+v", template)
+	}
+
+	// find an invalid and a valid nonce for xel/0
+	var valid, invalid *xelishash.MinerWork
+	for nonce := uint64(0); valid == nil || invalid == nil; nonce++ {
+		work := template.Template
+		work.SetNonce(nonce)
+		if daemon.target.Check(daemon.Hash(work)) {
+			valid = &work
+		} else {
+			invalid = &work
+		}
+	}
+
+	err := call(t, server.URL, "submit_block", SubmitBlockParams{BlockTemplate: template.Template, MinerWork: invalid}, nil)
+	if err == nil || err.Message != ErrLowDifficulty.Error() {
+		t.Fatalf("got error %v, expected %v", err, ErrLowDifficulty)
+	}
+
+	var accepted bool
+	if err := call(t, server.URL, "submit_block", SubmitBlockParams{BlockTemplate: *valid}, &accepted); err != nil || !accepted {
+		t.Fatalf("valid block rejected: %v", err)
+	}
+
+	// the chain moved on, so the same work is now stale
+	err = call(t, server.URL, "submit_block", SubmitBlockParams{BlockTemplate: *valid}, nil)
+	if err == nil || err.Message != ErrStaleWork.Error() {
+		t.Fatalf("got error %v, expected %v", err, ErrStaleWork)
+	}
+
+	if err := call(t, server.URL, "unknown_method", nil, nil); err == nil || err.Code != CODE_METHOD_NOT_FOUND {
+		t.Fatalf("got error %v, expected method not found", err)
+	}
+
+	if daemon.Accepted() != 1 || daemon.Rejected() != 2 || daemon.Height() != 2 {
+		t.Fatalf("incorrect counters: height %d, %d accepted, %d rejected", daemon.Height(), daemon.Accepted(), daemon.Rejected())
+	}
+}
+
+func TestBlockInterval(t *testing.T) {
+	daemon, _ := newDaemon(t, Config{BlockInterval: 5 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- daemon.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for daemon.Height() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("no new template generated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("got error %v, expected %v", err, context.Canceled)
+	}
+
+	if _, err := New(Config{Algorithm: "xel/unknown"}); err != xelishash.ErrUnknownAlgorithm {
+		t.Fatalf("got error %v, expected %v", err, xelishash.ErrUnknownAlgorithm)
+	}
+}
+
+func TestPublicKey(t *testing.T) {
+	a, err := address.Parse("xel:vs3mfyywt0fjys0rgslue7mm4wr23xdgejsjk0ld7f2kxng4d4nqqnkdufz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKey(a.String()) != a.PublicKey {
+		t.Fatal("a valid address must be mined with its key")
+	}
+	if publicKey("xel:miner") == publicKey("xel:other") {
+		t.Fatal("the synthetic keys must differ per name")
+	}
+}
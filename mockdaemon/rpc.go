@@ -0,0 +1,114 @@
+package mockdaemon
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/getwork"
+)
+
+// JSON-RPC error codes
+const (
+	CODE_PARSE_ERROR      = -32700
+	CODE_INVALID_REQUEST  = -32600
+	CODE_METHOD_NOT_FOUND = -32601
+	CODE_INVALID_PARAMS   = -32602
+	CODE_INTERNAL_ERROR   = -32603
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+type GetBlockTemplateParams struct {
+	Address string `json:"address"`
+}
+
+// GetBlockTemplateResult holds a synthetic template
+// The mock daemon has no real block header, so the template is the miner work itself
+type GetBlockTemplateResult struct {
+	Template   xelishash.MinerWork `json:"template"`
+	Algorithm  string              `json:"algorithm"`
+	Height     uint64              `json:"height"`
+	TopoHeight uint64              `json:"topoheight"`
+	Difficulty getwork.Difficulty  `json:"difficulty"`
+}
+
+type SubmitBlockParams struct {
+	BlockTemplate xelishash.MinerWork  `json:"block_template"`
+	MinerWork     *xelishash.MinerWork `json:"miner_work,omitempty"`
+}
+
+func (d *Daemon) serveRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request rpcRequest
+	response := rpcResponse{JSONRPC: "2.0"}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		response.Error = &RPCError{Code: CODE_PARSE_ERROR, Message: err.Error()}
+	} else {
+		response.ID = request.ID
+		response.Result, response.Error = d.call(request.Method, request.Params)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (d *Daemon) call(method string, params json.RawMessage) (interface{}, *RPCError) {
+	switch method {
+	case "get_block_template":
+		var p GetBlockTemplateParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &RPCError{Code: CODE_INVALID_PARAMS, Message: err.Error()}
+		}
+
+		job := d.job(p.Address)
+		return GetBlockTemplateResult{
+			Template:   job.MinerWork,
+			Algorithm:  job.Algorithm,
+			Height:     job.Height,
+			TopoHeight: job.TopoHeight,
+			Difficulty: job.Difficulty,
+		}, nil
+	case "submit_block":
+		var p SubmitBlockParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &RPCError{Code: CODE_INVALID_PARAMS, Message: err.Error()}
+		}
+
+		work := p.BlockTemplate
+		if p.MinerWork != nil {
+			work = *p.MinerWork
+		}
+		if err := d.Submit(work); err != nil {
+			return nil, &RPCError{Code: CODE_INTERNAL_ERROR, Message: err.Error()}
+		}
+		return true, nil
+	}
+
+	return nil, &RPCError{Code: CODE_METHOD_NOT_FOUND, Message: "method not found"}
+}
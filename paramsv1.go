@@ -0,0 +1,101 @@
+package xelishash
+
+import (
+	"errors"
+	"math/bits"
+)
+
+var (
+	ErrInvalidIters      = errors.New("xelishash: stage 2 iterations must be at least 1")
+	ErrInvalidBufferSize = errors.New("xelishash: buffer size must be at least 1")
+	ErrInvalidSlotLength = errors.New("xelishash: slot length must divide the small pad and fit in u16 indices")
+)
+
+// ParamsV1 holds the tweakable parameters of XelisHash
+// Only DefaultParamsV1 produces consensus valid hashes,
+// other values are meant for private networks and test suites
+type ParamsV1 struct {
+	// Size of the scratch pad in u64s
+	// Stage 3 derives its address masks from it, so it must be a power of two
+	MemorySize int
+	// Iterations of stage 3, the last 4 of them produce the hash
+	ScratchpadIters int
+	// Iterations of stage 2
+	Iters int
+	// Size of the memory buffers in stage 3
+	BufferSize int
+	// Number of u32 slots in stage 2
+	SlotLength int
+}
+
+// DefaultParamsV1 returns the consensus parameters used by XelisHash
+func DefaultParamsV1() ParamsV1 {
+	return ParamsV1{
+		MemorySize:      MEMORY_SIZE,
+		ScratchpadIters: SCRATCHPAD_ITERS,
+		Iters:           ITERS,
+		BufferSize:      BUFFER_SIZE,
+		SlotLength:      SLOT_LENGTH,
+	}
+}
+
+// Validate returns an error if the parameters can't be used to run the algorithm
+func (p ParamsV1) Validate() error {
+	// stage 1 fills at least one full keccak state
+	if p.MemorySize < KECCAK_WORDS || bits.OnesCount64(uint64(p.MemorySize)) != 1 {
+		return ErrInvalidMemorySize
+	}
+	if p.ScratchpadIters < HASH_SIZE/8 {
+		return ErrInvalidScratchpadIter
+	}
+	if p.Iters < 1 {
+		return ErrInvalidIters
+	}
+	if p.BufferSize < 1 {
+		return ErrInvalidBufferSize
+	}
+	small_pad_size := p.MemorySize * 2
+	if p.SlotLength < 1 || p.SlotLength > 1<<16 || small_pad_size%p.SlotLength != 0 {
+		return ErrInvalidSlotLength
+	}
+	return nil
+}
+
+// HasherV1 runs XelisHash with custom parameters
+// It owns its scratch pad, so it must not be used concurrently
+type HasherV1 struct {
+	params       ParamsV1
+	scratch_pad  []uint64
+	slots        []uint32
+	indices      []uint16
+	mem_buffer_a []uint64
+	mem_buffer_b []uint64
+}
+
+func NewHasherV1(params ParamsV1) (*HasherV1, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &HasherV1{
+		params:       params,
+		scratch_pad:  make([]uint64, params.MemorySize),
+		slots:        make([]uint32, params.SlotLength),
+		indices:      make([]uint16, params.SlotLength),
+		mem_buffer_a: make([]uint64, params.BufferSize),
+		mem_buffer_b: make([]uint64, params.BufferSize),
+	}, nil
+}
+
+func (h *HasherV1) Params() ParamsV1 {
+	return h.params
+}
+
+// Hash computes the hash of the input, which must be BYTES_ARRAY_INPUT bytes long
+func (h *HasherV1) Hash(input []byte) (Hash, error) {
+	if len(input) != BYTES_ARRAY_INPUT {
+		return Hash{}, ErrInvalidInputSize
+	}
+
+	return xelis_hash_v1(input, h.scratch_pad, &h.params, h.slots, h.indices, h.mem_buffer_a, h.mem_buffer_b), nil
+}
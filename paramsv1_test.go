@@ -0,0 +1,87 @@
+package xelishash
+
+import (
+	"testing"
+)
+
+func TestHasherV1DefaultParams(t *testing.T) {
+	hasher, err := NewHasherV1(DefaultParamsV1())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scratchpad ScratchPad
+	input := make([]byte, BYTES_ARRAY_INPUT)
+	copy(input, []byte("xelis-hashing-algorithm"))
+
+	hash, err := hasher.Hash(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := XelisHash(input, &scratchpad)
+	if hash != expected {
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expected)
+	}
+
+	if _, err := hasher.Hash(input[:112]); err != ErrInvalidInputSize {
+		t.Fatalf("got error %v, expected %v", err, ErrInvalidInputSize)
+	}
+}
+
+func TestHasherV1CustomParams(t *testing.T) {
+	params := ParamsV1{
+		MemorySize:      1024,
+		ScratchpadIters: 64,
+		Iters:           2,
+		BufferSize:      16,
+		SlotLength:      64,
+	}
+
+	hasher, err := NewHasherV1(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := make([]byte, BYTES_ARRAY_INPUT)
+	hash, err := hasher.Hash(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := hasher.Hash(input); hash != again {
+		t.Fatal("hasher is not deterministic")
+	}
+
+	var scratchpad ScratchPad
+	if hash == XelisHash(input, &scratchpad) {
+		t.Fatal("custom params must not produce the consensus hash")
+	}
+}
+
+func TestParamsV1Validate(t *testing.T) {
+	tests := []struct {
+		update func(*ParamsV1)
+		err    error
+	}{
+		{func(p *ParamsV1) {}, nil},
+		{func(p *ParamsV1) { p.MemorySize, p.SlotLength = 32, 64 }, nil},
+		{func(p *ParamsV1) { p.MemorySize = 16 }, ErrInvalidMemorySize},
+		{func(p *ParamsV1) { p.MemorySize = 30000 }, ErrInvalidMemorySize},
+		{func(p *ParamsV1) { p.ScratchpadIters = 3 }, ErrInvalidScratchpadIter},
+		{func(p *ParamsV1) { p.Iters = 0 }, ErrInvalidIters},
+		{func(p *ParamsV1) { p.BufferSize = 0 }, ErrInvalidBufferSize},
+		{func(p *ParamsV1) { p.SlotLength = 0 }, ErrInvalidSlotLength},
+		{func(p *ParamsV1) { p.SlotLength = 100 }, ErrInvalidSlotLength},
+		{func(p *ParamsV1) { p.MemorySize, p.SlotLength = 1<<16, 1<<17 }, ErrInvalidSlotLength},
+	}
+
+	for i, test := range tests {
+		params := DefaultParamsV1()
+		test.update(&params)
+		if err := params.Validate(); err != test.err {
+			t.Fatalf("test %d: got error %v, expected %v", i, err, test.err)
+		}
+		if _, err := NewHasherV1(params); err != test.err {
+			t.Fatalf("test %d: NewHasherV1 got error %v, expected %v", i, err, test.err)
+		}
+	}
+}
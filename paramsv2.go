@@ -0,0 +1,106 @@
+package xelishash
+
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/zeebo/blake3"
+)
+
+var (
+	ErrInvalidMemorySize     = errors.New("xelishash: invalid memory size")
+	ErrInvalidScratchpadIter = errors.New("xelishash: invalid scratchpad iterations")
+	ErrInvalidChunkSize      = errors.New("xelishash: chunk size must be between 1 and HASH_SIZE")
+	ErrInvalidInputSize      = errors.New("xelishash: invalid input size")
+)
+
+// ParamsV2 holds the tweakable parameters of XelisHashV2
+// Only DefaultParamsV2 produces consensus valid hashes,
+// other values are meant for testnets and research
+type ParamsV2 struct {
+	// Size of the scratch pad in u64s, split in two halves in stage 3
+	MemorySize int
+	// Iterations of the outer loop in stage 3
+	ScratchpadIters int
+	// Size of the input chunks in stage 1
+	ChunkSize int
+	// AES key used in stage 3
+	Key [16]byte
+}
+
+// DefaultParamsV2 returns the consensus parameters used by XelisHashV2
+func DefaultParamsV2() ParamsV2 {
+	return ParamsV2{
+		MemorySize:      MEMORY_SIZE_V2,
+		ScratchpadIters: SCRATCHPAD_ITERS_V2,
+		ChunkSize:       CHUNK_SIZE_V2,
+		Key:             [16]byte([]byte(KEY)),
+	}
+}
+
+// Validate returns an error if the parameters can't be used to run the algorithm
+// The memory size must be a non-zero even number and at least one iteration is required
+func (p ParamsV2) Validate() error {
+	if p.MemorySize < 2 || p.MemorySize%2 != 0 {
+		return ErrInvalidMemorySize
+	}
+	if p.ScratchpadIters < 1 {
+		return ErrInvalidScratchpadIter
+	}
+	// stage 1 hashes each chunk together with the previous HASH_SIZE bytes hash
+	if p.ChunkSize < 1 || p.ChunkSize > HASH_SIZE {
+		return ErrInvalidChunkSize
+	}
+	return nil
+}
+
+// checkInputV2 returns ErrInvalidInputSize if stage 1 can't split the scratch pad of memory_size u64s evenly between the input chunks
+func checkInputV2(input_size int, memory_size int, chunk_size int) error {
+	num_chunks := (input_size + chunk_size - 1) / chunk_size
+	if num_chunks == 0 || (memory_size*8)%num_chunks != 0 {
+		return ErrInvalidInputSize
+	}
+	return nil
+}
+
+// HasherV2 runs XelisHashV2 with custom parameters
+// It owns its scratch pad, so it must not be used concurrently
+type HasherV2 struct {
+	params      ParamsV2
+	scratch_pad []uint64
+}
+
+func NewHasherV2(params ParamsV2) (*HasherV2, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &HasherV2{
+		params:      params,
+		scratch_pad: make([]uint64, params.MemorySize),
+	}, nil
+}
+
+func (h *HasherV2) Params() ParamsV2 {
+	return h.params
+}
+
+// Hash computes the hash of the input, reusing the scratch pad of the hasher
+// Stage 1 splits the scratch pad evenly between the input chunks, so the input
+// is rejected if it is empty or its chunk count doesn't divide the scratch pad size
+func (h *HasherV2) Hash(input []byte) (Hash, error) {
+	if err := checkInputV2(len(input), len(h.scratch_pad), h.params.ChunkSize); err != nil {
+		return Hash{}, err
+	}
+
+	scratchpad_bytes := unsafe.Slice((*byte)(unsafe.Pointer(&h.scratch_pad[0])), len(h.scratch_pad)*8)
+
+	// stage 1
+	stage_1_v2(input, scratchpad_bytes, h.params.ChunkSize)
+
+	// stage 3
+	stage_3(h.scratch_pad, h.params.ScratchpadIters, &h.params.Key)
+
+	// stage 4
+	return blake3.Sum256(scratchpad_bytes), nil
+}
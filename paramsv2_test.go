@@ -0,0 +1,171 @@
+package xelishash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/chocolatkey/chacha8"
+	"github.com/zeebo/blake3"
+)
+
+// CHUNK_SIZE_28_VECTOR is the hash of the 0..111 input with a 256 u64s memory, 2 iterations,
+// chunks of 28 bytes and the "xelishash-testnt" key
+const CHUNK_SIZE_28_VECTOR = "3e1d6809fbe08f43a049adb6f4ff9a588472ff4c2f6f5bb551f340accb9b56dc"
+
+func TestHasherV2DefaultParams(t *testing.T) {
+	hasher, err := NewHasherV2(DefaultParamsV2())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scratchpad ScratchPadV2
+	input := make([]byte, 112)
+	for i := 0; i < 4; i++ {
+		input[0] = byte(i)
+		expected := XelisHashV2(input, &scratchpad)
+		hash, err := hasher.Hash(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != expected {
+			t.Fatalf("incorrect hash: %s, expected: %s", hash, expected)
+		}
+	}
+}
+
+func TestHasherV2CustomParams(t *testing.T) {
+	params := DefaultParamsV2()
+	params.MemorySize = 256
+	params.ScratchpadIters = 2
+	params.ChunkSize = 28
+	copy(params.Key[:], "xelishash-testnt")
+
+	hasher, err := NewHasherV2(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := make([]byte, 112)
+	hash, err := hasher.Hash(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := hasher.Hash(input); hash != again {
+		t.Fatal("hasher is not deterministic")
+	}
+
+	var scratchpad ScratchPadV2
+	if hash == XelisHashV2(input, &scratchpad) {
+		t.Fatal("custom params must not produce the consensus hash")
+	}
+
+	params.Key[0] ^= 1
+	other, err := NewHasherV2(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherHash, _ := other.Hash(input); hash == otherHash {
+		t.Fatal("the key must change the hash")
+	}
+}
+
+// TestStage1ChunkSize checks that each chunk of stage 1 only covers its own ChunkSize bytes
+// against a straightforward implementation of the stage
+func TestStage1ChunkSize(t *testing.T) {
+	input := make([]byte, 112)
+	for i := range input {
+		input[i] = byte(i)
+	}
+
+	for _, chunk_size := range []int{28, 14, 32} {
+		num_chunks := (len(input) + chunk_size - 1) / chunk_size
+		expected := make([]byte, 256*8)
+
+		input_hash := blake3.Sum256(input)
+		nonce := append([]byte(nil), input_hash[:NONCE_SIZE_V2]...)
+		part_size := len(expected) / num_chunks
+		for i := 0; i < num_chunks; i++ {
+			tmp := make([]byte, HASH_SIZE*2)
+			copy(tmp, input_hash[:])
+			chunk := input[i*chunk_size:]
+			if len(chunk) > chunk_size {
+				chunk = chunk[:chunk_size]
+			}
+			copy(tmp[HASH_SIZE:], chunk)
+			input_hash = blake3.Sum256(tmp)
+
+			cipher, err := chacha8.New(input_hash[:], nonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			part := expected[i*part_size : (i+1)*part_size]
+			cipher.KeyStream(part)
+			nonce = append([]byte(nil), part[len(part)-NONCE_SIZE_V2:]...)
+		}
+
+		output := make([]byte, len(expected))
+		stage_1_v2(input, output, chunk_size)
+		if !bytes.Equal(output, expected) {
+			t.Fatalf("chunk size %d: incorrect stage 1 output", chunk_size)
+		}
+	}
+
+	// regression vector of the custom parameters of TestHasherV2CustomParams
+	params := DefaultParamsV2()
+	params.MemorySize = 256
+	params.ScratchpadIters = 2
+	params.ChunkSize = 28
+	copy(params.Key[:], "xelishash-testnt")
+	hasher, err := NewHasherV2(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := hasher.Hash(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(hash[:]) != CHUNK_SIZE_28_VECTOR {
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, CHUNK_SIZE_28_VECTOR)
+	}
+}
+
+func TestHasherV2InvalidInput(t *testing.T) {
+	hasher, err := NewHasherV2(DefaultParamsV2())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 200 bytes are 7 chunks, which don't divide the default scratch pad
+	for _, size := range []int{0, 200} {
+		if _, err := hasher.Hash(make([]byte, size)); err != ErrInvalidInputSize {
+			t.Fatalf("input of %d bytes: got error %v, expected %v", size, err, ErrInvalidInputSize)
+		}
+	}
+}
+
+func TestParamsV2Validate(t *testing.T) {
+	tests := []struct {
+		update func(*ParamsV2)
+		err    error
+	}{
+		{func(p *ParamsV2) {}, nil},
+		{func(p *ParamsV2) { p.MemorySize = 2 }, nil},
+		{func(p *ParamsV2) { p.MemorySize = 0 }, ErrInvalidMemorySize},
+		{func(p *ParamsV2) { p.MemorySize = 1025 }, ErrInvalidMemorySize},
+		{func(p *ParamsV2) { p.ScratchpadIters = 0 }, ErrInvalidScratchpadIter},
+		{func(p *ParamsV2) { p.ChunkSize = 0 }, ErrInvalidChunkSize},
+		{func(p *ParamsV2) { p.ChunkSize = HASH_SIZE + 1 }, ErrInvalidChunkSize},
+	}
+
+	for i, test := range tests {
+		params := DefaultParamsV2()
+		test.update(&params)
+		if err := params.Validate(); err != test.err {
+			t.Fatalf("test %d: got error %v, expected %v", i, err, test.err)
+		}
+		if _, err := NewHasherV2(params); err != test.err {
+			t.Fatalf("test %d: NewHasherV2 got error %v, expected %v", i, err, test.err)
+		}
+	}
+}
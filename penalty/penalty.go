@@ -0,0 +1,322 @@
+// Package penalty scores pool connections on the shares they send
+//
+// Each connection and each IP keeps the outcomes of its last shares.
+// When the ratio of invalid, stale or duplicate shares goes over the configured limits,
+// the peer is throttled, disconnected or its IP is banned for a while,
+// so it stops costing hashes to the share verifier
+package penalty
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock returns the current time, it can be replaced in tests
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Outcome is the result of the validation of a share
+type Outcome int
+
+const (
+	OutcomeValid Outcome = iota
+	// OutcomeInvalid is a share failing the hash check or with malformed params
+	OutcomeInvalid
+	OutcomeStale
+	OutcomeDuplicate
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeValid:
+		return "valid"
+	case OutcomeInvalid:
+		return "invalid"
+	case OutcomeStale:
+		return "stale"
+	case OutcomeDuplicate:
+		return "duplicate"
+	}
+	return "unknown"
+}
+
+// Action is the penalty applied to a peer, from the mildest to the harshest
+type Action int
+
+const (
+	ActionNone Action = iota
+	// ActionThrottle delays the next share of the connection by Config.ThrottleDelay
+	ActionThrottle
+	// ActionDisconnect closes the connection
+	ActionDisconnect
+	// ActionBan closes the connection and refuses its IP for Config.BanDuration
+	ActionBan
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionNone:
+		return "none"
+	case ActionThrottle:
+		return "throttle"
+	case ActionDisconnect:
+		return "disconnect"
+	case ActionBan:
+		return "ban"
+	}
+	return "unknown"
+}
+
+// Limits are the ratios of the shares of a kind over which an action is taken, 0 disables the action
+type Limits struct {
+	Throttle   float64
+	Disconnect float64
+	Ban        float64
+}
+
+func (l *Limits) action(ratio float64) Action {
+	switch {
+	case l.Ban > 0 && ratio >= l.Ban:
+		return ActionBan
+	case l.Disconnect > 0 && ratio >= l.Disconnect:
+		return ActionDisconnect
+	case l.Throttle > 0 && ratio >= l.Throttle:
+		return ActionThrottle
+	}
+	return ActionNone
+}
+
+type Config struct {
+	// Window is the number of recent shares the ratios are computed on, defaults to 100
+	Window int
+	// MinShares is the number of shares in the window before any action is taken, defaults to 20
+	MinShares int
+
+	Invalid   Limits
+	Stale     Limits
+	Duplicate Limits
+
+	// ThrottleDelay defaults to 1 second, BanDuration to 10 minutes
+	ThrottleDelay time.Duration
+	BanDuration   time.Duration
+
+	// Clock defaults to the system clock
+	Clock Clock
+}
+
+// DefaultConfig bans the IPs sending mostly invalid shares and throttles the ones sending many stale shares
+var DefaultConfig = Config{
+	Invalid:   Limits{Throttle: 0.1, Disconnect: 0.25, Ban: 0.5},
+	Stale:     Limits{Throttle: 0.5},
+	Duplicate: Limits{Throttle: 0.05, Disconnect: 0.1, Ban: 0.25},
+}
+
+// score keeps the outcomes of the last shares in a ring
+type score struct {
+	outcomes []Outcome
+	next     int
+	counts   [4]int
+}
+
+func (s *score) record(outcome Outcome, window int) {
+	if len(s.outcomes) < window {
+		s.outcomes = append(s.outcomes, outcome)
+	} else {
+		s.counts[s.outcomes[s.next]]--
+		s.outcomes[s.next] = outcome
+		s.next = (s.next + 1) % window
+	}
+	s.counts[outcome]++
+}
+
+func (s *score) action(config *Config) Action {
+	total := len(s.outcomes)
+	if total < config.MinShares {
+		return ActionNone
+	}
+
+	action := config.Invalid.action(float64(s.counts[OutcomeInvalid]) / float64(total))
+	if a := config.Stale.action(float64(s.counts[OutcomeStale]) / float64(total)); a > action {
+		action = a
+	}
+	if a := config.Duplicate.action(float64(s.counts[OutcomeDuplicate]) / float64(total)); a > action {
+		action = a
+	}
+	return action
+}
+
+type ipScore struct {
+	score
+	connections  int
+	banned_until time.Time
+}
+
+// Tracker scores the connections and their IPs
+// It is safe for concurrent use
+type Tracker struct {
+	config Config
+
+	mu  sync.Mutex
+	ips map[string]*ipScore
+	// sweep_at is the number of IPs at which the expired bans are dropped
+	sweep_at int
+}
+
+func NewTracker(config Config) *Tracker {
+	if config.Window < 1 {
+		config.Window = 100
+	}
+	if config.MinShares < 1 {
+		config.MinShares = 20
+	}
+	if config.MinShares > config.Window {
+		config.MinShares = config.Window
+	}
+	if config.ThrottleDelay <= 0 {
+		config.ThrottleDelay = time.Second
+	}
+	if config.BanDuration <= 0 {
+		config.BanDuration = 10 * time.Minute
+	}
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+
+	return &Tracker{
+		config: config,
+		ips:    make(map[string]*ipScore),
+	}
+}
+
+// ThrottleDelay is how long a throttled connection waits before its next share
+func (t *Tracker) ThrottleDelay() time.Duration {
+	return t.config.ThrottleDelay
+}
+
+// Banned reports whether the IP is currently banned
+func (t *Tracker) Banned(ip string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.banned(ip)
+}
+
+func (t *Tracker) banned(ip string) bool {
+	score, ok := t.ips[ip]
+	if !ok {
+		return false
+	}
+	if t.config.Clock.Now().Before(score.banned_until) {
+		return true
+	}
+	t.forget(ip, score)
+	return false
+}
+
+// forget drops the IP once it has no connection and no ban, so the memory is bounded by the connections
+func (t *Tracker) forget(ip string, score *ipScore) {
+	if score.connections == 0 && !t.config.Clock.Now().Before(score.banned_until) {
+		delete(t.ips, ip)
+	}
+}
+
+// Connect returns the peer of a new connection from the IP
+// Its Close method must be called once the connection is closed
+func (t *Tracker) Connect(ip string) *Peer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// banned IPs not coming back are dropped from time to time, amortized over the connections
+	if len(t.ips) >= t.sweep_at {
+		for ip, score := range t.ips {
+			t.forget(ip, score)
+		}
+		t.sweep_at = 2*len(t.ips) + 64
+	}
+
+	score, ok := t.ips[ip]
+	if !ok {
+		score = &ipScore{}
+		t.ips[ip] = score
+	}
+	score.connections++
+
+	return &Peer{tracker: t, ip: ip}
+}
+
+// Peer is the score of a single connection
+type Peer struct {
+	tracker *Tracker
+	ip      string
+	score   score
+	closed  bool
+}
+
+// IP returns the IP of the peer
+func (p *Peer) IP() string {
+	return p.ip
+}
+
+// Banned reports whether the IP of the peer is banned, its shares must then be dropped without hashing them
+func (p *Peer) Banned() bool {
+	return p.tracker.Banned(p.ip)
+}
+
+// Record adds the outcome of a share to the scores of the connection and of its IP
+// It returns the harshest action deserved by either of them, a ban takes effect immediately
+func (p *Peer) Record(outcome Outcome) Action {
+	t := p.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p.closed {
+		return ActionDisconnect
+	}
+
+	p.score.record(outcome, t.config.Window)
+	action := p.score.action(&t.config)
+
+	ip := t.ips[p.ip]
+	ip.record(outcome, t.config.Window)
+	if a := ip.action(&t.config); a > action {
+		action = a
+	}
+
+	if action == ActionBan {
+		ip.banned_until = t.config.Clock.Now().Add(t.config.BanDuration)
+		// start over once the ban is lifted
+		ip.score = score{}
+	}
+	return action
+}
+
+// Close releases the connection from the score of its IP
+func (p *Peer) Close() {
+	t := p.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+
+	ip := t.ips[p.ip]
+	ip.connections--
+	t.forget(p.ip, ip)
+}
+
+// IPs returns the number of IPs tracked, connected or banned
+func (t *Tracker) IPs() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.ips)
+}
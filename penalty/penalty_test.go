@@ -0,0 +1,181 @@
+package penalty
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func testConfig(clock *fakeClock) Config {
+	config := DefaultConfig
+	config.Window = 10
+	config.MinShares = 10
+	config.Clock = clock
+	return config
+}
+
+func TestThresholds(t *testing.T) {
+	tests := []struct {
+		name    string
+		valid   int
+		outcome Outcome
+		bad     int
+		action  Action
+	}{
+		{"clean", 10, OutcomeInvalid, 0, ActionNone},
+		{"few invalid", 9, OutcomeInvalid, 1, ActionThrottle},
+		{"many invalid", 7, OutcomeInvalid, 3, ActionDisconnect},
+		{"mostly invalid", 5, OutcomeInvalid, 5, ActionBan},
+		{"stale", 4, OutcomeStale, 6, ActionThrottle},
+		{"all stale", 0, OutcomeStale, 10, ActionThrottle},
+		{"duplicates", 7, OutcomeDuplicate, 3, ActionBan},
+	}
+
+	for _, test := range tests {
+		tracker := NewTracker(testConfig(&fakeClock{}))
+		peer := tracker.Connect("10.0.0.1")
+
+		action := ActionNone
+		for i := 0; i < test.valid; i++ {
+			action = peer.Record(OutcomeValid)
+		}
+		for i := 0; i < test.bad; i++ {
+			action = peer.Record(test.outcome)
+		}
+		if action != test.action {
+			t.Errorf("%s: expected %s, got %s", test.name, test.action, action)
+		}
+	}
+}
+
+func TestMinShares(t *testing.T) {
+	tracker := NewTracker(testConfig(&fakeClock{}))
+	peer := tracker.Connect("10.0.0.1")
+
+	// a few bad shares right after connecting are not enough to judge
+	for i := 0; i < 9; i++ {
+		if action := peer.Record(OutcomeInvalid); action != ActionNone {
+			t.Fatalf("share %d: unexpected %s", i, action)
+		}
+	}
+	if action := peer.Record(OutcomeInvalid); action != ActionBan {
+		t.Fatalf("expected a ban, got %s", action)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	tracker := NewTracker(testConfig(&fakeClock{}))
+	peer := tracker.Connect("10.0.0.1")
+
+	peer.Record(OutcomeInvalid)
+	for i := 0; i < 9; i++ {
+		peer.Record(OutcomeValid)
+	}
+	// the invalid share leaves the window
+	if action := peer.Record(OutcomeValid); action != ActionNone {
+		t.Fatalf("old shares must leave the window, got %s", action)
+	}
+}
+
+func TestBan(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1736271107, 0)}
+	config := testConfig(clock)
+	config.BanDuration = time.Minute
+	tracker := NewTracker(config)
+
+	// the bad shares of two connections add up on their IP
+	first := tracker.Connect("10.0.0.1")
+	second := tracker.Connect("10.0.0.1")
+	other := tracker.Connect("10.0.0.2")
+	for i := 0; i < 4; i++ {
+		first.Record(OutcomeValid)
+		second.Record(OutcomeInvalid)
+		other.Record(OutcomeValid)
+	}
+	first.Record(OutcomeValid)
+	if tracker.Banned("10.0.0.1") {
+		t.Fatal("banned too early")
+	}
+
+	if action := second.Record(OutcomeInvalid); action != ActionBan {
+		t.Fatalf("expected a ban, got %s", action)
+	}
+	if !first.Banned() || !second.Banned() || other.Banned() {
+		t.Fatal("the ban must apply to every connection of the IP only")
+	}
+
+	first.Close()
+	second.Close()
+	if !tracker.Banned("10.0.0.1") || tracker.IPs() != 2 {
+		t.Fatal("the ban must outlive the connections")
+	}
+
+	clock.Advance(time.Minute)
+	if tracker.Banned("10.0.0.1") {
+		t.Fatal("the ban must expire")
+	}
+	if tracker.IPs() != 1 {
+		t.Fatalf("the expired IP must be forgotten, %d IPs left", tracker.IPs())
+	}
+
+	// the score starts over after the ban
+	peer := tracker.Connect("10.0.0.1")
+	for i := 0; i < 9; i++ {
+		if action := peer.Record(OutcomeValid); action != ActionNone {
+			t.Fatalf("unexpected %s after the ban", action)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1736271107, 0)}
+	config := testConfig(clock)
+	config.BanDuration = time.Minute
+	tracker := NewTracker(config)
+
+	// banned IPs never coming back don't grow the tracker forever
+	for i := 0; i < 1000; i++ {
+		peer := tracker.Connect("10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256))
+		for j := 0; j < 10; j++ {
+			peer.Record(OutcomeInvalid)
+		}
+		peer.Close()
+		clock.Advance(time.Second)
+	}
+
+	if tracker.IPs() > 200 {
+		t.Fatalf("%d IPs tracked for 60 active bans", tracker.IPs())
+	}
+}
+
+func TestClosedPeer(t *testing.T) {
+	tracker := NewTracker(testConfig(&fakeClock{}))
+	peer := tracker.Connect("10.0.0.1")
+	peer.Close()
+	peer.Close()
+
+	if tracker.IPs() != 0 {
+		t.Fatal("the IP must be forgotten with its last connection")
+	}
+	if action := peer.Record(OutcomeValid); action != ActionDisconnect {
+		t.Fatalf("a closed peer must be disconnected, got %s", action)
+	}
+}
@@ -0,0 +1,323 @@
+package stratum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xelpool/xelishash/difficulty"
+	"github.com/xelpool/xelishash/miner"
+)
+
+var (
+	ErrNotConnected = errors.New("stratum: not connected")
+	ErrUnauthorized = errors.New("stratum: unauthorized")
+)
+
+type ClientConfig struct {
+	// Address of the pool, as host:port
+	Address string
+	// User is usually <wallet address>.<worker name>
+	User     string
+	Password string
+	Agent    string
+	// MinBackoff is the delay before the first reconnection attempt, doubled after every failure up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Stats counts the answers of the pool to the submitted shares
+type Stats struct {
+	Accepted uint64
+	Rejected uint64
+	Stale    uint64
+}
+
+// Client mines for a pool: it turns notifications into miner jobs
+// using the assigned extra nonce prefix and submits the solutions as shares
+type Client struct {
+	config ClientConfig
+	jobs   chan miner.Job
+
+	accepted atomic.Uint64
+	rejected atomic.Uint64
+	stale    atomic.Uint64
+
+	mu         sync.Mutex
+	conn       net.Conn
+	encoder    *json.Encoder
+	next_id    uint64
+	pending    map[uint64]string
+	prefix     []byte
+	difficulty uint64
+}
+
+func NewClient(config ClientConfig) *Client {
+	if config.Agent == "" {
+		config.Agent = "xelishash"
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = time.Second
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = 30 * config.MinBackoff
+	}
+
+	return &Client{
+		config:     config,
+		jobs:       make(chan miner.Job, 1),
+		difficulty: 1,
+	}
+}
+
+// Jobs returns the channel receiving the jobs notified by the pool
+// A job not received before the next one arrives is dropped
+func (c *Client) Jobs() <-chan miner.Job {
+	return c.jobs
+}
+
+// Stats returns the share counters since the creation of the client
+func (c *Client) Stats() Stats {
+	return Stats{
+		Accepted: c.accepted.Load(),
+		Rejected: c.rejected.Load(),
+		Stale:    c.stale.Load(),
+	}
+}
+
+// Difficulty returns the share difficulty set by the pool
+func (c *Client) Difficulty() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.difficulty
+}
+
+// Run connects to the pool and reads its messages until the context is cancelled
+// The connection is retried with backoff when it fails or gets dropped
+func (c *Client) Run(ctx context.Context) error {
+	var dialer net.Dialer
+	backoff := c.config.MinBackoff
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", c.config.Address)
+		if err == nil {
+			if c.serve(ctx, conn) {
+				backoff = c.config.MinBackoff
+			}
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > c.config.MaxBackoff {
+			backoff = c.config.MaxBackoff
+		}
+	}
+}
+
+// request sends a request, the lock must be held
+// The params must encode to a JSON array
+func (c *Client) request(method string, params interface{}) error {
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+
+	c.next_id++
+	c.pending[c.next_id] = method
+
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.encoder.Encode(Message{
+		ID:     json.RawMessage(strconv.FormatUint(c.next_id, 10)),
+		Method: method,
+		Params: encoded,
+	})
+}
+
+// serve subscribes, authorizes and reads the messages of the connection
+// It returns true if the pool authorized the client
+func (c *Client) serve(ctx context.Context, conn net.Conn) bool {
+	c.mu.Lock()
+	c.conn = conn
+	c.encoder = json.NewEncoder(conn)
+	c.pending = make(map[uint64]string)
+	c.prefix = nil
+	err := c.request(METHOD_SUBSCRIBE, []string{c.config.Agent})
+	if err == nil {
+		err = c.request(METHOD_AUTHORIZE, []string{c.config.User, c.config.Password})
+	}
+	c.mu.Unlock()
+
+	// unblock the read once the context is done
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	defer func() {
+		close(done)
+		conn.Close()
+
+		c.mu.Lock()
+		c.conn = nil
+		c.encoder = nil
+		c.mu.Unlock()
+	}()
+
+	if err != nil {
+		return false
+	}
+
+	var authorized bool
+	decoder := json.NewDecoder(conn)
+	for {
+		var message Message
+		if err := decoder.Decode(&message); err != nil {
+			return authorized
+		}
+
+		if message.IsResponse() {
+			ok, err := c.response(&message)
+			if err != nil {
+				return authorized
+			}
+			authorized = authorized || ok
+			continue
+		}
+
+		switch message.Method {
+		case METHOD_SET_DIFFICULTY:
+			if difficulty, err := DecodeSetDifficulty(message.Params); err == nil {
+				c.mu.Lock()
+				c.difficulty = difficulty
+				c.mu.Unlock()
+			}
+		case METHOD_NOTIFY:
+			var notify NotifyParams
+			if err := json.Unmarshal(message.Params, &notify); err != nil {
+				continue
+			}
+			if job, ok := c.job(&notify); ok {
+				// only the latest job matters, replace the one not received yet
+				select {
+				case <-c.jobs:
+				default:
+				}
+				c.jobs <- job
+			}
+		}
+	}
+}
+
+// response handles the answer to a request
+// It returns true once the client got authorized, and an error if the connection must be dropped
+func (c *Client) response(message *Message) (bool, error) {
+	id, err := strconv.ParseUint(string(message.ID), 10, 64)
+	if err != nil {
+		return false, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	method, ok := c.pending[id]
+	if !ok {
+		return false, nil
+	}
+	delete(c.pending, id)
+
+	switch method {
+	case METHOD_SUBSCRIBE:
+		var result SubscribeResult
+		if message.Error != nil || json.Unmarshal(message.Result, &result) != nil {
+			return false, ErrInvalidParams
+		}
+		c.prefix = result.ExtraNoncePrefix
+	case METHOD_AUTHORIZE:
+		var result bool
+		if message.Error != nil || json.Unmarshal(message.Result, &result) != nil || !result {
+			return false, ErrUnauthorized
+		}
+		return true, nil
+	case METHOD_SUBMIT:
+		var result bool
+		switch {
+		case message.Error != nil && message.Error.Code == CODE_STALE_JOB:
+			c.stale.Add(1)
+		case message.Error == nil && json.Unmarshal(message.Result, &result) == nil && result:
+			c.accepted.Add(1)
+		default:
+			c.rejected.Add(1)
+		}
+	}
+	return false, nil
+}
+
+// job builds a miner job from a notification, with the extra nonce prefix written in the work
+func (c *Client) job(notify *NotifyParams) (miner.Job, bool) {
+	c.mu.Lock()
+	prefix := c.prefix
+	diff := c.difficulty
+	c.mu.Unlock()
+
+	// jobs can't be mined before knowing the prefix
+	if prefix == nil {
+		return miner.Job{}, false
+	}
+
+	target, err := difficulty.DifficultyToTarget(diff)
+	if err != nil {
+		return miner.Job{}, false
+	}
+
+	work := notify.Work
+	extra_nonce := work.ExtraNonce()
+	copy(extra_nonce[:], prefix)
+	work.SetExtraNonce(extra_nonce)
+
+	return miner.Job{
+		ID:               notify.JobID,
+		Work:             work,
+		Algorithm:        notify.Algorithm,
+		Target:           target,
+		ExtraNoncePrefix: len(prefix),
+	}, true
+}
+
+// Submit sends the solution as a share
+// The answer of the pool is counted in the Stats
+func (c *Client) Submit(solution miner.Solution) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil || c.prefix == nil {
+		return ErrNotConnected
+	}
+
+	extra_nonce := solution.Work.ExtraNonce()
+	return c.request(METHOD_SUBMIT, SubmitParams{
+		User:       c.config.User,
+		JobID:      solution.JobID,
+		ExtraNonce: extra_nonce[len(c.prefix):],
+		Nonce:      solution.Work.Nonce(),
+	})
+}
@@ -0,0 +1,245 @@
+package stratum
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/difficulty"
+	"github.com/xelpool/xelishash/miner"
+)
+
+// fakePool answers with a different prefix on each connection
+// The first connection is dropped after its first submission
+type fakePool struct {
+	listener    net.Listener
+	pool        *xelishash.ThreadPool
+	connections atomic.Int32
+}
+
+func newFakePool(t *testing.T) *fakePool {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	p := &fakePool{listener: listener, pool: xelishash.NewThreadPool(1)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *fakePool) serve(conn net.Conn) {
+	defer conn.Close()
+
+	n := p.connections.Add(1)
+	encoder := json.NewEncoder(conn)
+	scanner := bufio.NewScanner(conn)
+
+	reply := func(id json.RawMessage, result interface{}, err *Error) {
+		encoded, _ := json.Marshal(result)
+		if err != nil {
+			encoded = nil
+		}
+		encoder.Encode(Message{ID: id, Result: encoded, Error: err})
+	}
+	notify := func(method string, params interface{}) {
+		encoded, _ := json.Marshal(params)
+		encoder.Encode(Message{ID: json.RawMessage("null"), Method: method, Params: encoded})
+	}
+
+	var work xelishash.MinerWork
+	work[0] = byte(n)
+
+	for scanner.Scan() {
+		var message Message
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return
+		}
+
+		switch message.Method {
+		case METHOD_SUBSCRIBE:
+			reply(message.ID, SubscribeResult{SubscriptionID: "sub", ExtraNoncePrefix: []byte{0xaa, byte(n)}, ExtraNonceSize: 30}, nil)
+		case METHOD_AUTHORIZE:
+			user, _, _ := DecodeAuthorize(message.Params)
+			if user != "xel:miner.rig0" {
+				reply(message.ID, nil, &Error{Code: CODE_UNAUTHORIZED, Message: "unauthorized"})
+				return
+			}
+			reply(message.ID, true, nil)
+			notify(METHOD_SET_DIFFICULTY, []uint64{4})
+			notify(METHOD_NOTIFY, NotifyParams{JobID: "job1", Work: work, Algorithm: xelishash.ALGO_DEV, Clean: true})
+		case METHOD_SUBMIT:
+			if n == 1 {
+				return
+			}
+
+			var submit SubmitParams
+			if err := json.Unmarshal(message.Params, &submit); err != nil {
+				reply(message.ID, nil, &Error{Code: CODE_OTHER, Message: err.Error()})
+				continue
+			}
+			if submit.JobID != "job1" {
+				reply(message.ID, nil, &Error{Code: CODE_STALE_JOB, Message: "stale job"})
+				continue
+			}
+
+			share := work
+			extra_nonce := share.ExtraNonce()
+			copy(extra_nonce[:2], []byte{0xaa, byte(n)})
+			copy(extra_nonce[2:], submit.ExtraNonce)
+			share.SetExtraNonce(extra_nonce)
+			share.SetNonce(submit.Nonce)
+
+			valid, _ := difficulty.CheckDifficulty(p.pool.XelisHashDev(share[:]), 4)
+			if valid {
+				reply(message.ID, true, nil)
+			} else {
+				reply(message.ID, nil, &Error{Code: CODE_LOW_DIFFICULTY, Message: "low difficulty share"})
+			}
+		}
+	}
+}
+
+func receiveJob(t *testing.T, client *Client) miner.Job {
+	t.Helper()
+
+	select {
+	case job := <-client.Jobs():
+		return job
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a job")
+	}
+	return miner.Job{}
+}
+
+func waitStats(t *testing.T, client *Client, expected Stats) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for client.Stats() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("incorrect stats %+v, expected %+v", client.Stats(), expected)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClient(t *testing.T) {
+	pool := newFakePool(t)
+	client := NewClient(ClientConfig{
+		Address:    pool.listener.Addr().String(),
+		User:       "xel:miner.rig0",
+		MinBackoff: 10 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- client.Run(ctx)
+	}()
+
+	job := receiveJob(t, client)
+	if job.ID != "job1" || job.ExtraNoncePrefix != 2 || job.Work[0] != 1 {
+		t.Fatalf("incorrect job %+v", job)
+	}
+	if extra_nonce := job.Work.ExtraNonce(); extra_nonce[0] != 0xaa || extra_nonce[1] != 1 {
+		t.Fatal("the extra nonce prefix must be written in the work")
+	}
+	expected, _ := difficulty.DifficultyToTarget(4)
+	if job.Target != expected || client.Difficulty() != 4 {
+		t.Fatalf("incorrect target %s", job.Target)
+	}
+
+	// the pool drops the connection on the first submission
+	if err := client.Submit(miner.Solution{JobID: job.ID, Work: job.Work}); err != nil {
+		t.Fatal(err)
+	}
+	job = receiveJob(t, client)
+	if extra_nonce := job.Work.ExtraNonce(); extra_nonce[1] != 2 || pool.connections.Load() != 2 {
+		t.Fatal("the client must use the prefix of the new connection")
+	}
+
+	engine := miner.NewEngine(1)
+	go engine.Run(ctx)
+	if err := engine.SetJob(job); err != nil {
+		t.Fatal(err)
+	}
+
+	var solution miner.Solution
+	select {
+	case solution = <-engine.Solutions():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a solution")
+	}
+
+	if err := client.Submit(solution); err != nil {
+		t.Fatal(err)
+	}
+	waitStats(t, client, Stats{Accepted: 1})
+
+	// a share for an unknown job is stale
+	stale := solution
+	stale.JobID = "job0"
+	if err := client.Submit(stale); err != nil {
+		t.Fatal(err)
+	}
+	waitStats(t, client, Stats{Accepted: 1, Stale: 1})
+
+	// a share not meeting the difficulty is rejected
+	for {
+		solution.Work.SetNonce(solution.Work.Nonce() + 1)
+		if !job.Target.Check(pool.pool.XelisHashDev(solution.Work[:])) {
+			break
+		}
+	}
+	if err := client.Submit(solution); err != nil {
+		t.Fatal(err)
+	}
+	waitStats(t, client, Stats{Accepted: 1, Stale: 1, Rejected: 1})
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("got error %v, expected %v", err, context.Canceled)
+	}
+	if err := client.Submit(solution); err != ErrNotConnected {
+		t.Fatalf("got error %v, expected %v", err, ErrNotConnected)
+	}
+}
+
+func TestClientUnauthorized(t *testing.T) {
+	pool := newFakePool(t)
+	client := NewClient(ClientConfig{
+		Address:    pool.listener.Addr().String(),
+		User:       "xel:someone.else",
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	client.Run(ctx)
+
+	// the client keeps retrying but never receives a job
+	if pool.connections.Load() < 2 {
+		t.Fatalf("client did not reconnect, %d connections", pool.connections.Load())
+	}
+	select {
+	case job := <-client.Jobs():
+		t.Fatalf("unexpected job %+v", job)
+	default:
+	}
+}
@@ -0,0 +1,200 @@
+// Package stratum implements the Stratum-style JSON-RPC protocol used by XELIS mining pools
+//
+// Messages are newline delimited JSON objects over TCP:
+//
+//	mining.subscribe      [agent] -> [subscription id, extra nonce prefix (hex), extra nonce size left to the miner]
+//	mining.authorize      [user, password] -> true
+//	mining.set_difficulty [difficulty], sent by the pool, applies from the next job
+//	mining.notify         [job id, miner work (hex), algorithm, clean jobs], sent by the pool
+//	mining.submit         [user, job id, extra nonce after the prefix (hex), nonce (hex, big-endian)] -> true
+//
+// Errors are sent as [code, message, null]
+package stratum
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/xelpool/xelishash"
+)
+
+const (
+	METHOD_SUBSCRIBE      = "mining.subscribe"
+	METHOD_AUTHORIZE      = "mining.authorize"
+	METHOD_SET_DIFFICULTY = "mining.set_difficulty"
+	METHOD_NOTIFY         = "mining.notify"
+	METHOD_SUBMIT         = "mining.submit"
+)
+
+// Error codes, following the usual Stratum conventions
+const (
+	CODE_OTHER          = 20
+	CODE_STALE_JOB      = 21
+	CODE_DUPLICATE      = 22
+	CODE_LOW_DIFFICULTY = 23
+	CODE_UNAUTHORIZED   = 24
+	CODE_NOT_SUBSCRIBED = 25
+)
+
+var ErrInvalidParams = errors.New("stratum: invalid params")
+
+// Error is a Stratum error, encoded as [code, message, null]
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stratum: error %d: %s", e.Code, e.Message)
+}
+
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Code, e.Message, nil})
+}
+
+func (e *Error) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil || len(raw) < 2 {
+		return ErrInvalidParams
+	}
+	if err := json.Unmarshal(raw[0], &e.Code); err != nil {
+		return err
+	}
+	return json.Unmarshal(raw[1], &e.Message)
+}
+
+// Message is any request, response or notification
+// Notifications have a null ID
+type Message struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+// IsResponse reports whether the message answers a request
+func (m *Message) IsResponse() bool {
+	return m.Method == ""
+}
+
+// params decodes the JSON array of params into the given values
+func params(data json.RawMessage, values ...interface{}) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil || len(raw) < len(values) {
+		return ErrInvalidParams
+	}
+	for i, value := range values {
+		if err := json.Unmarshal(raw[i], value); err != nil {
+			return ErrInvalidParams
+		}
+	}
+	return nil
+}
+
+type SubscribeResult struct {
+	SubscriptionID string
+	// ExtraNoncePrefix is written at the start of the extra nonce of every job
+	ExtraNoncePrefix []byte
+	// ExtraNonceSize is the number of extra nonce bytes left to the miner
+	ExtraNonceSize int
+}
+
+func (r SubscribeResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{r.SubscriptionID, hex.EncodeToString(r.ExtraNoncePrefix), r.ExtraNonceSize})
+}
+
+func (r *SubscribeResult) UnmarshalJSON(data []byte) error {
+	var prefix string
+	if err := params(data, &r.SubscriptionID, &prefix, &r.ExtraNonceSize); err != nil {
+		return err
+	}
+
+	decoded, err := hex.DecodeString(prefix)
+	if err != nil || len(decoded)+r.ExtraNonceSize != xelishash.EXTRA_NONCE_SIZE {
+		return ErrInvalidParams
+	}
+	r.ExtraNoncePrefix = decoded
+	return nil
+}
+
+type NotifyParams struct {
+	JobID     string
+	Work      xelishash.MinerWork
+	Algorithm string
+	// Clean is set when the previous jobs are no longer valid
+	Clean bool
+}
+
+func (p NotifyParams) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{p.JobID, p.Work, p.Algorithm, p.Clean})
+}
+
+func (p *NotifyParams) UnmarshalJSON(data []byte) error {
+	return params(data, &p.JobID, &p.Work, &p.Algorithm, &p.Clean)
+}
+
+type SubmitParams struct {
+	User  string
+	JobID string
+	// ExtraNonce holds the extra nonce bytes after the prefix assigned by the pool
+	ExtraNonce []byte
+	Nonce      uint64
+}
+
+func (p SubmitParams) MarshalJSON() ([]byte, error) {
+	var nonce [8]byte
+	binary.BigEndian.PutUint64(nonce[:], p.Nonce)
+	return json.Marshal([]interface{}{p.User, p.JobID, hex.EncodeToString(p.ExtraNonce), hex.EncodeToString(nonce[:])})
+}
+
+func (p *SubmitParams) UnmarshalJSON(data []byte) error {
+	var extra_nonce, nonce string
+	if err := params(data, &p.User, &p.JobID, &extra_nonce, &nonce); err != nil {
+		return err
+	}
+
+	decoded, err := hex.DecodeString(extra_nonce)
+	if err != nil || len(decoded) > xelishash.EXTRA_NONCE_SIZE {
+		return ErrInvalidParams
+	}
+	p.ExtraNonce = decoded
+
+	decoded, err = hex.DecodeString(nonce)
+	if err != nil || len(decoded) != 8 {
+		return ErrInvalidParams
+	}
+	p.Nonce = binary.BigEndian.Uint64(decoded)
+
+	return nil
+}
+
+// DecodeSetDifficulty reads the params of mining.set_difficulty
+func DecodeSetDifficulty(data json.RawMessage) (uint64, error) {
+	var difficulty uint64
+	if err := params(data, &difficulty); err != nil {
+		return 0, err
+	}
+	if difficulty == 0 {
+		return 0, ErrInvalidParams
+	}
+	return difficulty, nil
+}
+
+// DecodeAuthorize reads the params of mining.authorize
+func DecodeAuthorize(data json.RawMessage) (user string, password string, err error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil || len(raw) < 1 {
+		return "", "", ErrInvalidParams
+	}
+	if err := json.Unmarshal(raw[0], &user); err != nil {
+		return "", "", ErrInvalidParams
+	}
+	if len(raw) > 1 {
+		json.Unmarshal(raw[1], &password)
+	}
+	return user, password, nil
+}
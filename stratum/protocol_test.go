@@ -0,0 +1,64 @@
+package stratum
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/xelpool/xelishash"
+)
+
+func TestProtocolJSON(t *testing.T) {
+	submit := SubmitParams{User: "xel:miner.rig0", JobID: "1f", ExtraNonce: []byte{1, 2, 3}, Nonce: 0x0102}
+	encoded, err := json.Marshal(submit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != `["xel:miner.rig0","1f","010203","0000000000000102"]` {
+		t.Fatalf("incorrect submit params %s", encoded)
+	}
+	var decoded SubmitParams
+	if err := json.Unmarshal(encoded, &decoded); err != nil || decoded.Nonce != submit.Nonce || !bytes.Equal(decoded.ExtraNonce, submit.ExtraNonce) {
+		t.Fatalf("incorrect decoded submit params %+v: %v", decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`["u","j","zz","0000000000000102"]`), &decoded); err != ErrInvalidParams {
+		t.Fatalf("got error %v, expected %v", err, ErrInvalidParams)
+	}
+
+	var work xelishash.MinerWork
+	work[0] = 0xff
+	notify := NotifyParams{JobID: "2", Work: work, Algorithm: xelishash.ALGO_V2, Clean: true}
+	encoded, _ = json.Marshal(notify)
+	var decodedNotify NotifyParams
+	if err := json.Unmarshal(encoded, &decodedNotify); err != nil || decodedNotify != notify {
+		t.Fatalf("incorrect notify round trip %s: %v", encoded, err)
+	}
+
+	subscribe := SubscribeResult{SubscriptionID: "s", ExtraNoncePrefix: []byte{0xaa, 0xbb}, ExtraNonceSize: 30}
+	encoded, _ = json.Marshal(subscribe)
+	var decodedSubscribe SubscribeResult
+	if err := json.Unmarshal(encoded, &decodedSubscribe); err != nil || !bytes.Equal(decodedSubscribe.ExtraNoncePrefix, subscribe.ExtraNoncePrefix) {
+		t.Fatalf("incorrect subscribe round trip %s: %v", encoded, err)
+	}
+	if err := json.Unmarshal([]byte(`["s","aabb",4]`), &decodedSubscribe); err != ErrInvalidParams {
+		t.Fatal("the prefix and the extra nonce size must add up to the extra nonce")
+	}
+
+	message := Message{ID: json.RawMessage("3"), Error: &Error{Code: CODE_STALE_JOB, Message: "stale"}}
+	encoded, _ = json.Marshal(message)
+	if string(encoded) != `{"id":3,"error":[21,"stale",null]}` {
+		t.Fatalf("incorrect error message %s", encoded)
+	}
+	var decodedMessage Message
+	if err := json.Unmarshal(encoded, &decodedMessage); err != nil || *decodedMessage.Error != *message.Error || !decodedMessage.IsResponse() {
+		t.Fatalf("incorrect message round trip: %v", err)
+	}
+
+	if _, err := DecodeSetDifficulty(json.RawMessage(`[0]`)); err != ErrInvalidParams {
+		t.Fatal("a zero difficulty must be rejected")
+	}
+	user, password, err := DecodeAuthorize(json.RawMessage(`["xel:miner"]`))
+	if err != nil || user != "xel:miner" || password != "" {
+		t.Fatalf("incorrect authorize params %s %s: %v", user, password, err)
+	}
+}
@@ -0,0 +1,385 @@
+// Package server implements a Stratum pool frontend validating the shares with the ThreadPool
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/dedupe"
+	"github.com/xelpool/xelishash/miner"
+	"github.com/xelpool/xelishash/penalty"
+	"github.com/xelpool/xelishash/stratum"
+	"github.com/xelpool/xelishash/vardiff"
+)
+
+type Config struct {
+	// ShareDifficulty is the difficulty assigned to new connections, defaults to 1
+	ShareDifficulty uint64
+	// Vardiff adjusts the share difficulty of each connection when set
+	Vardiff *vardiff.Config
+	// DifficultyGrace is how long the previous share difficulties stay accepted after a change,
+	// for the shares in flight, defaults to 10 seconds
+	DifficultyGrace time.Duration
+	// Pool verifies the shares, defaults to a pool with a single thread
+	Pool *xelishash.ThreadPool
+	// ExtraNonceBase is written before the prefix of every connection,
+	// PrefixSize bytes are then allocated per connection (defaults to 4)
+	ExtraNonceBase []byte
+	PrefixSize     int
+
+	// MaxConnections limits the number of connections, 0 means no limit
+	MaxConnections int
+	// MaxConnectionsPerIP limits the number of connections from a single IP, 0 means no limit
+	MaxConnectionsPerIP int
+	// MaxMessageSize is the longest line accepted from a miner, defaults to 4KB
+	MaxMessageSize int
+	// IdleTimeout closes the connections not sending anything for that long, 0 disables it
+	IdleTimeout time.Duration
+	// Penalties throttles, disconnects or bans the peers sending bad shares when set
+	Penalties *penalty.Config
+
+	// Jobs manages the templates, defaults to a JobManager without timestamp refresh
+	Jobs *miner.JobManager
+	// Authorize checks the credentials of a miner, every miner is authorized if nil
+	Authorize func(user string, password string) bool
+
+	// Shares receives the accepted shares, Blocks the shares meeting the block target
+	Shares ShareSink
+	Blocks BlockSink
+}
+
+// Stats counts the submissions since the start of the server
+type Stats struct {
+	Accepted  uint64
+	Rejected  uint64
+	Stale     uint64
+	Duplicate uint64
+	// Blocks counts the blocks handed to the Blocks sink without error, FailedBlocks the ones it refused
+	Blocks       uint64
+	FailedBlocks uint64
+	// Banned counts the connections and the shares refused because of a ban
+	Banned uint64
+}
+
+type Server struct {
+	config   Config
+	prefixes *miner.PrefixAllocator
+	// penalties is nil when disabled
+	penalties *penalty.Tracker
+	// submitted catches the replayed shares, it remembers as many jobs as the job manager
+	submitted *dedupe.Filter
+
+	accepted  atomic.Uint64
+	rejected  atomic.Uint64
+	stale     atomic.Uint64
+	duplicate atomic.Uint64
+	blocks    atomic.Uint64
+	failed    atomic.Uint64
+	banned    atomic.Uint64
+
+	// block_err is the last error returned by the Blocks sink
+	block_mu  sync.Mutex
+	block_err error
+
+	mu       sync.Mutex
+	sessions map[*session]struct{}
+	per_ip   map[string]int
+}
+
+func New(config Config) (*Server, error) {
+	if config.ShareDifficulty == 0 {
+		config.ShareDifficulty = 1
+	}
+	if config.Pool == nil {
+		config.Pool = xelishash.NewThreadPool(1)
+	}
+	if config.PrefixSize == 0 {
+		config.PrefixSize = 4
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = 4096
+	}
+	if config.Vardiff != nil {
+		if err := config.Vardiff.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if config.DifficultyGrace <= 0 {
+		config.DifficultyGrace = 10 * time.Second
+	}
+	if config.Jobs == nil {
+		config.Jobs = miner.NewJobManager(miner.JobManagerConfig{})
+	}
+
+	prefixes, err := miner.NewPrefixAllocator(config.ExtraNonceBase, config.PrefixSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var penalties *penalty.Tracker
+	if config.Penalties != nil {
+		penalties = penalty.NewTracker(*config.Penalties)
+	}
+
+	return &Server{
+		config:    config,
+		penalties: penalties,
+		prefixes:  prefixes,
+		submitted: dedupe.NewFilter(config.Jobs.Capacity()),
+		sessions:  make(map[*session]struct{}),
+		per_ip:    make(map[string]int),
+	}, nil
+}
+
+func (s *Server) Stats() Stats {
+	return Stats{
+		Accepted:     s.accepted.Load(),
+		Rejected:     s.rejected.Load(),
+		Stale:        s.stale.Load(),
+		Duplicate:    s.duplicate.Load(),
+		Blocks:       s.blocks.Load(),
+		FailedBlocks: s.failed.Load(),
+		Banned:       s.banned.Load(),
+	}
+}
+
+// LastBlockError returns the last error of the Blocks sink, nil if no block submission failed
+func (s *Server) LastBlockError() error {
+	s.block_mu.Lock()
+	defer s.block_mu.Unlock()
+
+	return s.block_err
+}
+
+// SetTemplate replaces the work mined by every connection
+// The target of the template is the block target, jobs of previous templates become stale
+func (s *Server) SetTemplate(template miner.Template) error {
+	job, err := s.config.Jobs.SetTemplate(template)
+	if err != nil {
+		return err
+	}
+
+	s.prune()
+	s.broadcast(job, true)
+	return nil
+}
+
+// Refresh rewrites the timestamp of the current template if its refresh interval elapsed
+// Previous jobs of the template stay valid until they leave the history of the job manager
+func (s *Server) Refresh() {
+	if job, ok := s.config.Jobs.Refresh(); ok {
+		s.prune()
+		s.broadcast(job, false)
+	}
+}
+
+// prune forgets the shares of the jobs no longer current,
+// they are rejected as stale before the dedupe
+func (s *Server) prune() {
+	s.submitted.Prune(func(job_id string) bool {
+		return s.config.Jobs.Status(job_id) == miner.JobCurrent
+	})
+}
+
+func (s *Server) broadcast(job miner.Job, clean bool) {
+	s.mu.Lock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mu.Unlock()
+
+	for _, session := range sessions {
+		session.notify(job, clean)
+	}
+}
+
+// Serve accepts connections until the context is done or the listener fails
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		ip := remoteIP(conn)
+		if s.penalties != nil && s.penalties.Banned(ip) {
+			s.banned.Add(1)
+			conn.Close()
+			continue
+		}
+		if !s.admit(ip) {
+			conn.Close()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer s.release(ip)
+
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// admit reserves a connection slot, enforcing the connection limits
+func (s *Server) admit(ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	for _, n := range s.per_ip {
+		total += n
+	}
+	if s.config.MaxConnections > 0 && total >= s.config.MaxConnections {
+		return false
+	}
+	if s.config.MaxConnectionsPerIP > 0 && s.per_ip[ip] >= s.config.MaxConnectionsPerIP {
+		return false
+	}
+
+	s.per_ip[ip]++
+	return true
+}
+
+func (s *Server) release(ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.per_ip[ip]--
+	if s.per_ip[ip] == 0 {
+		delete(s.per_ip, ip)
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	session := newSession(s, conn)
+	if s.penalties != nil {
+		session.peer = s.penalties.Connect(remoteIP(conn))
+		defer session.peer.Close()
+	}
+
+	s.mu.Lock()
+	s.sessions[session] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, session)
+		s.mu.Unlock()
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if s.config.Vardiff != nil {
+		go func() {
+			ticker := time.NewTicker(s.config.Vardiff.RetargetInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case now := <-ticker.C:
+					if session.tick(now) != nil {
+						conn.Close()
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	session.serve(ctx)
+}
+
+// validate checks a share and hands it to the sinks
+// It returns a Stratum error if the share is rejected
+// The share is credited with the highest difficulty of the targets it meets
+func (s *Server) validate(ctx context.Context, share *Share, job *miner.Job, targets []shareTarget) *stratum.Error {
+	input := share.Work[:]
+	if job.Algorithm == xelishash.ALGO_V1 {
+		v1 := share.Work.V1()
+		input = v1[:]
+	}
+
+	hash, err := s.config.Pool.HashContext(ctx, job.Algorithm, input)
+	if err != nil {
+		return &stratum.Error{Code: stratum.CODE_OTHER, Message: err.Error()}
+	}
+	share.Hash = hash
+
+	// the work is only recorded once hashed, a share that could not be hashed can be sent again
+	if s.submitted.Seen(job.ID, &share.Work) {
+		s.duplicate.Add(1)
+		return &stratum.Error{Code: stratum.CODE_DUPLICATE, Message: "duplicate share"}
+	}
+
+	for _, target := range targets {
+		if target.target.Check(hash) && target.difficulty > share.Difficulty {
+			share.Difficulty = target.difficulty
+		}
+	}
+	if share.Difficulty == 0 {
+		s.rejected.Add(1)
+		return &stratum.Error{Code: stratum.CODE_LOW_DIFFICULTY, Message: "low difficulty share"}
+	}
+
+	if job.Target.Check(hash) {
+		share.Block = true
+		s.submitBlock(*share)
+	}
+
+	s.accepted.Add(1)
+	if s.config.Shares != nil {
+		s.config.Shares.SubmitShare(*share)
+	}
+
+	return nil
+}
+
+// submitBlock hands a share meeting the block target to the Blocks sink
+// A failed submission is counted and kept for LastBlockError, the share itself stays valid
+func (s *Server) submitBlock(share Share) {
+	if s.config.Blocks != nil {
+		if err := s.config.Blocks.SubmitBlock(share); err != nil {
+			s.failed.Add(1)
+			s.block_mu.Lock()
+			s.block_err = err
+			s.block_mu.Unlock()
+			return
+		}
+	}
+	s.blocks.Add(1)
+}
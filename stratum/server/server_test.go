@@ -0,0 +1,714 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/difficulty"
+	"github.com/xelpool/xelishash/miner"
+	"github.com/xelpool/xelishash/penalty"
+	"github.com/xelpool/xelishash/stratum"
+	"github.com/xelpool/xelishash/vardiff"
+)
+
+func testTemplate(t *testing.T, block_difficulty uint64) miner.Template {
+	t.Helper()
+
+	target, err := difficulty.DifficultyToTarget(block_difficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var work xelishash.MinerWork
+	work[0] = 0x42
+	return miner.Template{Work: work, Algorithm: xelishash.ALGO_DEV, Target: target, Height: 10}
+}
+
+func startServer(t *testing.T, config Config) (*Server, string) {
+	t.Helper()
+
+	server, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.Serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return server, listener.Addr().String()
+}
+
+// rawMiner speaks the protocol directly, to send invalid requests
+type rawMiner struct {
+	t       *testing.T
+	conn    net.Conn
+	scanner *bufio.Scanner
+	next_id int
+	// notifications received while waiting for a response
+	notifications []stratum.Message
+}
+
+func dialRaw(t *testing.T, address string) *rawMiner {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	return &rawMiner{t: t, conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+func (m *rawMiner) call(method string, params interface{}) stratum.Message {
+	m.t.Helper()
+
+	m.next_id++
+	id := json.RawMessage(strconv.Itoa(m.next_id))
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	if err := json.NewEncoder(m.conn).Encode(stratum.Message{ID: id, Method: method, Params: encoded}); err != nil {
+		m.t.Fatal(err)
+	}
+
+	for m.scanner.Scan() {
+		var message stratum.Message
+		if err := json.Unmarshal(m.scanner.Bytes(), &message); err != nil {
+			m.t.Fatal(err)
+		}
+		if !message.IsResponse() {
+			m.notifications = append(m.notifications, message)
+			continue
+		}
+		if string(message.ID) != string(id) {
+			m.t.Fatalf("unexpected response %s", message.ID)
+		}
+		return message
+	}
+	m.t.Fatalf("connection closed waiting for %s", method)
+	return stratum.Message{}
+}
+
+func (m *rawMiner) subscribe() stratum.SubscribeResult {
+	m.t.Helper()
+
+	response := m.call(stratum.METHOD_SUBSCRIBE, []string{"test"})
+	var result stratum.SubscribeResult
+	if response.Error != nil || json.Unmarshal(response.Result, &result) != nil {
+		m.t.Fatalf("incorrect subscribe response %+v", response)
+	}
+	return result
+}
+
+func (m *rawMiner) authorize(user string) {
+	m.t.Helper()
+
+	if response := m.call(stratum.METHOD_AUTHORIZE, []string{user, "x"}); response.Error != nil {
+		m.t.Fatalf("authorize failed: %s", response.Error)
+	}
+}
+
+// nextJob returns the next job notified to the miner
+func (m *rawMiner) nextJob() stratum.NotifyParams {
+	m.t.Helper()
+
+	for {
+		var message stratum.Message
+		if len(m.notifications) > 0 {
+			message = m.notifications[0]
+			m.notifications = m.notifications[1:]
+		} else {
+			if !m.scanner.Scan() {
+				m.t.Fatal("connection closed waiting for a job")
+			}
+			if err := json.Unmarshal(m.scanner.Bytes(), &message); err != nil {
+				m.t.Fatal(err)
+			}
+		}
+		if message.Method != stratum.METHOD_NOTIFY {
+			continue
+		}
+
+		var notify stratum.NotifyParams
+		if err := json.Unmarshal(message.Params, &notify); err != nil {
+			m.t.Fatal(err)
+		}
+		return notify
+	}
+}
+
+func expectError(t *testing.T, response stratum.Message, code int) {
+	t.Helper()
+
+	if response.Error == nil || response.Error.Code != code {
+		t.Fatalf("expected error %d, got %+v", code, response)
+	}
+}
+
+func expectClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 1024)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("the connection must be closed")
+			}
+			return
+		}
+	}
+}
+
+// TestServerMiners runs simulated miners against the server
+func TestServerMiners(t *testing.T) {
+	var mu sync.Mutex
+	var shares, blocks []Share
+
+	server, address := startServer(t, Config{
+		ShareDifficulty: 4,
+		Authorize: func(user string, password string) bool {
+			return strings.HasPrefix(user, "xel:")
+		},
+		Shares: ShareSinkFunc(func(share Share) {
+			mu.Lock()
+			shares = append(shares, share)
+			mu.Unlock()
+		}),
+		Blocks: BlockSinkFunc(func(share Share) error {
+			mu.Lock()
+			blocks = append(blocks, share)
+			mu.Unlock()
+			return nil
+		}),
+	})
+	if err := server.SetTemplate(testTemplate(t, 16)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const MINERS = 2
+	clients := make([]*stratum.Client, MINERS)
+	for i := range clients {
+		client := stratum.NewClient(stratum.ClientConfig{
+			Address:    address,
+			User:       "xel:miner.rig" + strconv.Itoa(i),
+			MinBackoff: 10 * time.Millisecond,
+		})
+		clients[i] = client
+		go client.Run(ctx)
+
+		engine := miner.NewEngine(1)
+		go engine.Run(ctx)
+		go func() {
+			for {
+				select {
+				case job := <-client.Jobs():
+					engine.SetJob(job)
+				case solution, ok := <-engine.Solutions():
+					if !ok {
+						return
+					}
+					client.Submit(solution)
+				}
+			}
+		}()
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		done := true
+		for _, client := range clients {
+			if client.Stats().Accepted < 3 {
+				done = false
+			}
+		}
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out, server stats %+v", server.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	stats := server.Stats()
+	if stats.Rejected != 0 || stats.Accepted != uint64(len(shares)) || stats.Blocks != uint64(len(blocks)) {
+		t.Fatalf("incorrect stats %+v for %d shares and %d blocks", stats, len(shares), len(blocks))
+	}
+
+	block_target, _ := difficulty.DifficultyToTarget(16)
+	share_target, _ := difficulty.DifficultyToTarget(4)
+	prefixes := make(map[string]string)
+	for _, share := range shares {
+		if share.Hash != xelishash.XelisHashDev(share.Work[:], &xelishash.ScratchPadDev{}) {
+			t.Fatal("the share hash must be the hash of the rebuilt work")
+		}
+		if !share_target.Check(share.Hash) || share.Difficulty != 4 || share.Height != 10 {
+			t.Fatalf("incorrect share %+v", share)
+		}
+		if share.Block != block_target.Check(share.Hash) {
+			t.Fatal("the block flag must match the block target")
+		}
+
+		// each connection mines its own extra nonce prefix
+		extra_nonce := share.Work.ExtraNonce()
+		prefix := string(extra_nonce[:4])
+		if user, ok := prefixes[prefix]; ok && user != share.User {
+			t.Fatal("two miners got the same extra nonce prefix")
+		}
+		prefixes[prefix] = share.User
+	}
+	if len(prefixes) != MINERS {
+		t.Fatalf("expected %d prefixes, got %d", MINERS, len(prefixes))
+	}
+	for _, block := range blocks {
+		if !block.Block {
+			t.Fatal("the block sink must only receive blocks")
+		}
+	}
+}
+
+func TestServerRejects(t *testing.T) {
+	server, address := startServer(t, Config{
+		ShareDifficulty: 1 << 62,
+		Authorize: func(user string, password string) bool {
+			return user == "xel:miner"
+		},
+	})
+	if err := server.SetTemplate(testTemplate(t, 1<<62)); err != nil {
+		t.Fatal(err)
+	}
+
+	m := dialRaw(t, address)
+	expectError(t, m.call(stratum.METHOD_AUTHORIZE, []string{"xel:miner", "x"}), stratum.CODE_NOT_SUBSCRIBED)
+
+	submit := stratum.SubmitParams{User: "xel:miner", JobID: "0", ExtraNonce: make([]byte, 28)}
+	expectError(t, m.call(stratum.METHOD_SUBMIT, submit), stratum.CODE_UNAUTHORIZED)
+
+	result := m.subscribe()
+	if len(result.ExtraNoncePrefix) != 4 || result.ExtraNonceSize != 28 {
+		t.Fatalf("incorrect subscription %+v", result)
+	}
+	m.authorize("xel:miner")
+	job := m.nextJob()
+	if job.Algorithm != xelishash.ALGO_DEV || !job.Clean || job.Work[0] != 0x42 {
+		t.Fatalf("incorrect job %+v", job)
+	}
+
+	// the extra nonce must fill the space left by the prefix
+	submit.JobID = job.JobID
+	submit.ExtraNonce = make([]byte, 32)
+	expectError(t, m.call(stratum.METHOD_SUBMIT, submit), stratum.CODE_OTHER)
+
+	submit.ExtraNonce = make([]byte, 28)
+	expectError(t, m.call(stratum.METHOD_SUBMIT, submit), stratum.CODE_LOW_DIFFICULTY)
+
+	// a replay is caught before hashing, so it doesn't count as a low difficulty share
+	expectError(t, m.call(stratum.METHOD_SUBMIT, submit), stratum.CODE_DUPLICATE)
+
+	submit.JobID = "unknown"
+	expectError(t, m.call(stratum.METHOD_SUBMIT, submit), stratum.CODE_STALE_JOB)
+
+	// a new template makes the previous jobs stale
+	if err := server.SetTemplate(testTemplate(t, 1<<62)); err != nil {
+		t.Fatal(err)
+	}
+	submit.JobID = job.JobID
+	expectError(t, m.call(stratum.METHOD_SUBMIT, submit), stratum.CODE_STALE_JOB)
+	if next := m.nextJob(); next.JobID == job.JobID || !next.Clean {
+		t.Fatalf("the new template must be notified, got %+v", next)
+	}
+
+	expected := Stats{Rejected: 2, Stale: 2, Duplicate: 1}
+	if stats := server.Stats(); stats != expected {
+		t.Fatalf("incorrect stats %+v, expected %+v", stats, expected)
+	}
+
+	// an unknown user is disconnected
+	other := dialRaw(t, address)
+	other.subscribe()
+	expectError(t, other.call(stratum.METHOD_AUTHORIZE, []string{"xel:other", "x"}), stratum.CODE_UNAUTHORIZED)
+	expectClosed(t, other.conn)
+}
+
+// TestServerFailedBlocks checks that a block refused by the sink isn't counted as a block
+func TestServerFailedBlocks(t *testing.T) {
+	ErrUpstream := errors.New("upstream unreachable")
+
+	var mu sync.Mutex
+	var shares []Share
+	server, address := startServer(t, Config{
+		Shares: ShareSinkFunc(func(share Share) {
+			mu.Lock()
+			shares = append(shares, share)
+			mu.Unlock()
+		}),
+		Blocks: BlockSinkFunc(func(share Share) error {
+			return ErrUpstream
+		}),
+	})
+	// every share is a block
+	if err := server.SetTemplate(testTemplate(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	m := dialRaw(t, address)
+	m.subscribe()
+	m.authorize("xel:miner")
+	job := m.nextJob()
+
+	submit := stratum.SubmitParams{User: "xel:miner", JobID: job.JobID, ExtraNonce: make([]byte, 28)}
+	if response := m.call(stratum.METHOD_SUBMIT, submit); response.Error != nil {
+		t.Fatalf("the share must still be accepted, got %+v", response.Error)
+	}
+
+	expected := Stats{Accepted: 1, FailedBlocks: 1}
+	if stats := server.Stats(); stats != expected {
+		t.Fatalf("incorrect stats %+v, expected %+v", stats, expected)
+	}
+	if err := server.LastBlockError(); err != ErrUpstream {
+		t.Fatalf("got error %v, expected %v", err, ErrUpstream)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(shares) != 1 || !shares[0].Block || shares[0].Difficulty != 1 {
+		t.Fatalf("incorrect shares %+v", shares)
+	}
+}
+
+// stepClock moves forward by a second every time it is read
+type stepClock struct {
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.now = c.now.Add(time.Second)
+	return c.now
+}
+
+func TestServerDedupe(t *testing.T) {
+	jobs := miner.NewJobManager(miner.JobManagerConfig{
+		RefreshInterval: time.Second,
+		History:         2,
+		Clock:           &stepClock{now: time.Unix(1700000000, 0)},
+	})
+	server, err := New(Config{Jobs: jobs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetTemplate(testTemplate(t, 1<<62)); err != nil {
+		t.Fatal(err)
+	}
+	job, err := jobs.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	target, _ := difficulty.DifficultyToTarget(1)
+	targets := []shareTarget{{difficulty: 1, target: target}}
+	share := Share{JobID: job.ID, Work: job.Work}
+
+	// a share that could not be hashed is not recorded
+	unknown := job
+	unknown.Algorithm = "xel/unknown"
+	if err := server.validate(ctx, &share, &unknown, targets); err == nil || err.Code != stratum.CODE_OTHER {
+		t.Fatalf("expected a hash error, got %+v", err)
+	}
+	if server.submitted.Contains(job.ID, &share.Work) {
+		t.Fatal("the work must only be recorded once hashed")
+	}
+
+	if err := server.validate(ctx, &share, &job, targets); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.validate(ctx, &share, &job, targets); err == nil || err.Code != stratum.CODE_DUPLICATE {
+		t.Fatalf("expected a duplicate, got %+v", err)
+	}
+
+	// the job stays current while the job manager remembers it
+	for i := 0; i < 2; i++ {
+		server.Refresh()
+		if server.submitted.Jobs() != 1 {
+			t.Fatalf("refresh %d: the shares of a current job must be kept", i)
+		}
+	}
+	server.Refresh()
+	if jobs.Status(job.ID) != miner.JobUnknown || server.submitted.Jobs() != 0 {
+		t.Fatal("the shares of a forgotten job must be pruned")
+	}
+
+	expected := Stats{Accepted: 1, Duplicate: 1}
+	if stats := server.Stats(); stats != expected {
+		t.Fatalf("incorrect stats %+v, expected %+v", stats, expected)
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	_, address := startServer(t, Config{
+		MaxConnectionsPerIP: 1,
+		MaxMessageSize:      256,
+	})
+
+	first := dialRaw(t, address)
+	first.subscribe()
+
+	// the second connection from the same IP is refused
+	second := dialRaw(t, address)
+	expectClosed(t, second.conn)
+
+	// an oversized message drops the connection, releasing its slot
+	first.conn.Write([]byte(strings.Repeat("a", 1024) + "\n"))
+	expectClosed(t, first.conn)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &rawMiner{t: t, conn: conn, scanner: bufio.NewScanner(conn)}
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n")); err == nil && m.scanner.Scan() {
+			conn.Close()
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("the slot of the dropped connection must be released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	_, address := startServer(t, Config{IdleTimeout: 50 * time.Millisecond})
+
+	m := dialRaw(t, address)
+	m.subscribe()
+	expectClosed(t, m.conn)
+}
+
+func TestServerVardiff(t *testing.T) {
+	var mu sync.Mutex
+	difficulties := make(map[uint64]int)
+
+	config := vardiff.DefaultConfig
+	config.SharesPerMinute = 600
+	config.RetargetInterval = 250 * time.Millisecond
+	server, address := startServer(t, Config{
+		Vardiff: &config,
+		Shares: ShareSinkFunc(func(share Share) {
+			mu.Lock()
+			difficulties[share.Difficulty]++
+			mu.Unlock()
+		}),
+	})
+	if err := server.SetTemplate(testTemplate(t, 1<<62)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := stratum.NewClient(stratum.ClientConfig{Address: address, User: "xel:miner"})
+	go client.Run(ctx)
+
+	engine := miner.NewEngine(1)
+	go engine.Run(ctx)
+	go func() {
+		for {
+			select {
+			case job := <-client.Jobs():
+				engine.SetJob(job)
+			case solution, ok := <-engine.Solutions():
+				if !ok {
+					return
+				}
+				client.Submit(solution)
+			}
+		}
+	}()
+
+	// raised reports whether a share got credited above the starting difficulty
+	raised := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for difficulty := range difficulties {
+			if difficulty > 1 {
+				return true
+			}
+		}
+		return false
+	}
+
+	// the miner finds a share per hash at difficulty 1, far more than 10 per second
+	deadline := time.Now().Add(30 * time.Second)
+	for client.Difficulty() < 16 || !raised() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the difficulty must increase, got %d", client.Difficulty())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(difficulties) < 2 || difficulties[1] == 0 {
+		t.Fatalf("the shares must be credited with their difficulty, got %v", difficulties)
+	}
+	if stats := server.Stats(); stats.Rejected != 0 {
+		t.Fatalf("no share must be rejected after a retarget, got %+v", stats)
+	}
+}
+
+func TestServerVardiffStarvation(t *testing.T) {
+	config := vardiff.DefaultConfig
+	config.RetargetInterval = 20 * time.Millisecond
+	server, address := startServer(t, Config{ShareDifficulty: 1 << 20, Vardiff: &config})
+	if err := server.SetTemplate(testTemplate(t, 1<<62)); err != nil {
+		t.Fatal(err)
+	}
+
+	m := dialRaw(t, address)
+	m.subscribe()
+	m.authorize("xel:miner")
+
+	// without shares the difficulty goes down, each change comes with a job to apply it
+	expected := uint64(1 << 20)
+	for i := 0; i < 3; i++ {
+		expected /= 4
+		for {
+			if !m.scanner.Scan() {
+				t.Fatal("connection closed")
+			}
+
+			var message stratum.Message
+			if err := json.Unmarshal(m.scanner.Bytes(), &message); err != nil {
+				t.Fatal(err)
+			}
+			if message.Method != stratum.METHOD_SET_DIFFICULTY {
+				continue
+			}
+
+			diff, err := stratum.DecodeSetDifficulty(message.Params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff == expected {
+				break
+			}
+		}
+		if job := m.nextJob(); job.Clean {
+			t.Fatal("a retarget must not clean the jobs")
+		}
+	}
+}
+
+func TestServerPenalties(t *testing.T) {
+	server, address := startServer(t, Config{
+		ShareDifficulty: 1 << 62,
+		Penalties: &penalty.Config{
+			Window:    4,
+			MinShares: 4,
+			Invalid:   penalty.Limits{Ban: 0.5},
+		},
+	})
+	if err := server.SetTemplate(testTemplate(t, 1<<62)); err != nil {
+		t.Fatal(err)
+	}
+
+	first := dialRaw(t, address)
+	first.subscribe()
+	first.authorize("xel:miner")
+	job := first.nextJob()
+
+	second := dialRaw(t, address)
+	second.subscribe()
+	second.authorize("xel:miner")
+
+	// low difficulty shares get the IP banned
+	submit := stratum.SubmitParams{User: "xel:miner", JobID: job.JobID, ExtraNonce: make([]byte, 28)}
+	for i := 0; i < 4; i++ {
+		submit.Nonce = uint64(i)
+		expectError(t, first.call(stratum.METHOD_SUBMIT, submit), stratum.CODE_LOW_DIFFICULTY)
+	}
+	expectClosed(t, first.conn)
+
+	// the other connection of the IP is dropped without hashing its share
+	rejected := server.Stats().Rejected
+	submit.Nonce = 100
+	expectError(t, second.call(stratum.METHOD_SUBMIT, submit), stratum.CODE_UNAUTHORIZED)
+	expectClosed(t, second.conn)
+	if stats := server.Stats(); stats.Rejected != rejected || stats.Banned != 1 {
+		t.Fatalf("the share of a banned peer must not be verified, got %+v", stats)
+	}
+
+	// new connections from the IP are refused
+	third := dialRaw(t, address)
+	expectClosed(t, third.conn)
+	if stats := server.Stats(); stats.Banned != 2 {
+		t.Fatalf("the connection must be refused, got %+v", stats)
+	}
+}
+
+func TestServerThrottle(t *testing.T) {
+	_, address := startServer(t, Config{
+		Penalties: &penalty.Config{
+			Window:        4,
+			MinShares:     4,
+			Stale:         penalty.Limits{Throttle: 0.5},
+			ThrottleDelay: 200 * time.Millisecond,
+		},
+	})
+
+	// stale shares get the connection throttled
+	m := dialRaw(t, address)
+	m.subscribe()
+	m.authorize("xel:miner")
+	submit := stratum.SubmitParams{User: "xel:miner", JobID: "unknown", ExtraNonce: make([]byte, 28)}
+	for i := 0; i < 4; i++ {
+		expectError(t, m.call(stratum.METHOD_SUBMIT, submit), stratum.CODE_STALE_JOB)
+	}
+
+	start := time.Now()
+	expectError(t, m.call(stratum.METHOD_SUBMIT, submit), stratum.CODE_STALE_JOB)
+	if time.Since(start) < 200*time.Millisecond {
+		t.Fatal("the connection must be throttled")
+	}
+}
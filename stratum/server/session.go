@@ -0,0 +1,375 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xelpool/xelishash"
+	"github.com/xelpool/xelishash/difficulty"
+	"github.com/xelpool/xelishash/miner"
+	"github.com/xelpool/xelishash/penalty"
+	"github.com/xelpool/xelishash/stratum"
+	"github.com/xelpool/xelishash/vardiff"
+)
+
+var errPenalized = errors.New("server: connection dropped by the penalties")
+
+// session is the state of one miner connection
+type session struct {
+	server *Server
+	conn   net.Conn
+
+	mu         sync.Mutex
+	encoder    *json.Encoder
+	prefix     []byte
+	user       string
+	authorized bool
+	current    shareTarget
+	// previous targets stay accepted until they expire, for the jobs the miner got before a retarget
+	previous []shareTarget
+	vardiff  *vardiff.Controller
+	// peer is nil when the penalties are disabled
+	peer *penalty.Peer
+}
+
+// shareTarget is a share difficulty with its target
+type shareTarget struct {
+	difficulty uint64
+	target     difficulty.Target
+	expires    time.Time
+}
+
+func newSession(server *Server, conn net.Conn) *session {
+	return &session{
+		server:  server,
+		conn:    conn,
+		encoder: json.NewEncoder(conn),
+	}
+}
+
+func (s *session) send(message stratum.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.encoder.Encode(message)
+}
+
+func (s *session) reply(id json.RawMessage, result interface{}, err *stratum.Error) error {
+	message := stratum.Message{ID: id, Error: err}
+	if err == nil {
+		encoded, e := json.Marshal(result)
+		if e != nil {
+			return e
+		}
+		message.Result = encoded
+	}
+	return s.send(message)
+}
+
+func (s *session) notification(method string, params interface{}) error {
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.send(stratum.Message{ID: json.RawMessage("null"), Method: method, Params: encoded})
+}
+
+// notify sends the job to the miner once it is authorized
+func (s *session) notify(job miner.Job, clean bool) {
+	s.mu.Lock()
+	authorized := s.authorized
+	s.mu.Unlock()
+
+	if !authorized {
+		return
+	}
+
+	s.notification(stratum.METHOD_NOTIFY, stratum.NotifyParams{
+		JobID:     job.ID,
+		Work:      job.Work,
+		Algorithm: job.Algorithm,
+		Clean:     clean,
+	})
+}
+
+// setDifficulty changes the share difficulty and notifies the miner
+// The previous difficulty stays accepted for the DifficultyGrace of the server
+func (s *session) setDifficulty(diff uint64) error {
+	target, err := difficulty.DifficultyToTarget(diff)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.current.difficulty != 0 {
+		previous := s.current
+		previous.expires = time.Now().Add(s.server.config.DifficultyGrace)
+		s.previous = append(s.previous, previous)
+	}
+	s.current = shareTarget{difficulty: diff, target: target}
+	s.mu.Unlock()
+
+	return s.notification(stratum.METHOD_SET_DIFFICULTY, []uint64{diff})
+}
+
+// retarget sends the new difficulty with the current job, as it only applies from the next job
+func (s *session) retarget(diff uint64) error {
+	if err := s.setDifficulty(diff); err != nil {
+		return err
+	}
+	if job, err := s.server.config.Jobs.Current(); err == nil {
+		s.notify(job, false)
+	}
+	return nil
+}
+
+// tick lets the vardiff lower the difficulty of a connection not sending shares
+func (s *session) tick(now time.Time) error {
+	s.mu.Lock()
+	if s.vardiff == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	diff, changed := s.vardiff.Tick(now)
+	s.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+	return s.retarget(diff)
+}
+
+func (s *session) serve(ctx context.Context) {
+	defer s.conn.Close()
+
+	scanner := bufio.NewScanner(s.conn)
+	scanner.Buffer(make([]byte, 0, 512), s.server.config.MaxMessageSize)
+
+	for {
+		if s.server.config.IdleTimeout > 0 {
+			s.conn.SetReadDeadline(time.Now().Add(s.server.config.IdleTimeout))
+		}
+		if !scanner.Scan() {
+			return
+		}
+
+		var message stratum.Message
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return
+		}
+		if err := s.handle(ctx, &message); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers a request, an error drops the connection
+func (s *session) handle(ctx context.Context, message *stratum.Message) error {
+	switch message.Method {
+	case stratum.METHOD_SUBSCRIBE:
+		return s.subscribe(message)
+	case stratum.METHOD_AUTHORIZE:
+		return s.authorize(message)
+	case stratum.METHOD_SUBMIT:
+		return s.submit(ctx, message)
+	}
+	return s.reply(message.ID, nil, &stratum.Error{Code: stratum.CODE_OTHER, Message: "unknown method"})
+}
+
+func (s *session) subscribe(message *stratum.Message) error {
+	s.mu.Lock()
+	prefix := s.prefix
+	s.mu.Unlock()
+
+	if prefix == nil {
+		var err error
+		prefix, err = s.server.prefixes.Next()
+		if err != nil {
+			s.reply(message.ID, nil, &stratum.Error{Code: stratum.CODE_OTHER, Message: err.Error()})
+			return err
+		}
+
+		s.mu.Lock()
+		s.prefix = prefix
+		s.mu.Unlock()
+	}
+
+	return s.reply(message.ID, stratum.SubscribeResult{
+		SubscriptionID:   hex.EncodeToString(prefix),
+		ExtraNoncePrefix: prefix,
+		ExtraNonceSize:   xelishash.EXTRA_NONCE_SIZE - len(prefix),
+	}, nil)
+}
+
+func (s *session) authorize(message *stratum.Message) error {
+	s.mu.Lock()
+	subscribed := s.prefix != nil
+	s.mu.Unlock()
+
+	if !subscribed {
+		return s.reply(message.ID, nil, &stratum.Error{Code: stratum.CODE_NOT_SUBSCRIBED, Message: "not subscribed"})
+	}
+
+	user, password, err := stratum.DecodeAuthorize(message.Params)
+	if err != nil {
+		return s.reply(message.ID, nil, &stratum.Error{Code: stratum.CODE_OTHER, Message: err.Error()})
+	}
+	if s.server.config.Authorize != nil && !s.server.config.Authorize(user, password) {
+		s.reply(message.ID, nil, &stratum.Error{Code: stratum.CODE_UNAUTHORIZED, Message: "unauthorized"})
+		return stratum.ErrInvalidParams
+	}
+
+	diff := s.server.config.ShareDifficulty
+	var controller *vardiff.Controller
+	if s.server.config.Vardiff != nil {
+		controller, err = vardiff.New(*s.server.config.Vardiff, diff, time.Now())
+		if err != nil {
+			return err
+		}
+		diff = controller.Difficulty()
+	}
+
+	s.mu.Lock()
+	s.user = user
+	s.authorized = true
+	s.vardiff = controller
+	s.mu.Unlock()
+
+	if err := s.reply(message.ID, true, nil); err != nil {
+		return err
+	}
+	if err := s.setDifficulty(diff); err != nil {
+		return err
+	}
+	if job, err := s.server.config.Jobs.Current(); err == nil {
+		s.notify(job, true)
+	}
+	return nil
+}
+
+// reject answers a rejected share and penalizes the connection
+func (s *session) reject(ctx context.Context, id json.RawMessage, outcome penalty.Outcome, err *stratum.Error) error {
+	if e := s.reply(id, nil, err); e != nil {
+		return e
+	}
+	return s.penalize(ctx, outcome)
+}
+
+// penalize records the outcome of a share
+// A throttled connection waits before reading its next message, an error means the connection must be dropped
+func (s *session) penalize(ctx context.Context, outcome penalty.Outcome) error {
+	if s.peer == nil {
+		return nil
+	}
+
+	switch s.peer.Record(outcome) {
+	case penalty.ActionThrottle:
+		timer := time.NewTimer(s.server.penalties.ThrottleDelay())
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	case penalty.ActionDisconnect, penalty.ActionBan:
+		return errPenalized
+	}
+	return nil
+}
+
+func (s *session) submit(ctx context.Context, message *stratum.Message) error {
+	s.mu.Lock()
+	authorized := s.authorized
+	prefix := s.prefix
+	user := s.user
+	// drop the expired targets, they are ordered by expiration
+	now := time.Now()
+	for len(s.previous) > 0 && now.After(s.previous[0].expires) {
+		s.previous = s.previous[1:]
+	}
+	targets := append([]shareTarget{s.current}, s.previous...)
+	s.mu.Unlock()
+
+	if !authorized {
+		return s.reply(message.ID, nil, &stratum.Error{Code: stratum.CODE_UNAUTHORIZED, Message: "unauthorized"})
+	}
+
+	if s.peer != nil && s.peer.Banned() {
+		// the shares of banned peers are not worth a hash
+		s.server.banned.Add(1)
+		s.reply(message.ID, nil, &stratum.Error{Code: stratum.CODE_UNAUTHORIZED, Message: "banned"})
+		return errPenalized
+	}
+
+	var params stratum.SubmitParams
+	if err := json.Unmarshal(message.Params, &params); err != nil || len(prefix)+len(params.ExtraNonce) != xelishash.EXTRA_NONCE_SIZE {
+		s.server.rejected.Add(1)
+		return s.reject(ctx, message.ID, penalty.OutcomeInvalid, &stratum.Error{Code: stratum.CODE_OTHER, Message: "invalid params"})
+	}
+
+	job, status := s.server.config.Jobs.Job(params.JobID)
+	if status != miner.JobCurrent {
+		s.server.stale.Add(1)
+		return s.reject(ctx, message.ID, penalty.OutcomeStale, &stratum.Error{Code: stratum.CODE_STALE_JOB, Message: "stale job"})
+	}
+
+	// rebuild the work mined by the miner
+	work := job.Work
+	var extra_nonce [xelishash.EXTRA_NONCE_SIZE]byte
+	copy(extra_nonce[:], prefix)
+	copy(extra_nonce[len(prefix):], params.ExtraNonce)
+	work.SetExtraNonce(extra_nonce)
+	work.SetNonce(params.Nonce)
+
+	// replays are rejected before spending a hash on them
+	if s.server.submitted.Contains(job.ID, &work) {
+		s.server.duplicate.Add(1)
+		return s.reject(ctx, message.ID, penalty.OutcomeDuplicate, &stratum.Error{Code: stratum.CODE_DUPLICATE, Message: "duplicate share"})
+	}
+
+	share := Share{
+		User:       user,
+		RemoteAddr: s.conn.RemoteAddr().String(),
+		JobID:      job.ID,
+		Work:       work,
+	}
+	share.Height, _ = s.server.config.Jobs.Height(job.ID)
+
+	if err := s.server.validate(ctx, &share, &job, targets); err != nil {
+		switch err.Code {
+		case stratum.CODE_LOW_DIFFICULTY:
+			return s.reject(ctx, message.ID, penalty.OutcomeInvalid, err)
+		case stratum.CODE_DUPLICATE:
+			// the same work got submitted concurrently
+			return s.reject(ctx, message.ID, penalty.OutcomeDuplicate, err)
+		}
+		// the share could not be hashed, it is not the fault of the miner
+		return s.reply(message.ID, nil, err)
+	}
+	if err := s.reply(message.ID, true, nil); err != nil {
+		return err
+	}
+	if err := s.penalize(ctx, penalty.OutcomeValid); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.vardiff == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	diff, changed := s.vardiff.Share(time.Now())
+	s.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+	return s.retarget(diff)
+}
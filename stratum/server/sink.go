@@ -0,0 +1,47 @@
+package server
+
+import (
+	"github.com/xelpool/xelishash"
+)
+
+// Share is a submission that passed validation
+type Share struct {
+	User       string
+	RemoteAddr string
+	JobID      string
+	Height     uint64
+	// Work is the rebuilt miner work, with the extra nonce and the nonce of the submission
+	Work xelishash.MinerWork
+	Hash xelishash.Hash
+	// Difficulty is the highest share difficulty met by the hash among the ones accepted
+	// for the connection, the current one and the previous ones still in their grace period
+	Difficulty uint64
+	// Block is set when the hash also meets the block target
+	Block bool
+}
+
+// ShareSink receives every valid share, for accounting
+// It is called from the goroutine of the connection, so it must not block for long
+type ShareSink interface {
+	SubmitShare(share Share)
+}
+
+// BlockSink receives the shares meeting the block target, to be sent upstream
+// An error is counted in Stats.FailedBlocks instead of Stats.Blocks, the share is still accepted
+type BlockSink interface {
+	SubmitBlock(share Share) error
+}
+
+// ShareSinkFunc adapts a function to the ShareSink interface
+type ShareSinkFunc func(share Share)
+
+func (f ShareSinkFunc) SubmitShare(share Share) {
+	f(share)
+}
+
+// BlockSinkFunc adapts a function to the BlockSink interface
+type BlockSinkFunc func(share Share) error
+
+func (f BlockSinkFunc) SubmitBlock(share Share) error {
+	return f(share)
+}
@@ -1,47 +1,125 @@
 package xelishash
 
-import "unsafe"
+import (
+	"context"
+	"unsafe"
+)
 
 type ThreadPool struct {
-	scratch chan *ScratchPadV2
+	workers chan *worker
+}
+
+// worker holds the memory of one thread of the pool
+type worker struct {
+	scratch *ScratchPadV2
+	// hashers of the algorithms registered with RegisterAlgorithm, created on first use
+	hashers map[*algorithm]Hasher
+}
+
+// hash runs the algorithm registered under the given name
+// The built-in algorithms share the scratch pad, the others get their own Hasher
+func (w *worker) hash(algo string, input []byte) (Hash, error) {
+	a, ok := lookupAlgorithm(algo)
+	if !ok {
+		return Hash{}, ErrUnknownAlgorithm
+	}
+	if a.pooled != nil {
+		return a.pooled(input, w.scratch)
+	}
+
+	hasher, ok := w.hashers[a]
+	if !ok {
+		var err error
+		if hasher, err = a.factory(); err != nil {
+			return Hash{}, err
+		}
+		w.hashers[a] = hasher
+	}
+	return hasher.Hash(input)
 }
 
 func NewThreadPool(threads int) *ThreadPool {
 	tp := &ThreadPool{
-		scratch: make(chan *ScratchPadV2, threads),
+		workers: make(chan *worker, threads),
 	}
 
 	for i := 0; i < threads; i++ {
-		tp.scratch <- &ScratchPadV2{}
+		tp.workers <- &worker{
+			scratch: &ScratchPadV2{},
+			hashers: make(map[*algorithm]Hasher),
+		}
 	}
 
 	return tp
 }
 
-// Hash accepts algorithm name as string (example: xel/0, xel/1)
-func (t *ThreadPool) Hash(algo string, input []byte) [32]byte {
-	if algo == "xel/1" { // XelisHash v2
-		return t.XelisHashV2(input)
+// Hash accepts algorithm name as string (example: xel/0, xel/1, xel/dev)
+// Any algorithm of the registry can be used, an unknown one falls back to xel/0
+// It panics if the algorithm fails, TryHash returns the error instead
+func (t *ThreadPool) Hash(algo string, input []byte) Hash {
+	hash, err := t.TryHash(algo, input)
+	if err == ErrUnknownAlgorithm {
+		return t.XelisHash(input)
+	}
+	if err != nil {
+		panic(err)
 	}
-	return t.XelisHash(input)
+	return hash
 }
 
-func (t *ThreadPool) XelisHash(input []byte) [32]byte {
-	scratch := <-t.scratch
+// TryHash is like Hash, but an unknown algorithm returns ErrUnknownAlgorithm
+// The xel/0 input must be exactly BYTES_ARRAY_INPUT bytes, see MinerWork.V1
+func (t *ThreadPool) TryHash(algo string, input []byte) (Hash, error) {
+	w := <-t.workers
 
 	defer func() {
-		t.scratch <- scratch
+		t.workers <- w
 	}()
 
-	return XelisHash(input, (*ScratchPad)(unsafe.Pointer(scratch)))
+	return w.hash(algo, input)
 }
 
-func (t *ThreadPool) XelisHashV2(input []byte) [32]byte {
-	scratch := <-t.scratch
+// XelisHash panics if the input is shorter than BYTES_ARRAY_INPUT bytes, see XelisHash
+func (t *ThreadPool) XelisHash(input []byte) Hash {
+	w := <-t.workers
 
 	defer func() {
-		t.scratch <- scratch
+		t.workers <- w
 	}()
 
-	return XelisHashV2(input, scratch)
+	return XelisHash(input, (*ScratchPad)(unsafe.Pointer(w.scratch)))
+}
+
+func (t *ThreadPool) XelisHashV2(input []byte) Hash {
+	w := <-t.workers
+
+	defer func() {
+		t.workers <- w
+	}()
+
+	return XelisHashV2(input, w.scratch)
+}
+
+func (t *ThreadPool) XelisHashDev(input []byte) Hash {
+	w := <-t.workers
+
+	defer func() {
+		t.workers <- w
+	}()
+
+	return XelisHashDev(input, (*ScratchPadDev)(unsafe.Pointer(w.scratch)))
+}
+
+// HashContext is like TryHash, but gives up waiting for a free scratch pad once the context is done
+func (t *ThreadPool) HashContext(ctx context.Context, algo string, input []byte) (Hash, error) {
+	select {
+	case w := <-t.workers:
+		defer func() {
+			t.workers <- w
+		}()
+
+		return w.hash(algo, input)
+	case <-ctx.Done():
+		return Hash{}, ctx.Err()
+	}
 }
@@ -1,8 +1,10 @@
 package xelishash
 
 import (
+	"context"
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestThreadPool(t *testing.T) {
@@ -23,7 +25,7 @@ func TestThreadPool(t *testing.T) {
 
 	inputV2 := input[:112]
 
-	expectedHashV2 := [32]byte{
+	expectedHashV2 := Hash{
 		126, 219, 112, 240, 116, 133, 115, 144, 39, 40, 164,
 		105, 30, 158, 45, 126, 64, 67, 238, 52, 200, 35,
 		161, 19, 144, 211, 214, 225, 95, 190, 146, 27,
@@ -40,7 +42,7 @@ func TestThreadPool(t *testing.T) {
 		172, 220, 137, 143, 234, 68, 188,
 	}
 
-	expectedHashV2_2 := [32]byte{
+	expectedHashV2_2 := Hash{
 		199, 114, 154, 28, 4, 164, 196, 178, 117, 17, 148,
 		203, 125, 228, 51, 145, 162, 222, 106, 202, 205,
 		55, 244, 178, 94, 29, 248, 242, 98, 221, 158, 179,
@@ -50,18 +52,18 @@ func TestThreadPool(t *testing.T) {
 		if i2%2 == 0 {
 			go func() {
 				result := tp.XelisHashV2(inputV2)
-				t.Logf("xelishash v2 result 1: %x", result)
+				t.Logf("xelishash v2 result 1: %s", result)
 				if result != expectedHashV2 {
-					panic(fmt.Errorf("invalid result %x, expected %x", result, expectedHashV2))
+					panic(fmt.Errorf("invalid result %s, expected %s", result, expectedHashV2))
 				}
 				endchan <- true
 			}()
 		} else {
 			go func() {
 				result := tp.XelisHashV2(inputV2_2)
-				t.Logf("xelishash v2 result 2: %x", result)
+				t.Logf("xelishash v2 result 2: %s", result)
 				if result != expectedHashV2_2 {
-					panic(fmt.Errorf("invalid result %x, expected %x", result, expectedHashV2))
+					panic(fmt.Errorf("invalid result %s, expected %s", result, expectedHashV2))
 				}
 				endchan <- true
 			}()
@@ -71,3 +73,76 @@ func TestThreadPool(t *testing.T) {
 		<-endchan
 	}
 }
+
+func TestThreadPoolHashContext(t *testing.T) {
+	tp := NewThreadPool(1)
+	input := make([]byte, 112)
+
+	hash, err := tp.HashContext(context.Background(), ALGO_V2, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := tp.Hash(ALGO_V2, input); hash != expected {
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expected)
+	}
+
+	if _, err := tp.HashContext(context.Background(), "xel/unknown", input); err != ErrUnknownAlgorithm {
+		t.Fatalf("got error %v, expected %v", err, ErrUnknownAlgorithm)
+	}
+
+	// hold the only scratch pad so the next call has to wait
+	w := <-tp.workers
+	defer func() {
+		tp.workers <- w
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := tp.HashContext(ctx, ALGO_V2, input); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestThreadPoolHash(t *testing.T) {
+	tp := NewThreadPool(1)
+	input := make([]byte, BYTES_ARRAY_INPUT)
+	copy(input, []byte("xelis-hashing-algorithm"))
+
+	// unknown algorithms fall back to xel/0, as before the registry
+	if hash := tp.Hash("xel/unknown", input); hash != tp.XelisHash(input) {
+		t.Fatalf("incorrect hash: %s, expected the xel/0 hash", hash)
+	}
+
+	defer func() {
+		if r := recover(); r != ErrInvalidInputSize {
+			t.Fatalf("got panic %v, expected %v", r, ErrInvalidInputSize)
+		}
+	}()
+	tp.Hash(ALGO_V2, nil)
+}
+
+func TestThreadPoolRegistry(t *testing.T) {
+	tp := NewThreadPool(1)
+	input := make([]byte, 112)
+
+	if _, err := tp.TryHash("xel/unknown", input); err != ErrUnknownAlgorithm {
+		t.Fatalf("got error %v, expected %v", err, ErrUnknownAlgorithm)
+	}
+
+	// xel/0 only hashes padded inputs
+	if _, err := tp.TryHash(ALGO_V1, input); err != ErrInvalidInputSize {
+		t.Fatalf("got error %v, expected %v", err, ErrInvalidInputSize)
+	}
+	if _, err := tp.TryHash(ALGO_V2, nil); err != ErrInvalidInputSize {
+		t.Fatalf("got error %v, expected %v", err, ErrInvalidInputSize)
+	}
+
+	// registered algorithms run in the pool too, see init in hasher_test.go
+	hash, err := tp.TryHash("xel/pooled", input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := (FakeHasher{}).Hash(input); hash != expected {
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expected)
+	}
+}
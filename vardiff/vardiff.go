@@ -0,0 +1,160 @@
+// Package vardiff adjusts the share difficulty of a connection to reach a target share rate
+//
+// The controller estimates the hashrate of the connection from the shares received
+// since the last retarget, smooths it with an exponential moving average,
+// and picks the difficulty giving the configured number of shares per minute
+package vardiff
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	ErrInvalidSharesPerMinute = errors.New("vardiff: shares per minute must be positive")
+	ErrInvalidRetarget        = errors.New("vardiff: retarget interval must be positive")
+	ErrInvalidBounds          = errors.New("vardiff: invalid difficulty bounds")
+	ErrInvalidSmoothing       = errors.New("vardiff: smoothing must be in (0, 1]")
+	ErrInvalidVariance        = errors.New("vardiff: variance must be finite and not negative")
+	ErrInvalidMaxStep         = errors.New("vardiff: max step must be 0 or greater than 1")
+)
+
+type Config struct {
+	// SharesPerMinute is the share rate aimed for each connection
+	SharesPerMinute float64
+	// RetargetInterval is the minimum time between two retargets
+	RetargetInterval time.Duration
+	// MinDifficulty and MaxDifficulty bound the share difficulty, MaxDifficulty 0 means no bound
+	MinDifficulty uint64
+	MaxDifficulty uint64
+	// Smoothing is the weight of the last estimation in the hashrate average, 1 disables smoothing
+	Smoothing float64
+	// Variance is the relative deviation from the current difficulty tolerated without retargeting
+	Variance float64
+	// MaxStep limits the factor by which the difficulty changes at each retarget, 0 means no limit
+	// A step of 1 or less would freeze the difficulty, so it is refused
+	MaxStep float64
+}
+
+// DefaultConfig aims for 10 shares per minute, retargeting at most every 30 seconds
+var DefaultConfig = Config{
+	SharesPerMinute:  10,
+	RetargetInterval: 30 * time.Second,
+	MinDifficulty:    1,
+	Smoothing:        0.5,
+	Variance:         0.3,
+	MaxStep:          4,
+}
+
+func (c *Config) Validate() error {
+	if !(c.SharesPerMinute > 0) {
+		return ErrInvalidSharesPerMinute
+	}
+	if c.RetargetInterval <= 0 {
+		return ErrInvalidRetarget
+	}
+	if c.MinDifficulty == 0 || (c.MaxDifficulty != 0 && c.MaxDifficulty < c.MinDifficulty) {
+		return ErrInvalidBounds
+	}
+	if !(c.Smoothing > 0 && c.Smoothing <= 1) {
+		return ErrInvalidSmoothing
+	}
+	if !(c.Variance >= 0) || math.IsInf(c.Variance, 1) {
+		return ErrInvalidVariance
+	}
+	if !(c.MaxStep == 0 || c.MaxStep > 1) {
+		return ErrInvalidMaxStep
+	}
+	return nil
+}
+
+// Controller tracks the shares of a single connection
+// Times are given by the caller, so simulations can drive it with synthetic timings
+// It is not safe for concurrent use
+type Controller struct {
+	config     Config
+	difficulty uint64
+	// hashrate is the smoothed estimation, in difficulty per second, 0 until the first retarget
+	hashrate float64
+	since    time.Time
+	shares   uint64
+}
+
+// New returns a controller starting at the given difficulty, clamped to the bounds
+func New(config Config, difficulty uint64, now time.Time) (*Controller, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := &Controller{config: config, since: now}
+	c.difficulty = c.clamp(float64(difficulty))
+	return c, nil
+}
+
+// Difficulty returns the current share difficulty
+func (c *Controller) Difficulty() uint64 {
+	return c.difficulty
+}
+
+// Hashrate returns the smoothed hashrate estimation in difficulty per second
+// For the XelisHash algorithms one unit of difficulty is one hash on average
+func (c *Controller) Hashrate() float64 {
+	return c.hashrate
+}
+
+// Share records an accepted share at the current difficulty
+// It returns the new difficulty and true when a retarget happened
+func (c *Controller) Share(now time.Time) (uint64, bool) {
+	c.shares++
+	return c.Tick(now)
+}
+
+// Tick retargets if the retarget interval elapsed, so connections sending no shares get a lower difficulty
+// It returns the new difficulty and true when the difficulty changed
+func (c *Controller) Tick(now time.Time) (uint64, bool) {
+	elapsed := now.Sub(c.since)
+	if elapsed < c.config.RetargetInterval {
+		return c.difficulty, false
+	}
+
+	estimation := float64(c.shares) * float64(c.difficulty) / elapsed.Seconds()
+	if c.hashrate == 0 {
+		c.hashrate = estimation
+	} else {
+		c.hashrate = c.config.Smoothing*estimation + (1-c.config.Smoothing)*c.hashrate
+	}
+	c.since = now
+	c.shares = 0
+
+	wanted := c.hashrate * 60 / c.config.SharesPerMinute
+	current := float64(c.difficulty)
+	if math.Abs(wanted-current) <= c.config.Variance*current {
+		return c.difficulty, false
+	}
+
+	if step := c.config.MaxStep; step > 1 {
+		wanted = math.Min(math.Max(wanted, current/step), current*step)
+	}
+
+	difficulty := c.clamp(wanted)
+	if difficulty == c.difficulty {
+		return c.difficulty, false
+	}
+	c.difficulty = difficulty
+	return difficulty, true
+}
+
+func (c *Controller) clamp(difficulty float64) uint64 {
+	min := float64(c.config.MinDifficulty)
+	if difficulty < min {
+		return c.config.MinDifficulty
+	}
+	if c.config.MaxDifficulty != 0 && difficulty > float64(c.config.MaxDifficulty) {
+		return c.config.MaxDifficulty
+	}
+	if difficulty >= math.MaxUint64 {
+		return math.MaxUint64
+	}
+	return uint64(difficulty)
+}
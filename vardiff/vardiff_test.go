@@ -0,0 +1,197 @@
+package vardiff
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var epoch = time.Unix(1736271107, 0)
+
+// simulate feeds the controller with the shares of a miner at the given hashrate
+// Share times follow an exponential distribution, like real mining
+// It returns the number of shares sent and the time after the simulation
+func simulate(c *Controller, rng *rand.Rand, hashrate float64, now time.Time, duration time.Duration) (int, time.Time) {
+	end := now.Add(duration)
+	tick := now.Add(time.Second)
+	next := now.Add(time.Duration(rng.ExpFloat64() * float64(c.Difficulty()) / hashrate * float64(time.Second)))
+
+	shares := 0
+	for {
+		if next.Before(tick) {
+			if next.After(end) {
+				return shares, end
+			}
+			shares++
+			c.Share(next)
+			now = next
+			next = now.Add(time.Duration(rng.ExpFloat64() * float64(c.Difficulty()) / hashrate * float64(time.Second)))
+		} else {
+			if tick.After(end) {
+				return shares, end
+			}
+			if _, changed := c.Tick(tick); changed {
+				// the time to the next share only depends on the new difficulty
+				next = tick.Add(time.Duration(rng.ExpFloat64() * float64(c.Difficulty()) / hashrate * float64(time.Second)))
+			}
+			now = tick
+			tick = tick.Add(time.Second)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := DefaultConfig.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		change func(*Config)
+		err    error
+	}{
+		{func(c *Config) { c.SharesPerMinute = 0 }, ErrInvalidSharesPerMinute},
+		{func(c *Config) { c.SharesPerMinute = math.NaN() }, ErrInvalidSharesPerMinute},
+		{func(c *Config) { c.RetargetInterval = 0 }, ErrInvalidRetarget},
+		{func(c *Config) { c.MinDifficulty = 0 }, ErrInvalidBounds},
+		{func(c *Config) { c.MinDifficulty, c.MaxDifficulty = 10, 5 }, ErrInvalidBounds},
+		{func(c *Config) { c.Smoothing = 0 }, ErrInvalidSmoothing},
+		{func(c *Config) { c.Smoothing = 1.5 }, ErrInvalidSmoothing},
+		{func(c *Config) { c.Variance = -0.1 }, ErrInvalidVariance},
+		{func(c *Config) { c.Variance = math.NaN() }, ErrInvalidVariance},
+		{func(c *Config) { c.Variance = math.Inf(1) }, ErrInvalidVariance},
+		{func(c *Config) { c.MaxStep = 1 }, ErrInvalidMaxStep},
+		{func(c *Config) { c.MaxStep = -2 }, ErrInvalidMaxStep},
+		{func(c *Config) { c.MaxStep = math.NaN() }, ErrInvalidMaxStep},
+	}
+	for i, test := range tests {
+		config := DefaultConfig
+		test.change(&config)
+		if _, err := New(config, 1, epoch); err != test.err {
+			t.Errorf("test %d: expected %v, got %v", i, test.err, err)
+		}
+	}
+}
+
+func TestConvergence(t *testing.T) {
+	tests := []struct {
+		hashrate float64
+		start    uint64
+	}{
+		// large rig starting at the minimum difficulty
+		{hashrate: 1e6, start: 1},
+		// small miner starting far too high
+		{hashrate: 50, start: 1 << 20},
+		// already close
+		{hashrate: 5000, start: 25000},
+	}
+
+	for _, test := range tests {
+		rng := rand.New(rand.NewSource(1))
+		c, err := New(DefaultConfig, test.start, epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, now := simulate(c, rng, test.hashrate, epoch, 30*time.Minute)
+
+		// the miner should now send about SharesPerMinute shares
+		wanted := test.hashrate * 60 / DefaultConfig.SharesPerMinute
+		if d := float64(c.Difficulty()); d < wanted/2 || d > wanted*2 {
+			t.Errorf("hashrate %v: difficulty %d, expected about %v", test.hashrate, c.Difficulty(), wanted)
+		}
+
+		shares, _ := simulate(c, rng, test.hashrate, now, 30*time.Minute)
+		if rate := float64(shares) / 30; rate < 5 || rate > 20 {
+			t.Errorf("hashrate %v: %v shares per minute", test.hashrate, rate)
+		}
+	}
+}
+
+func TestSteadyMiner(t *testing.T) {
+	// a miner at the right difficulty sending perfectly regular shares is never retargeted
+	c, err := New(DefaultConfig, 6000, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := epoch
+	for i := 0; i < 100; i++ {
+		now = now.Add(6 * time.Second)
+		if _, changed := c.Share(now); changed {
+			t.Fatalf("share %d: unexpected retarget to %d", i, c.Difficulty())
+		}
+	}
+	if hashrate := c.Hashrate(); math.Abs(hashrate-1000) > 1 {
+		t.Fatalf("incorrect hashrate %v", hashrate)
+	}
+}
+
+func TestStarvation(t *testing.T) {
+	config := DefaultConfig
+	config.MinDifficulty = 100
+	c, err := New(config, 1<<20, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without shares, each retarget divides the difficulty by MaxStep until the minimum
+	now := epoch
+	expected := uint64(1 << 20)
+	for expected > config.MinDifficulty {
+		now = now.Add(config.RetargetInterval)
+		expected /= 4
+		if expected < config.MinDifficulty {
+			expected = config.MinDifficulty
+		}
+
+		difficulty, changed := c.Tick(now)
+		if !changed || difficulty != expected {
+			t.Fatalf("expected %d, got %d", expected, difficulty)
+		}
+	}
+
+	if _, changed := c.Tick(now.Add(config.RetargetInterval)); changed {
+		t.Fatal("the difficulty cannot go below the minimum")
+	}
+}
+
+func TestBounds(t *testing.T) {
+	config := DefaultConfig
+	config.MinDifficulty = 10
+	config.MaxDifficulty = 1000
+	config.MaxStep = 0
+
+	c, err := New(config, 1, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Difficulty() != 10 {
+		t.Fatal("the initial difficulty must be clamped")
+	}
+
+	rng := rand.New(rand.NewSource(2))
+	simulate(c, rng, 1e6, epoch, 10*time.Minute)
+	if c.Difficulty() != 1000 {
+		t.Fatalf("the difficulty must stop at the maximum, got %d", c.Difficulty())
+	}
+}
+
+func TestRetargetInterval(t *testing.T) {
+	c, err := New(DefaultConfig, 1, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a flood of shares doesn't retarget before the interval
+	for i := 1; i < 1000; i++ {
+		if _, changed := c.Share(epoch.Add(time.Duration(i) * time.Millisecond)); changed {
+			t.Fatal("retarget before the interval")
+		}
+	}
+
+	difficulty, changed := c.Share(epoch.Add(DefaultConfig.RetargetInterval))
+	if !changed || difficulty != 4 {
+		t.Fatalf("the change must be limited by MaxStep, got %d", difficulty)
+	}
+}
@@ -18,9 +18,8 @@ const HASH_SIZE = 32
 const STAGE_1_MAX = MEMORY_SIZE / KECCAK_WORDS
 
 type ScratchPad [MEMORY_SIZE]uint64
-type Hash [HASH_SIZE]byte
 
-func stage_1(int_input *[KECCAK_WORDS]uint64, scratch_pad *ScratchPad, a0 uint64, a1 uint64, b0 uint64, b1 uint64) {
+func stage_1(int_input *[KECCAK_WORDS]uint64, scratch_pad []uint64, a0 uint64, a1 uint64, b0 uint64, b1 uint64) {
 	for i := a0; i <= a1; i++ {
 		keccakp(int_input)
 
@@ -55,46 +54,86 @@ func stage_1(int_input *[KECCAK_WORDS]uint64, scratch_pad *ScratchPad, a0 uint64
 	}
 }
 
-func XelisHash(input []byte, scratch_pad *ScratchPad) [32]byte {
+// XelisHash computes the xel/0 hash of the first BYTES_ARRAY_INPUT bytes of the input
+// It panics if the input is shorter, HasherV1 and ThreadPool.Hash return ErrInvalidInputSize instead
+// It is the consensus path: the sizes are constants, xelis_hash_v1 runs the other parameters
+func XelisHash(input []byte, scratch_pad *ScratchPad) Hash {
 	var int_input *[KECCAK_WORDS]uint64 = intInput([BYTES_ARRAY_INPUT]byte(input[:BYTES_ARRAY_INPUT]))
 
 	// stage 1
-	stage_1(int_input, scratch_pad, 0, STAGE_1_MAX-1, 0, KECCAK_WORDS-1)
-	stage_1(int_input, scratch_pad, STAGE_1_MAX, STAGE_1_MAX, 0, 17)
+	stage_1(int_input, scratch_pad[:], 0, STAGE_1_MAX-1, 0, KECCAK_WORDS-1)
+	stage_1(int_input, scratch_pad[:], STAGE_1_MAX, STAGE_1_MAX, 0, MEMORY_SIZE%KECCAK_WORDS-1)
 
 	// stage 2
+	small_pad := (*[MEMORY_SIZE * 2]uint32)(scratchpadToSmallpad(scratch_pad[:]))
 
-	// this is equal to MEMORY_SIZE, just in u32 format
-	var slots [SLOT_LENGTH]uint32
+	slots := [SLOT_LENGTH]uint32(small_pad[len(small_pad)-SLOT_LENGTH:])
+	var indices [SLOT_LENGTH]uint16
+	stage_2(small_pad[:], slots[:], indices[:], ITERS)
 
-	var small_pad *[MEMORY_SIZE * 2]uint32 = scratchpadToSmallpad(scratch_pad)
+	copy(small_pad[len(small_pad)-SLOT_LENGTH:], slots[:])
 
-	slots = [SLOT_LENGTH]uint32(small_pad[len(small_pad)-SLOT_LENGTH:])
+	// stage 3
+	var mem_buffer_a [BUFFER_SIZE]uint64
+	var mem_buffer_b [BUFFER_SIZE]uint64
+	return stage_3_v1(scratch_pad[:], SCRATCHPAD_ITERS, mem_buffer_a[:], mem_buffer_b[:])
+}
 
-	var indices [SLOT_LENGTH]uint16
+// xelis_hash_v1 runs the algorithm with the given parameters
+// slots and indices must be SlotLength long, the mem buffers BufferSize long
+func xelis_hash_v1(input []byte, scratch_pad []uint64, params *ParamsV1, slots []uint32, indices []uint16, mem_buffer_a []uint64, mem_buffer_b []uint64) Hash {
+	var int_input *[KECCAK_WORDS]uint64 = intInput([BYTES_ARRAY_INPUT]byte(input[:BYTES_ARRAY_INPUT]))
+
+	memory_size := uint64(len(scratch_pad))
+	slot_length := len(slots)
+
+	// stage 1
+	stage_1_max := memory_size / KECCAK_WORDS
+	stage_1(int_input, scratch_pad, 0, stage_1_max-1, 0, KECCAK_WORDS-1)
+	if last_words := memory_size % KECCAK_WORDS; last_words > 0 {
+		stage_1(int_input, scratch_pad, stage_1_max, stage_1_max, 0, last_words-1)
+	}
+
+	// stage 2
+
+	var small_pad []uint32 = scratchpadToSmallpad(scratch_pad)
 
-	for i := 0; i < ITERS; i++ {
-		for j := 0; j < len(small_pad)/SLOT_LENGTH; j++ {
+	copy(slots, small_pad[len(small_pad)-slot_length:])
+
+	stage_2(small_pad, slots, indices, params.Iters)
+
+	copy(small_pad[len(small_pad)-slot_length:], slots)
+
+	// stage 3
+	return stage_3_v1(scratch_pad, params.ScratchpadIters, mem_buffer_a, mem_buffer_b)
+}
+
+// stage_2 shuffles the slots with the small pad, slots and indices are slot_length long
+func stage_2(small_pad []uint32, slots []uint32, indices []uint16, iters int) {
+	slot_length := len(slots)
+
+	for i := 0; i < iters; i++ {
+		for j := 0; j < len(small_pad)/slot_length; j++ {
 			// Initialize indices and precompute the total sum of small pad
 			var total_sum uint32 = 0
-			for k := 0; k < SLOT_LENGTH; k++ {
+			for k := 0; k < slot_length; k++ {
 				indices[k] = uint16(k)
 				if slots[k]>>31 == 0 {
-					total_sum += small_pad[j*SLOT_LENGTH+k]
+					total_sum += small_pad[j*slot_length+k]
 				} else {
-					total_sum -= small_pad[j*SLOT_LENGTH+k]
+					total_sum -= small_pad[j*slot_length+k]
 				}
 			}
 
-			for slot_idx := SLOT_LENGTH - 1; slot_idx >= 0; slot_idx-- {
+			for slot_idx := slot_length - 1; slot_idx >= 0; slot_idx-- {
 
-				index_in_indices := int((small_pad[j*SLOT_LENGTH+slot_idx] % (uint32(slot_idx) + 1)))
+				index_in_indices := int((small_pad[j*slot_length+slot_idx] % (uint32(slot_idx) + 1)))
 				index := int(indices[index_in_indices])
 				indices[index_in_indices] = indices[slot_idx]
 
 				local_sum := total_sum
 				s1 := int32(slots[index] >> 31)
-				pad_value := small_pad[j*SLOT_LENGTH+index]
+				pad_value := small_pad[j*slot_length+index]
 				if s1 == 0 {
 					local_sum -= pad_value
 				} else {
@@ -106,33 +145,39 @@ func XelisHash(input []byte, scratch_pad *ScratchPad) [32]byte {
 
 				// Update the total sum
 				s2 := int32(slots[index] >> 31)
-				total_sum -= 2 * small_pad[j*SLOT_LENGTH+index] * uint32(-s1+s2)
+				total_sum -= 2 * small_pad[j*slot_length+index] * uint32(-s1+s2)
 			}
 		}
 	}
+}
 
-	copy(small_pad[(MEMORY_SIZE*8/4)-SLOT_LENGTH:], slots[:])
+// stage_3_v1 hashes the scratch pad into the result, the buffers must have the same length
+// The scratch pad size must be a power of two, the addresses are masked from it
+func stage_3_v1(scratch_pad []uint64, iters int, mem_buffer_a []uint64, mem_buffer_b []uint64) Hash {
+	memory_size := uint64(len(scratch_pad))
+	buffer_size := len(mem_buffer_a)
 
-	// stage 3
 	var key [16]byte
 	var block [16]byte
 
-	addr_a := (scratch_pad[MEMORY_SIZE-1] >> 15) & 0x7FFF
-	addr_b := scratch_pad[MEMORY_SIZE-1] & 0x7FFF
+	// the addresses are derived from the memory size, which is a power of two
+	addr_mask := memory_size - 1
+	addr_shift := bits.TrailingZeros64(memory_size)
 
-	var mem_buffer_a [BUFFER_SIZE]uint64
-	var mem_buffer_b [BUFFER_SIZE]uint64
+	addr_a := (scratch_pad[memory_size-1] >> addr_shift) & addr_mask
+	addr_b := scratch_pad[memory_size-1] & addr_mask
 
-	for i := 0; i < BUFFER_SIZE; i++ {
-		mem_buffer_a[i] = scratch_pad[((addr_a + uint64(i)) % MEMORY_SIZE)]
-		mem_buffer_b[i] = scratch_pad[((addr_b + uint64(i)) % MEMORY_SIZE)]
+	for i := 0; i < buffer_size; i++ {
+		mem_buffer_a[i] = scratch_pad[((addr_a + uint64(i)) % memory_size)]
+		mem_buffer_b[i] = scratch_pad[((addr_b + uint64(i)) % memory_size)]
 	}
 
 	var final_result Hash
 
-	for i := 0; i < SCRATCHPAD_ITERS; i++ {
-		mem_a := mem_buffer_a[i%BUFFER_SIZE]
-		mem_b := mem_buffer_b[i%BUFFER_SIZE]
+	for i := 0; i < iters; i++ {
+		slot := i % buffer_size
+		mem_a := mem_buffer_a[slot]
+		mem_b := mem_buffer_b[slot]
 
 		copy(block[:8], toLE(mem_b))
 		copy(block[8:], toLE(mem_a))
@@ -144,9 +189,14 @@ func XelisHash(input []byte, scratch_pad *ScratchPad) [32]byte {
 
 		result := ^(hash1 ^ hash2)
 
+		// k is (i + j) % buffer_size, without a division per step
+		k := slot
 		for j := 0; j < HASH_SIZE; j++ {
-			a := mem_buffer_a[(j+i)%BUFFER_SIZE]
-			b := mem_buffer_b[(j+i)%BUFFER_SIZE]
+			a := mem_buffer_a[k]
+			b := mem_buffer_b[k]
+			if k++; k == buffer_size {
+				k = 0
+			}
 
 			// more branching
 			switch (result >> (j * 2)) & 0xf {
@@ -185,18 +235,17 @@ func XelisHash(input []byte, scratch_pad *ScratchPad) [32]byte {
 			}
 		}
 
-		addr_b = result & 0x7FFF
-		mem_buffer_a[i%BUFFER_SIZE] = result
-		mem_buffer_b[i%BUFFER_SIZE] = scratch_pad[addr_b]
+		addr_b = result & addr_mask
+		mem_buffer_a[slot] = result
+		mem_buffer_b[slot] = scratch_pad[addr_b]
 
-		addr_a = (result >> 15) & 0x7FFF
+		addr_a = (result >> addr_shift) & addr_mask
 		scratch_pad[addr_a] = result
 
-		index := SCRATCHPAD_ITERS - i - 1
+		index := iters - i - 1
 		if index < 4 {
-			copy(final_result[index*8:(SCRATCHPAD_ITERS-i)*8], toBE(result))
+			copy(final_result[index*8:(iters-i)*8], toBE(result))
 		}
 	}
-
 	return final_result
 }
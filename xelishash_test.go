@@ -6,12 +6,12 @@ import (
 	"time"
 )
 
-func testInput(input []byte, expected_hash [32]byte) error {
+func testInput(input []byte, expected_hash Hash) error {
 	var scratch_pad ScratchPad
 	hash := XelisHash(input, &scratch_pad)
 
 	if hash != expected_hash {
-		return fmt.Errorf("hash %x does not match expected hash %x", hash, expected_hash)
+		return fmt.Errorf("hash %s does not match expected hash %s", hash, expected_hash)
 	}
 
 	return nil
@@ -0,0 +1,40 @@
+package xelishash
+
+import (
+	"unsafe"
+
+	"github.com/zeebo/blake3"
+)
+
+// Dev mode runs XelisHashV2 with a tiny scratch pad
+// It is only meant for unit tests and local networks, never for consensus
+// In bytes, this is equal to 2KB
+const MEMORY_SIZE_DEV = 256
+
+const SCRATCHPAD_ITERS_DEV = SCRATCHPAD_ITERS_V2
+
+// ScratchPadDev used to store intermediate values of the dev mode algorithm
+// It can be easily reused for multiple hashing operations safely
+type ScratchPadDev [MEMORY_SIZE_DEV]uint64
+
+// DevParamsV2 returns the parameters used by XelisHashDev
+func DevParamsV2() ParamsV2 {
+	params := DefaultParamsV2()
+	params.MemorySize = MEMORY_SIZE_DEV
+	params.ScratchpadIters = SCRATCHPAD_ITERS_DEV
+	return params
+}
+
+// XelisHashDev has the same structure as XelisHashV2 with a much smaller scratch pad
+func XelisHashDev(input []byte, scratch_pad *ScratchPadDev) Hash {
+	// stage 1
+	scratchpad_bytes := (*[MEMORY_SIZE_DEV * 8]byte)(unsafe.Pointer(scratch_pad))
+	stage_1_v2(input, scratchpad_bytes[:], CHUNK_SIZE_V2)
+
+	// stage 3
+	key := [16]byte([]byte(KEY))
+	stage_3(scratch_pad[:], SCRATCHPAD_ITERS_DEV, &key)
+
+	// stage 4
+	return blake3.Sum256(scratchpad_bytes[:])
+}
@@ -0,0 +1,57 @@
+package xelishash
+
+import (
+	"testing"
+)
+
+func TestZeroHashDev(t *testing.T) {
+	scratchpad := ScratchPadDev{}
+	input := make([]byte, 112)
+
+	hash := XelisHashDev(input, &scratchpad)
+	expectedHash := Hash{
+		28, 197, 201, 156, 207, 121, 38, 202, 228, 111, 248,
+		79, 237, 50, 89, 42, 22, 28, 96, 164, 136, 131,
+		29, 51, 9, 109, 28, 250, 75, 11, 3, 125,
+	}
+
+	if hash != expectedHash {
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expectedHash)
+	}
+
+	tp := NewThreadPool(1)
+	if hash, err := tp.TryHash(ALGO_DEV, input); err != nil || hash != expectedHash {
+		t.Fatalf("incorrect thread pool hash: %s, %v, expected: %s", hash, err, expectedHash)
+	}
+}
+
+func TestHasherDevParams(t *testing.T) {
+	hasher, err := NewHasherV2(DevParamsV2())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scratchpad ScratchPadDev
+	input := make([]byte, 112)
+	for i := 0; i < 16; i++ {
+		input[40] = byte(i)
+		expected := XelisHashDev(input, &scratchpad)
+		hash, err := hasher.Hash(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != expected {
+			t.Fatalf("incorrect hash: %s, expected: %s", hash, expected)
+		}
+	}
+}
+
+func BenchmarkHashDev(b *testing.B) {
+	var scratch_pad ScratchPadDev
+
+	var input = make([]byte, 112)
+
+	for i := 0; i < b.N; i++ {
+		XelisHashDev(input, &scratch_pad)
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"lukechampine.com/uint128"
 )
 
-// These are tweakable parameters
+// These are tweakable parameters, see ParamsV2 to run the algorithm with other values
 // Memory size is the size of the scratch pad in u64s
 // In bytes, this is equal to ~ 440KB
 const MEMORY_SIZE_V2 = 429 * 128
@@ -38,7 +38,7 @@ type ScratchPadV2 [MEMORY_SIZE_V2]uint64
 // This stage is responsible for generating the scratch pad
 // The scratch pad is generated using Chacha8 with a custom nonce
 // that is updated after each iteration
-func stage_1_v2(input []byte, scratch_pad *[MEMORY_SIZE_V2 * 8]byte) {
+func stage_1_v2(input []byte, scratch_pad []byte, chunk_size int) {
 	output_offset := 0
 	nonce := [NONCE_SIZE_V2]byte{}
 
@@ -46,10 +46,14 @@ func stage_1_v2(input []byte, scratch_pad *[MEMORY_SIZE_V2 * 8]byte) {
 	input_hash := blake3.Sum256(input)
 	copy(nonce[:], input_hash[:NONCE_SIZE_V2])
 
-	num_chunks := (len(input) + CHUNK_SIZE_V2 - 1) / CHUNK_SIZE_V2
+	num_chunks := (len(input) + chunk_size - 1) / chunk_size
 
 	for chunk_index := 0; chunk_index < num_chunks; chunk_index++ {
-		chunk := input[chunk_index*CHUNK_SIZE_V2:]
+		end := (chunk_index + 1) * chunk_size
+		if end > len(input) {
+			end = len(input)
+		}
+		chunk := input[chunk_index*chunk_size : end]
 
 		// Concatenate the input hash with the chunk
 		tmp := [HASH_SIZE * 2]byte{}
@@ -65,7 +69,7 @@ func stage_1_v2(input []byte, scratch_pad *[MEMORY_SIZE_V2 * 8]byte) {
 		}
 
 		// Calculate the remaining size and how much to generate this iteration
-		current_output_size := OUTPUT_SIZE_V2 - output_offset
+		current_output_size := len(scratch_pad) - output_offset
 		// Remaining chunks
 		chunks_left := num_chunks - chunk_index
 		chunk_output_size := current_output_size / chunks_left
@@ -96,8 +100,70 @@ func stage_1_v2(input []byte, scratch_pad *[MEMORY_SIZE_V2 * 8]byte) {
 // Its goal is to have lot of random memory accesses
 // and some branching to make it hard to optimize on GPUs
 // it shouldn't be possible to parallelize this stage
-func stage_3(scratch_pad *ScratchPadV2) {
-	key := [16]byte([]byte(KEY))
+func stage_3(scratch_pad []uint64, iters int, key *[16]byte) {
+	// the addressing of the default size uses constant moduli, which is measurably faster
+	if len(scratch_pad) == MEMORY_SIZE_V2 {
+		stage_3_v2((*ScratchPadV2)(scratch_pad), iters, key)
+		return
+	}
+
+	block := [16]byte{}
+	memory_size := len(scratch_pad)
+	buffer_size := memory_size / 2
+	modulus := uint64(buffer_size)
+
+	// Create two new slices for each half
+	mem_buffer_a := scratch_pad[:buffer_size]
+	mem_buffer_b := scratch_pad[buffer_size:]
+
+	addr_a := mem_buffer_b[buffer_size-1]
+	addr_b := mem_buffer_a[buffer_size-1] >> 32
+	var r int = 0
+
+	for i := 0; i < iters; i++ {
+		mem_a := mem_buffer_a[addr_a%modulus]
+		mem_b := mem_buffer_b[addr_b%modulus]
+
+		copy(block[:8], (toBytesLE(&mem_b))[:])
+		copy(block[8:], (toBytesLE(&mem_a))[:])
+
+		aesRound2(&block, key)
+
+		hash1 := binary.LittleEndian.Uint64(block[:8])
+
+		hash2 := mem_a ^ mem_b
+		result := ^(hash1 ^ hash2)
+
+		for j := 0; j < buffer_size; j++ {
+			a := mem_buffer_a[result%modulus]
+			b := mem_buffer_b[^bits.RotateLeft64(result, -r)%modulus]
+			var c uint64
+			if r < buffer_size {
+				c = mem_buffer_a[r]
+			} else {
+				c = mem_buffer_b[r-buffer_size]
+			}
+			if r < memory_size-1 {
+				r++
+			} else {
+				r = 0
+			}
+
+			v := mix(result, a, b, c, r, i, j)
+
+			result = bits.RotateLeft64(v, 1)
+
+			t := mem_buffer_a[buffer_size-j-1] ^ result
+			mem_buffer_a[buffer_size-j-1] = t
+			mem_buffer_b[j] ^= bits.RotateLeft64(t, -int(result))
+		}
+		addr_a = result
+		addr_b = isqrt(result)
+	}
+}
+
+// stage_3_v2 is stage_3 for a scratch pad of the default size
+func stage_3_v2(scratch_pad *ScratchPadV2, iters int, key *[16]byte) {
 	block := [16]byte{}
 
 	// Create two new slices for each half
@@ -108,14 +174,14 @@ func stage_3(scratch_pad *ScratchPadV2) {
 	addr_b := mem_buffer_a[BUFFER_SIZE_V2-1] >> 32
 	var r int = 0
 
-	for i := 0; i < SCRATCHPAD_ITERS_V2; i++ {
+	for i := 0; i < iters; i++ {
 		mem_a := mem_buffer_a[int(addr_a%BUFFER_SIZE_V2)]
 		mem_b := mem_buffer_b[int(addr_b%BUFFER_SIZE_V2)]
 
 		copy(block[:8], (toBytesLE(&mem_b))[:])
 		copy(block[8:], (toBytesLE(&mem_a))[:])
 
-		aesRound2(&block, &key)
+		aesRound2(&block, key)
 
 		hash1 := binary.LittleEndian.Uint64(block[:8])
 
@@ -137,60 +203,7 @@ func stage_3(scratch_pad *ScratchPadV2) {
 				r = 0
 			}
 
-			var v uint64
-
-			switch bits.RotateLeft64(result, int(c)) & 0xf {
-			case 0:
-				v = result ^ bits.RotateLeft64(c, int(i*j)) ^ b
-			case 1:
-				v = result ^ bits.RotateLeft64(c, -int(i*j)) ^ a
-			case 2:
-				v = result ^ a ^ b ^ c
-			case 3:
-				v = result ^ (a+b)*c
-			case 4:
-				v = result ^ (b-c)*a
-			case 5:
-				v = result ^ (c - a + b)
-			case 6:
-				v = result ^ (a - b + c)
-			case 7:
-				v = result ^ (b*c + a)
-			case 8:
-				v = result ^ (c*a + b)
-			case 9:
-				v = result ^ a*b*c
-			case 10:
-				t1 := uint128.Uint128{Hi: a, Lo: b}
-				v = result ^ (t1.Mod64(c | 1))
-			case 11:
-				t1 := uint128.Uint128{Hi: b, Lo: c}
-				t2 := uint128.Uint128{Hi: bits.RotateLeft64(result, r), Lo: a | 2}
-				v = result ^ t1.Mod(t2).Lo
-			case 12:
-				t1 := uint128.Uint128{Hi: c, Lo: a}
-				v = result ^ (t1.Div64(b | 4).Lo)
-			case 13:
-				t1 := uint128.Uint128{Hi: bits.RotateLeft64(result, r), Lo: b}
-				t2 := uint128.Uint128{Hi: a, Lo: c | 8}
-
-				if t1.Cmp(t2) > 0 {
-					v = result ^ t1.Div(t2).Lo
-				} else {
-					v = result ^ (a ^ b)
-				}
-			case 14:
-				t1 := uint128.Uint128{Hi: b, Lo: a}
-				t2 := uint128.Uint128{Lo: c}
-				v = result ^ t1.MulWrap(t2).Hi
-			case 15:
-				t1 := uint128.Uint128{Hi: a, Lo: c}
-				t2 := uint128.Uint128{
-					Hi: bits.RotateLeft64(result, -r),
-					Lo: b,
-				}
-				v = result ^ t1.MulWrap(t2).Hi
-			}
+			v := mix(result, a, b, c, r, i, j)
 
 			result = bits.RotateLeft64(v, 1)
 
@@ -203,6 +216,65 @@ func stage_3(scratch_pad *ScratchPadV2) {
 	}
 }
 
+// mix is the branching step of the inner loop of stage 3
+func mix(result, a, b, c uint64, r, i, j int) uint64 {
+	var v uint64
+
+	switch bits.RotateLeft64(result, int(c)) & 0xf {
+	case 0:
+		v = result ^ bits.RotateLeft64(c, int(i*j)) ^ b
+	case 1:
+		v = result ^ bits.RotateLeft64(c, -int(i*j)) ^ a
+	case 2:
+		v = result ^ a ^ b ^ c
+	case 3:
+		v = result ^ (a+b)*c
+	case 4:
+		v = result ^ (b-c)*a
+	case 5:
+		v = result ^ (c - a + b)
+	case 6:
+		v = result ^ (a - b + c)
+	case 7:
+		v = result ^ (b*c + a)
+	case 8:
+		v = result ^ (c*a + b)
+	case 9:
+		v = result ^ a*b*c
+	case 10:
+		t1 := uint128.Uint128{Hi: a, Lo: b}
+		v = result ^ (t1.Mod64(c | 1))
+	case 11:
+		t1 := uint128.Uint128{Hi: b, Lo: c}
+		t2 := uint128.Uint128{Hi: bits.RotateLeft64(result, r), Lo: a | 2}
+		v = result ^ t1.Mod(t2).Lo
+	case 12:
+		t1 := uint128.Uint128{Hi: c, Lo: a}
+		v = result ^ (t1.Div64(b | 4).Lo)
+	case 13:
+		t1 := uint128.Uint128{Hi: bits.RotateLeft64(result, r), Lo: b}
+		t2 := uint128.Uint128{Hi: a, Lo: c | 8}
+
+		if t1.Cmp(t2) > 0 {
+			v = result ^ t1.Div(t2).Lo
+		} else {
+			v = result ^ (a ^ b)
+		}
+	case 14:
+		t1 := uint128.Uint128{Hi: b, Lo: a}
+		t2 := uint128.Uint128{Lo: c}
+		v = result ^ t1.MulWrap(t2).Hi
+	case 15:
+		t1 := uint128.Uint128{Hi: a, Lo: c}
+		t2 := uint128.Uint128{
+			Hi: bits.RotateLeft64(result, -r),
+			Lo: b,
+		}
+		v = result ^ t1.MulWrap(t2).Hi
+	}
+	return v
+}
+
 func isqrt(n uint64) uint64 {
 	if n < 2 {
 		return n
@@ -221,15 +293,18 @@ func isqrt(n uint64) uint64 {
 
 // This function is used to hash the input using the generated scratch pad
 // NOTE: The ScratchPadV2 is completely overwritten in stage 1  and can be reused without any issues
-func XelisHashV2(input []byte, scratch_pad *ScratchPadV2) [32]byte {
+// The chunk count of the input must divide the scratch pad size, as for the 112-byte miner work,
+// other inputs panic or leave part of the scratch pad untouched: HasherV2 and ThreadPool.Hash reject them
+func XelisHashV2(input []byte, scratch_pad *ScratchPadV2) Hash {
 	// stage 1
 	scratchpad_bytes := (*[MEMORY_SIZE_V2 * 8]byte)(unsafe.Pointer(scratch_pad))
-	stage_1_v2(input, scratchpad_bytes)
+	stage_1_v2(input, scratchpad_bytes[:], CHUNK_SIZE_V2)
 
 	// stage 2 got removed as it got completely optimized on GPUs
 
 	// stage 3
-	stage_3(scratch_pad)
+	key := [16]byte([]byte(KEY))
+	stage_3_v2(scratch_pad, SCRATCHPAD_ITERS_V2, &key)
 
 	// stage 4
 	return blake3.Sum256(scratchpad_bytes[:])
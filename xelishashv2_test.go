@@ -16,7 +16,7 @@ func TestReusedScratchpad(t *testing.T) {
 	hash := XelisHashV2(input, &scratchpad)
 
 	if hash != expected_hash {
-		t.Fatalf("incorrect hash: %x, expected: %x", hash, expected_hash)
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expected_hash)
 	}
 }
 
@@ -25,14 +25,14 @@ func TestZeroHash(t *testing.T) {
 	input := make([]byte, 112)
 
 	hash := XelisHashV2(input, &scratchpad)
-	expectedHash := [32]byte{
+	expectedHash := Hash{
 		126, 219, 112, 240, 116, 133, 115, 144, 39, 40, 164,
 		105, 30, 158, 45, 126, 64, 67, 238, 52, 200, 35,
 		161, 19, 144, 211, 214, 225, 95, 190, 146, 27,
 	}
 
 	if hash != expectedHash {
-		t.Fatalf("incorrect hash: %x, expected: %x", hash, expectedHash)
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expectedHash)
 	}
 }
 
@@ -51,24 +51,24 @@ func TestVerifyOutput(t *testing.T) {
 
 	scratchpad := ScratchPadV2{}
 
-	expectedHash := [32]byte{
+	expectedHash := Hash{
 		199, 114, 154, 28, 4, 164, 196, 178, 117, 17, 148,
 		203, 125, 228, 51, 145, 162, 222, 106, 202, 205,
 		55, 244, 178, 94, 29, 248, 242, 98, 221, 158, 179,
 	}
 
-	expectedHash2 := [32]byte{86, 153, 158, 47, 177, 49, 55, 60, 155, 61, 147, 124, 179, 204, 11, 76, 59, 90, 186, 134, 9, 20, 21, 248, 156, 47, 122, 116, 118, 227, 24, 75}
+	expectedHash2 := Hash{86, 153, 158, 47, 177, 49, 55, 60, 155, 61, 147, 124, 179, 204, 11, 76, 59, 90, 186, 134, 9, 20, 21, 248, 156, 47, 122, 116, 118, 227, 24, 75}
 
 	hash := XelisHashV2(input, &scratchpad)
 	if hash != expectedHash {
-		t.Fatalf("incorrect hash: %x, expected: %x", hash, expectedHash)
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expectedHash)
 	}
 
 	hash = XelisHashV2(input2, &scratchpad)
 	if hash != expectedHash2 {
-		t.Fatalf("incorrect hash: %x, expected: %x", hash, expectedHash)
+		t.Fatalf("incorrect hash: %s, expected: %s", hash, expectedHash)
 	}
-	t.Logf("%x", hash)
+	t.Logf("%s", hash)
 }
 
 func BenchmarkHashV2(b *testing.B) {